@@ -0,0 +1,129 @@
+package bimg
+
+/*
+#cgo pkg-config: vips
+#include "vips/vips.h"
+*/
+import "C"
+
+import (
+	"errors"
+	"math"
+)
+
+// SmartCropRegion returns the left and top coordinates of the width x height
+// region that the libvips attention model considers the most interesting,
+// without cropping the image. Coordinates are relative to the image as
+// stored, without applying the EXIF orientation.
+// The region is clamped to the image size.
+func SmartCropRegion(buf []byte, width, height int) (left, top int, err error) {
+	defer C.vips_thread_shutdown()
+
+	if width <= 0 || height <= 0 {
+		return 0, 0, errors.New("Region width and height must be greater than zero")
+	}
+
+	image, _, err := vipsRead(buf)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer C.g_object_unref(C.gpointer(image))
+
+	inWidth, inHeight := int(image.Xsize), int(image.Ysize)
+	width = int(math.Min(float64(width), float64(inWidth)))
+	height = int(math.Min(float64(height), float64(inHeight)))
+
+	x, y, err := vipsSmartCropAttention(image, width, height)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Center the region on the attention point, like libvips does
+	left = clamp(x-width/2, 0, inWidth-width)
+	top = clamp(y-height/2, 0, inHeight-height)
+
+	return left, top, nil
+}
+
+// SSIM returns the structural similarity index between the given images, from
+// 0 (no similarity) to 1 (identical), measured on their luma in 8x8 windows.
+// Transparent images are flattened over black before being compared.
+// Both images must have the same dimensions.
+func SSIM(a, b []byte) (float64, error) {
+	lumaA, width, height, err := ssimLuma(a)
+	if err != nil {
+		return 0, err
+	}
+
+	lumaB, widthB, heightB, err := ssimLuma(b)
+	if err != nil {
+		return 0, err
+	}
+
+	if width != widthB || height != heightB {
+		return 0, errors.New("Images must have the same dimensions")
+	}
+
+	return ssim(lumaA, lumaB, width, height), nil
+}
+
+// ssimLuma decodes the given image into its luma values.
+func ssimLuma(buf []byte) ([]float64, int, int, error) {
+	pixels, width, height, _, err := ToRaw(buf, Options{ForceRGB: true})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	luma := make([]float64, width*height)
+	for i := range luma {
+		r, g, b := float64(pixels[i*3]), float64(pixels[i*3+1]), float64(pixels[i*3+2])
+		luma[i] = 0.299*r + 0.587*g + 0.114*b
+	}
+
+	return luma, width, height, nil
+}
+
+// ssim calculates the mean structural similarity index of the given luma
+// values, over non-overlapping 8x8 windows (clipped on the image edges).
+func ssim(a, b []float64, width, height int) float64 {
+	const window = 8
+	const c1 = (0.01 * 255) * (0.01 * 255)
+	const c2 = (0.03 * 255) * (0.03 * 255)
+
+	var sum float64
+	var windows int
+	for top := 0; top < height; top += window {
+		for left := 0; left < width; left += window {
+			var sumA, sumB, sumAA, sumBB, sumAB, n float64
+			for y := top; y < top+window && y < height; y++ {
+				for x := left; x < left+window && x < width; x++ {
+					va, vb := a[y*width+x], b[y*width+x]
+					sumA += va
+					sumB += vb
+					sumAA += va * va
+					sumBB += vb * vb
+					sumAB += va * vb
+					n++
+				}
+			}
+
+			meanA, meanB := sumA/n, sumB/n
+			varA := sumAA/n - meanA*meanA
+			varB := sumBB/n - meanB*meanB
+			covariance := sumAB/n - meanA*meanB
+
+			sum += ((2*meanA*meanB + c1) * (2*covariance + c2)) /
+				((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			windows++
+		}
+	}
+
+	if windows == 0 {
+		return 1
+	}
+	return sum / float64(windows)
+}
+
+func clamp(x, min, max int) int {
+	return int(math.Max(float64(min), math.Min(float64(x), float64(max))))
+}
@@ -0,0 +1,78 @@
+package bimg
+
+import (
+	"testing"
+)
+
+func TestSmartCropRegion(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+
+	left, top, err := SmartCropRegion(buf, 800, 600)
+	if err != nil {
+		t.Fatalf("Cannot calculate the region: %#v", err)
+	}
+
+	if left < 0 || left > 1680-800 {
+		t.Errorf("Invalid region left: %d", left)
+	}
+	if top < 0 || top > 1050-600 {
+		t.Errorf("Invalid region top: %d", top)
+	}
+}
+
+func TestSmartCropRegionLargerThanImage(t *testing.T) {
+	buf, _ := Read("fixtures/test.png")
+
+	left, top, err := SmartCropRegion(buf, 1000, 1000)
+	if err != nil {
+		t.Fatalf("Cannot calculate the region: %#v", err)
+	}
+
+	if left != 0 || top != 0 {
+		t.Errorf("Invalid region: %d,%d", left, top)
+	}
+}
+
+func TestSSIM(t *testing.T) {
+	buf := readFile("test.jpg")
+	blurred, _ := Resize(buf, Options{GaussianBlur: GaussianBlur{Sigma: 5}})
+
+	value, err := SSIM(buf, buf)
+	if err != nil {
+		t.Fatalf("Cannot compare the images: %#v", err)
+	}
+	if value < 0.999 {
+		t.Errorf("Invalid SSIM for identical images: %f", value)
+	}
+
+	value, err = SSIM(buf, blurred)
+	if err != nil {
+		t.Fatalf("Cannot compare the images: %#v", err)
+	}
+	if value >= 0.99 {
+		t.Errorf("Invalid SSIM for blurred image: %f", value)
+	}
+}
+
+func TestSSIMDimensionMismatch(t *testing.T) {
+	_, err := SSIM(readFile("test.jpg"), readFile("test.png"))
+	if err == nil || err.Error() != "Images must have the same dimensions" {
+		t.Fatalf("Expected dimension mismatch error, got: %#v", err)
+	}
+}
+
+func TestCalculateSSIM(t *testing.T) {
+	a := make([]float64, 16*16)
+	b := make([]float64, 16*16)
+	for i := range a {
+		a[i] = float64(i % 256)
+		b[i] = 255 - a[i]
+	}
+
+	if value := ssim(a, a, 16, 16); value < 0.9999 {
+		t.Errorf("Invalid SSIM for identical values: %f", value)
+	}
+	if value := ssim(a, b, 16, 16); value >= 0 {
+		t.Errorf("Invalid SSIM for inverted values: %f", value)
+	}
+}
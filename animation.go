@@ -0,0 +1,113 @@
+package bimg
+
+/*
+#cgo pkg-config: vips
+#include "vips/vips.h"
+*/
+import "C"
+
+import (
+	"errors"
+)
+
+// Frames decodes each frame of the given animated image (GIF or WebP) and
+// returns them as separate static images, in order. WebP frames are encoded
+// as WebP, while GIF frames are encoded as PNG, keeping their transparency.
+// Static images are returned as a single frame.
+func Frames(buf []byte) ([][]byte, error) {
+	defer C.vips_thread_shutdown()
+
+	imageType := DetermineImageType(buf)
+	if imageType != GIF && imageType != WEBP {
+		return nil, errors.New("Frames are only supported for GIF and WebP images")
+	}
+
+	// Load all the frames, stacked vertically
+	image, _, err := vipsReadType(buf, imageType, map[string]string{"n": "-1"})
+	if err != nil {
+		return nil, err
+	}
+	defer C.g_object_unref(C.gpointer(image))
+
+	format := imageType
+	if format == GIF {
+		format = PNG
+	}
+	o := applyDefaults(Options{Type: format}, imageType)
+
+	width, pageHeight := int(image.Xsize), vipsPageHeight(image)
+	frames := make([][]byte, 0, int(image.Ysize)/pageHeight)
+	for top := 0; top < int(image.Ysize); top += pageHeight {
+		// vipsExtract releases its input image, hence keep a reference
+		C.g_object_ref(C.gpointer(image))
+		frame, err := vipsExtract(image, 0, top, width, pageHeight)
+		if err != nil {
+			return nil, err
+		}
+
+		out, err := saveImage(frame, o)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, out)
+	}
+
+	return frames, nil
+}
+
+// shouldPreserveAnimation returns true if all the frames of the given image
+// type must be loaded and transformed, in order to preserve the animation.
+func shouldPreserveAnimation(o Options, imageType ImageType) bool {
+	return o.PreserveAnimation && !o.FirstFrame && (imageType == GIF || imageType == WEBP)
+}
+
+// transformAnimation transforms each frame of the given animated image, whose
+// frames are stacked vertically, and joins them back keeping their delays and
+// the loop count. libvips decodes every frame already composited over the
+// previous ones, according to its disposal method and transparency, hence the
+// output frames are full frames which cannot smear.
+func transformAnimation(image *C.VipsImage, buf []byte, imageType ImageType, o Options, info *ImageInfo) (*C.VipsImage, Options, error) {
+	defer C.g_object_unref(C.gpointer(image))
+
+	if (o.Type != GIF && o.Type != WEBP) || !IsTypeSupportedSave(o.Type) {
+		return nil, o, errors.New("Animated images can only be saved as GIF or WebP")
+	}
+
+	_, loop, delays, err := vipsAnimationInfo(image)
+	if err != nil {
+		return nil, o, err
+	}
+
+	width, pageHeight := int(image.Xsize), vipsPageHeight(image)
+	info.InputSize.Height = pageHeight
+
+	// The thumbnail fast path would load the first frame only
+	frameOptions := o
+	frameOptions.UseThumbnail = false
+
+	frames := make([]*C.VipsImage, 0, int(image.Ysize)/pageHeight)
+	for top := 0; top < int(image.Ysize); top += pageHeight {
+		// vipsExtract releases its input image, hence keep a reference
+		C.g_object_ref(C.gpointer(image))
+		frame, err := vipsExtract(image, 0, top, width, pageHeight)
+		if err == nil {
+			frame, o, err = processImage(frame, buf, imageType, frameOptions, info)
+		}
+		if err != nil {
+			for _, frame := range frames {
+				C.g_object_unref(C.gpointer(frame))
+			}
+			return nil, o, err
+		}
+		frames = append(frames, frame)
+	}
+
+	count := len(frames)
+	animation, err := vipsArrayJoin(frames)
+	if err != nil {
+		return nil, o, err
+	}
+
+	animation, err = vipsSetAnimation(animation, int(animation.Ysize)/count, loop, delays)
+	return animation, o, err
+}
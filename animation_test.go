@@ -0,0 +1,50 @@
+package bimg
+
+import (
+	"testing"
+)
+
+func TestFrames(t *testing.T) {
+	if !IsTypeSupported(GIF) {
+		t.Skip("GIF load is not supported by the current libvips compilation")
+	}
+
+	frames, err := Frames(animatedGIF(t))
+	if err != nil {
+		t.Fatalf("Cannot split the frames: %#v", err)
+	}
+	if len(frames) != 2 {
+		t.Fatalf("Invalid number of frames: %d", len(frames))
+	}
+
+	for i, frame := range frames {
+		if DetermineImageType(frame) != PNG {
+			t.Fatalf("Frame %d is not png", i)
+		}
+		if err := assertSize(frame, 10, 10); err != nil {
+			t.Error(err)
+		}
+	}
+
+	pixels, _, _, _, err := ToRaw(frames[1], Options{})
+	if err != nil {
+		t.Fatalf("Cannot read the frame pixels: %#v", err)
+	}
+	if pixels[0] != 0 {
+		t.Errorf("Invalid second frame color: %v", pixels[:3])
+	}
+}
+
+func TestFramesStatic(t *testing.T) {
+	frames, err := Frames(readImage("test.webp"))
+	if err != nil {
+		t.Fatalf("Cannot split the frames: %#v", err)
+	}
+	if len(frames) != 1 {
+		t.Fatalf("Invalid number of frames: %d", len(frames))
+	}
+
+	if _, err := Frames(readImage("test.jpg")); err == nil {
+		t.Fatal("Expected error for JPEG image")
+	}
+}
@@ -0,0 +1,111 @@
+package bimg
+
+/*
+#cgo pkg-config: vips
+#include "vips/vips.h"
+*/
+import "C"
+
+import (
+	"errors"
+)
+
+// MathOperation represents the pixel-wise arithmetic operation
+// to be applied between two images.
+type MathOperation int
+
+const (
+	// MathAdd adds the right image pixels to the left image pixels.
+	MathAdd MathOperation = iota
+	// MathSubtract subtracts the right image pixels from the left image pixels.
+	MathSubtract
+	// MathMultiply multiplies the left image pixels by the right image pixels.
+	MathMultiply
+	// MathDivide divides the left image pixels by the right image pixels.
+	MathDivide
+	// MathAverage averages the left and right image pixels.
+	MathAverage
+	// MathDifference calculates the absolute difference between the left and right image pixels.
+	MathDifference
+)
+
+var mathOperations = map[MathOperation]string{
+	MathAdd:        "add",
+	MathSubtract:   "subtract",
+	MathMultiply:   "multiply",
+	MathDivide:     "divide",
+	MathAverage:    "average",
+	MathDifference: "difference",
+}
+
+func (m MathOperation) String() string {
+	return mathOperations[m]
+}
+
+// ImageMath applies the given pixel-wise arithmetic operation between two
+// images of the same size, returning the resultant image encoded in the left
+// image format. Both images must have the same number of bands, or one of them
+// must have a single band, which is then applied to every band of the other.
+// Resultant values are clipped to the 0-255 range.
+func ImageMath(left, right []byte, operation MathOperation) ([]byte, error) {
+	defer C.vips_thread_shutdown()
+
+	if _, ok := mathOperations[operation]; !ok {
+		return nil, errors.New("Unsupported math operation")
+	}
+
+	leftImage, imageType, err := vipsRead(left)
+	if err != nil {
+		return nil, err
+	}
+
+	rightImage, _, err := vipsRead(right)
+	if err != nil {
+		C.g_object_unref(C.gpointer(leftImage))
+		return nil, err
+	}
+
+	if leftImage.Xsize != rightImage.Xsize || leftImage.Ysize != rightImage.Ysize {
+		C.g_object_unref(C.gpointer(leftImage))
+		C.g_object_unref(C.gpointer(rightImage))
+		return nil, errors.New("Images must have the same dimensions")
+	}
+
+	image, err := vipsMath(leftImage, rightImage, operation)
+	if err != nil {
+		return nil, err
+	}
+
+	image, err = vipsCast(image, C.VIPS_FORMAT_UCHAR)
+	if err != nil {
+		return nil, err
+	}
+
+	o := applyDefaults(Options{}, imageType)
+	return saveImage(image, o)
+}
+
+func vipsMath(left, right *C.VipsImage, operation MathOperation) (*C.VipsImage, error) {
+	switch operation {
+	case MathSubtract:
+		return vipsSubtract(left, right)
+	case MathMultiply:
+		return vipsMultiply(left, right)
+	case MathDivide:
+		return vipsDivide(left, right)
+	case MathAverage:
+		image, err := vipsAdd(left, right)
+		if err != nil {
+			return nil, err
+		}
+		return vipsLinear1(image, 0.5, 0)
+	case MathDifference:
+		image, err := vipsSubtract(left, right)
+		if err != nil {
+			return nil, err
+		}
+		return vipsAbs(image)
+	default:
+		return vipsAdd(left, right)
+	}
+}
@@ -0,0 +1,61 @@
+package bimg
+
+import (
+	"testing"
+)
+
+func TestImageMath(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+
+	for operation := range mathOperations {
+		newImg, err := ImageMath(buf, buf, operation)
+		if err != nil {
+			t.Fatalf("Cannot apply the %s operation: %#v", operation, err)
+		}
+
+		if err := assertSize(newImg, 1680, 1050); err != nil {
+			t.Error(err)
+		}
+		if DetermineImageType(newImg) != JPEG {
+			t.Fatalf("Invalid image type for the %s operation", operation)
+		}
+	}
+}
+
+func TestImageMathDifference(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+
+	newImg, err := ImageMath(buf, buf, MathDifference)
+	if err != nil {
+		t.Fatalf("Cannot apply the difference operation: %#v", err)
+	}
+
+	pixels, _, _, _, err := ToRaw(newImg, Options{})
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+
+	for _, value := range pixels {
+		if value > 8 {
+			t.Fatalf("Expected a black image, got pixel value %d", value)
+		}
+	}
+}
+
+func TestImageMathDimensionMismatch(t *testing.T) {
+	left, _ := Read("fixtures/test.jpg")
+	right, _ := Read("fixtures/test.png")
+
+	_, err := ImageMath(left, right, MathAdd)
+	if err == nil || err.Error() != "Images must have the same dimensions" {
+		t.Fatalf("Expected dimension mismatch error, got: %#v", err)
+	}
+}
+
+func TestImageMathInvalidOperation(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+
+	if _, err := ImageMath(buf, buf, MathOperation(100)); err == nil {
+		t.Fatal("Expected error for unsupported math operation")
+	}
+}
@@ -0,0 +1,186 @@
+package bimg
+
+/*
+#cgo pkg-config: vips
+#include "vips/vips.h"
+*/
+import "C"
+
+import (
+	"errors"
+)
+
+// NewImageColor creates a new solid color image of the given size,
+// encoded in the given image format.
+func NewImageColor(width, height int, color Color, format ImageType) ([]byte, error) {
+	background := []float64{float64(color.R), float64(color.G), float64(color.B)}
+	return newCanvas(width, height, background, format)
+}
+
+// NewImageColorAlpha creates a new solid color image of the given size with an
+// alpha channel, encoded in the given image format. Use an alpha value of 0 to
+// create a fully transparent canvas.
+// The image format must support transparency (png, webp or tiff).
+func NewImageColorAlpha(width, height int, color ColorAlpha, format ImageType) ([]byte, error) {
+	if !typeSupportsAlpha(format) {
+		return nil, errors.New("Image output type does not support alpha channel")
+	}
+
+	background := []float64{float64(color.R), float64(color.G), float64(color.B), float64(color.A)}
+	return newCanvas(width, height, background, format)
+}
+
+func newCanvas(width, height int, background []float64, format ImageType) ([]byte, error) {
+	defer C.vips_thread_shutdown()
+
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("Canvas width and height must be greater than zero")
+	}
+	if width > MaxSize || height > MaxSize {
+		return nil, errors.New("Maximum image size exceeded")
+	}
+	if !IsTypeSupportedSave(format) {
+		return nil, errors.New("Unsupported image output type")
+	}
+
+	image, err := vipsCanvas(width, height, background)
+	if err != nil {
+		return nil, err
+	}
+
+	o := applyDefaults(Options{Type: format}, format)
+	return saveImage(image, o)
+}
+
+// Tile creates a new image of the given size by repeating the given image
+// buffer as a tile, starting from the top-left corner.
+func Tile(buf []byte, width, height int) ([]byte, error) {
+	return TileWithOffset(buf, width, height, 0, 0)
+}
+
+// TileWithOffset creates a new image of the given size by repeating the given
+// image buffer as a tile, shifting the tiling phase by the given left and top
+// offsets in pixels.
+func TileWithOffset(buf []byte, width, height, left, top int) ([]byte, error) {
+	defer C.vips_thread_shutdown()
+
+	if len(buf) == 0 {
+		return nil, errors.New("Image buffer is empty")
+	}
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("Tile width and height must be greater than zero")
+	}
+
+	image, imageType, err := vipsRead(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	tileWidth, tileHeight := int(image.Xsize), int(image.Ysize)
+
+	// Normalize the offsets to be within a single tile
+	left = ((left % tileWidth) + tileWidth) % tileWidth
+	top = ((top % tileHeight) + tileHeight) % tileHeight
+
+	across := (left+width)/tileWidth + 1
+	down := (top+height)/tileHeight + 1
+
+	image, err = vipsReplicate(image, across, down)
+	if err != nil {
+		return nil, err
+	}
+
+	image, err = vipsExtract(image, left, top, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	o := applyDefaults(Options{}, imageType)
+	return saveImage(image, o)
+}
+
+// Place positions the given image within a canvas of the given size, at the
+// position defined by the given gravity (centre, one of the four edges or one
+// of the four corners), filling the rest of the canvas with the background
+// color. The image is rotated according to its EXIF orientation first, and
+// cropped if it's larger than the canvas. The resultant image is encoded in
+// the same image format, or as JPEG if the image format cannot be saved.
+// Requires libvips 8.6+.
+func Place(buf []byte, width, height int, gravity Gravity, background Color) ([]byte, error) {
+	defer C.vips_thread_shutdown()
+
+	if len(buf) == 0 {
+		return nil, errors.New("Image buffer is empty")
+	}
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("Canvas width and height must be greater than zero")
+	}
+	if width > MaxSize || height > MaxSize {
+		return nil, errors.New("Maximum image size exceeded")
+	}
+	if gravity == GravitySmart {
+		return nil, errors.New("Smart gravity is not supported to place images")
+	}
+
+	image, imageType, err := vipsRead(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	image, _, err = rotateAndFlipImage(image, Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	image, err = vipsGravity(image, gravity, width, height, background)
+	if err != nil {
+		return nil, err
+	}
+
+	// The orientation is already applied to the pixels
+	vipsRemoveOrientation(image)
+
+	format := imageType
+	if !IsTypeSupportedSave(format) {
+		format = JPEG
+	}
+
+	o := applyDefaults(Options{Type: format}, imageType)
+	return saveImage(image, o)
+}
+
+// CheckerboardBackground composites the given image over a checkerboard
+// pattern of squares of the given size, alternating the c1 and c2 colors,
+// which is the usual way to preview the image transparency.
+// The resultant image is opaque, with no alpha channel, and encoded in the
+// same image format, or as JPEG if the image format cannot be saved.
+func CheckerboardBackground(buf []byte, size int, c1, c2 Color) ([]byte, error) {
+	defer C.vips_thread_shutdown()
+
+	if len(buf) == 0 {
+		return nil, errors.New("Image buffer is empty")
+	}
+	if size <= 0 {
+		return nil, errors.New("Checkerboard size must be greater than zero")
+	}
+
+	image, imageType, err := vipsRead(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if vipsHasAlpha(image) {
+		image, err = vipsCheckerboard(image, size, c1, c2)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	format := imageType
+	if !IsTypeSupportedSave(format) {
+		format = JPEG
+	}
+
+	o := applyDefaults(Options{Type: format}, imageType)
+	return saveImage(image, o)
+}
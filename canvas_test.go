@@ -0,0 +1,194 @@
+package bimg
+
+import (
+	"testing"
+)
+
+func TestNewImageColor(t *testing.T) {
+	buf, err := NewImageColor(300, 200, Color{255, 255, 255}, JPEG)
+	if err != nil {
+		t.Fatalf("Cannot create the image: %#v", err)
+	}
+
+	if DetermineImageType(buf) != JPEG {
+		t.Fatal("Image is not jpeg")
+	}
+
+	err = assertSize(buf, 300, 200)
+	if err != nil {
+		t.Error(err)
+	}
+
+	Write("fixtures/test_canvas_out.jpg", buf)
+}
+
+func TestNewImageColorAlpha(t *testing.T) {
+	buf, err := NewImageColorAlpha(300, 200, ColorAlpha{255, 0, 0, 0}, PNG)
+	if err != nil {
+		t.Fatalf("Cannot create the image: %#v", err)
+	}
+
+	metadata, err := Metadata(buf)
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+	if metadata.Alpha != true {
+		t.Fatal("Invalid alpha channel")
+	}
+	if metadata.Size.Width != 300 || metadata.Size.Height != 200 {
+		t.Fatalf("Invalid image size: %dx%d", metadata.Size.Width, metadata.Size.Height)
+	}
+
+	Write("fixtures/test_canvas_alpha_out.png", buf)
+}
+
+func TestNewImageColorAlphaUnsupportedType(t *testing.T) {
+	_, err := NewImageColorAlpha(300, 200, ColorAlpha{255, 0, 0, 0}, JPEG)
+	if err == nil {
+		t.Fatal("Expected error for jpeg output with alpha channel")
+	}
+}
+
+func TestTile(t *testing.T) {
+	buf, _ := Read("fixtures/test.png")
+
+	newImg, err := Tile(buf, 1000, 700)
+	if err != nil {
+		t.Fatalf("Cannot tile the image: %#v", err)
+	}
+
+	if DetermineImageType(newImg) != PNG {
+		t.Fatal("Image is not png")
+	}
+
+	err = assertSize(newImg, 1000, 700)
+	if err != nil {
+		t.Error(err)
+	}
+
+	Write("fixtures/test_tile_out.png", newImg)
+}
+
+func TestTileWithOffset(t *testing.T) {
+	buf, _ := Read("fixtures/test.png")
+
+	newImg, err := TileWithOffset(buf, 1000, 700, 150, -50)
+	if err != nil {
+		t.Fatalf("Cannot tile the image: %#v", err)
+	}
+
+	err = assertSize(newImg, 1000, 700)
+	if err != nil {
+		t.Error(err)
+	}
+
+	Write("fixtures/test_tile_offset_out.png", newImg)
+}
+
+func TestPlace(t *testing.T) {
+	square, _ := NewImageColor(100, 100, Color{255, 0, 0}, PNG)
+	tests := []struct {
+		gravity Gravity
+		x, y    int
+	}{
+		{GravityCentre, 150, 100},
+		{GravityNorth, 150, 50},
+		{GravityEast, 250, 100},
+		{GravitySouth, 150, 150},
+		{GravityWest, 50, 100},
+		{GravityNorthEast, 250, 50},
+		{GravitySouthEast, 250, 150},
+		{GravitySouthWest, 50, 150},
+		{GravityNorthWest, 50, 50},
+	}
+
+	for _, test := range tests {
+		buf, err := Place(square, 300, 200, test.gravity, Color{0, 0, 255})
+		if err != nil {
+			t.Fatalf("Cannot place the image: %#v", err)
+		}
+
+		pixels, width, height, bands, err := ToRaw(buf, Options{})
+		if err != nil {
+			t.Fatalf("Cannot read the image: %#v", err)
+		}
+		if width != 300 || height != 200 {
+			t.Fatalf("Invalid image size: %dx%d", width, height)
+		}
+
+		// The square is centred on the expected point, over the background
+		inside := (test.y*width + test.x) * bands
+		if pixels[inside] != 255 || pixels[inside+2] != 0 {
+			t.Errorf("Invalid image pixel for gravity %d: %v", test.gravity, pixels[inside:inside+3])
+		}
+		outside := ((200-test.y)*width + 300 - test.x) * bands
+		if test.gravity != GravityCentre && (pixels[outside] != 0 || pixels[outside+2] != 255) {
+			t.Errorf("Invalid background pixel for gravity %d: %v", test.gravity, pixels[outside:outside+3])
+		}
+	}
+
+	if _, err := Place(square, 300, 200, GravitySmart, ColorBlack); err == nil {
+		t.Error("Expected error for smart gravity")
+	}
+}
+
+func TestPlaceAutoRotate(t *testing.T) {
+	// 1680x1050 image with the EXIF orientation 6 (rotated by 90 degrees)
+	buf, err := Place(readFile("exif_orientation_6.jpg"), 1050, 1680, GravityNorthWest, Color{0, 0, 255})
+	if err != nil {
+		t.Fatalf("Cannot place the image: %#v", err)
+	}
+
+	// The rotated image covers the whole canvas
+	pixels, width, _, bands, err := ToRaw(buf, Options{})
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+	bottom := (1600*width + 500) * bands
+	if pixels[bottom] < 50 && pixels[bottom+1] < 50 && pixels[bottom+2] > 200 {
+		t.Errorf("Expected the image to be rotated, found the background: %v", pixels[bottom:bottom+3])
+	}
+
+	metadata, err := Metadata(buf)
+	if err != nil {
+		t.Fatalf("Cannot read the metadata: %#v", err)
+	}
+	if metadata.Orientation != 0 {
+		t.Errorf("Expected the orientation to be removed: %d", metadata.Orientation)
+	}
+}
+
+func TestCheckerboardBackground(t *testing.T) {
+	buf, _ := Read("fixtures/transparent.png")
+
+	newImg, err := CheckerboardBackground(buf, 8, Color{255, 255, 255}, Color{204, 204, 204})
+	if err != nil {
+		t.Fatalf("Cannot create the image: %#v", err)
+	}
+
+	metadata, err := Metadata(newImg)
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+	if metadata.Alpha {
+		t.Fatal("Expected an opaque image")
+	}
+	if metadata.Type != "png" {
+		t.Fatalf("Invalid image type: %s", metadata.Type)
+	}
+
+	original, _ := Size(buf)
+	if metadata.Size != original {
+		t.Fatalf("Invalid image size: %dx%d", metadata.Size.Width, metadata.Size.Height)
+	}
+
+	Write("fixtures/test_checkerboard_out.png", newImg)
+}
+
+func TestCheckerboardBackgroundInvalidSize(t *testing.T) {
+	buf, _ := Read("fixtures/transparent.png")
+
+	if _, err := CheckerboardBackground(buf, 0, Color{255, 255, 255}, Color{204, 204, 204}); err == nil {
+		t.Fatal("Expected error for invalid checkerboard size")
+	}
+}
@@ -0,0 +1,104 @@
+package bimg
+
+/*
+#cgo pkg-config: vips
+#include "vips/vips.h"
+*/
+import "C"
+
+import (
+	"errors"
+)
+
+// ReplaceColor paints with the replacement color the image pixels matching
+// the target color, within the given tolerance: the maximum euclidean distance
+// between both sRGB colors (e.g: 0 only matches the exact color, while 30
+// matches the slightly different shades too). The alpha channel, if any, is
+// kept as it is. The resultant image is encoded in the same image format, or
+// as JPEG if the image format cannot be saved.
+func ReplaceColor(buf []byte, target, replacement Color, tolerance float64) ([]byte, error) {
+	defer C.vips_thread_shutdown()
+
+	if tolerance < 0 {
+		return nil, errors.New("Color tolerance must be positive")
+	}
+
+	image, imageType, err := vipsRead(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	image, err = vipsReplaceColor(image, target, replacement, tolerance, false)
+	if err != nil {
+		return nil, err
+	}
+
+	format := imageType
+	if !IsTypeSupportedSave(format) {
+		format = JPEG
+	}
+
+	o := applyDefaults(Options{Type: format}, imageType)
+	return saveImage(image, o)
+}
+
+// ChromaKey makes transparent the image pixels matching the target color
+// (e.g: a solid green or white backdrop), within the given tolerance, like
+// ReplaceColor. The resultant image is encoded in the given image format, or
+// in the same image format if not defined, which must support transparency
+// (png, webp or tiff): an error is returned otherwise, e.g: for JPEG images.
+func ChromaKey(buf []byte, target Color, tolerance float64, format ImageType) ([]byte, error) {
+	defer C.vips_thread_shutdown()
+
+	if tolerance < 0 {
+		return nil, errors.New("Color tolerance must be positive")
+	}
+
+	image, imageType, err := vipsRead(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == UNKNOWN {
+		format = imageType
+	}
+	if !typeSupportsAlpha(format) {
+		C.g_object_unref(C.gpointer(image))
+		return nil, errors.New("Image output type does not support alpha channel")
+	}
+
+	image, err = vipsReplaceColor(image, target, Color{}, tolerance, true)
+	if err != nil {
+		return nil, err
+	}
+
+	o := applyDefaults(Options{Type: format}, imageType)
+	return saveImage(image, o)
+}
+
+// AdjustColor adjusts the lightness, chroma and hue of the given image in the
+// LCh color space, as defined by the given ColorAdjust, and converts it back
+// into sRGB. The alpha channel, if any, is kept as it is. The resultant image
+// is encoded in the same image format, or as JPEG if the image format cannot
+// be saved.
+func AdjustColor(buf []byte, a ColorAdjust) ([]byte, error) {
+	defer C.vips_thread_shutdown()
+
+	image, imageType, err := vipsRead(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	image, err = vipsColorAdjust(image, a)
+	if err != nil {
+		return nil, err
+	}
+
+	format := imageType
+	if !IsTypeSupportedSave(format) {
+		format = JPEG
+	}
+
+	o := applyDefaults(Options{Type: format}, imageType)
+	return saveImage(image, o)
+}
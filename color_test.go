@@ -0,0 +1,124 @@
+package bimg
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReplaceColor(t *testing.T) {
+	pixels := append(bytes.Repeat([]byte{0, 255, 0}, 50), bytes.Repeat([]byte{200, 10, 10}, 50)...)
+	image, err := NewImageFromRaw(pixels, 10, 10, 3)
+	if err != nil {
+		t.Fatalf("Cannot create the image: %#v", err)
+	}
+
+	buf, err := ReplaceColor(image.Image(), Color{10, 250, 10}, Color{255, 255, 255}, 20)
+	if err != nil {
+		t.Fatalf("Cannot replace the color: %#v", err)
+	}
+
+	raw, _, _, bands, err := ToRaw(buf, Options{})
+	if err != nil {
+		t.Fatalf("Cannot read the image pixels: %#v", err)
+	}
+	if !bytes.Equal(raw[:3], []byte{255, 255, 255}) {
+		t.Errorf("Invalid replaced color: %v", raw[:3])
+	}
+	if last := raw[len(raw)-bands:]; !bytes.Equal(last[:3], []byte{200, 10, 10}) {
+		t.Errorf("Invalid unmatched color: %v", last[:3])
+	}
+
+	if _, err := ReplaceColor(image.Image(), Color{}, Color{}, -1); err == nil {
+		t.Fatal("Expected error for negative tolerance")
+	}
+}
+
+func TestChromaKey(t *testing.T) {
+	pixels := append(bytes.Repeat([]byte{0, 255, 0}, 50), bytes.Repeat([]byte{200, 10, 10}, 50)...)
+	image, err := NewImageFromRaw(pixels, 10, 10, 3)
+	if err != nil {
+		t.Fatalf("Cannot create the image: %#v", err)
+	}
+
+	buf, err := ChromaKey(image.Image(), Color{0, 255, 0}, 10, PNG)
+	if err != nil {
+		t.Fatalf("Cannot key the color: %#v", err)
+	}
+
+	raw, _, _, bands, err := ToRaw(buf, Options{})
+	if err != nil {
+		t.Fatalf("Cannot read the image pixels: %#v", err)
+	}
+	if bands != 4 {
+		t.Fatalf("Invalid number of bands: %d", bands)
+	}
+	if raw[3] != 0 {
+		t.Errorf("Expected a transparent pixel: %v", raw[:4])
+	}
+	if raw[len(raw)-1] != 255 {
+		t.Errorf("Expected an opaque pixel: %v", raw[len(raw)-4:])
+	}
+
+	if _, err := ChromaKey(readImage("test.jpg"), Color{0, 255, 0}, 10, JPEG); err == nil {
+		t.Fatal("Expected error for JPEG output")
+	}
+	if _, err := ChromaKey(readImage("test.jpg"), Color{0, 255, 0}, 10, UNKNOWN); err == nil {
+		t.Fatal("Expected error for JPEG output")
+	}
+}
+
+func TestAdjustColor(t *testing.T) {
+	image, err := NewImageFromRaw(bytes.Repeat([]byte{200, 10, 10}, 100), 10, 10, 3)
+	if err != nil {
+		t.Fatalf("Cannot create the image: %#v", err)
+	}
+
+	buf, err := AdjustColor(image.Image(), ColorAdjust{Chroma: -1})
+	if err != nil {
+		t.Fatalf("Cannot adjust the color: %#v", err)
+	}
+
+	raw, _, _, _, err := ToRaw(buf, Options{})
+	if err != nil {
+		t.Fatalf("Cannot read the image pixels: %#v", err)
+	}
+	if byteDiff(raw[0], raw[1]) > 2 || byteDiff(raw[1], raw[2]) > 2 {
+		t.Errorf("Expected a gray pixel: %v", raw[:3])
+	}
+
+	buf, err = AdjustColor(image.Image(), ColorAdjust{Lightness: 100})
+	if err != nil {
+		t.Fatalf("Cannot adjust the color: %#v", err)
+	}
+	if raw, _, _, _, _ := ToRaw(buf, Options{}); raw[1] < 200 || raw[2] < 200 {
+		t.Errorf("Expected a lighter pixel: %v", raw[:3])
+	}
+
+	if _, err := AdjustColor(image.Image(), ColorAdjust{Chroma: -2}); err == nil {
+		t.Fatal("Expected error for invalid chroma adjustment")
+	}
+}
+
+func TestResizeColorAdjust(t *testing.T) {
+	buf, err := Resize(readFile("test.jpg"), Options{Width: 300, ColorAdjust: ColorAdjust{Chroma: -1}})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+
+	raw, _, _, bands, err := ToRaw(buf, Options{})
+	if err != nil {
+		t.Fatalf("Cannot read the image pixels: %#v", err)
+	}
+	for i := 0; i < len(raw); i += bands * 997 {
+		if byteDiff(raw[i], raw[i+1]) > 3 || byteDiff(raw[i+1], raw[i+2]) > 3 {
+			t.Fatalf("Expected a gray pixel: %v", raw[i:i+3])
+		}
+	}
+}
+
+func byteDiff(a, b byte) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
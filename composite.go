@@ -0,0 +1,217 @@
+package bimg
+
+/*
+#cgo pkg-config: vips
+#include "vips/vips.h"
+*/
+import "C"
+
+import (
+	"errors"
+)
+
+// MaskComposite composites the overlay image over the base image using the
+// given mask image as the condition: white mask pixels select the overlay,
+// black mask pixels keep the base. When blend is true, the gray levels of the
+// mask are used to smoothly mix both images, otherwise any non-black mask pixel
+// selects the overlay.
+//
+// All the images must have the same size. The mask is converted to a single
+// band (grayscale, with no alpha channel) and broadcast across all the image
+// bands. The resultant image is encoded in the base image format.
+func MaskComposite(base, overlay, mask []byte, blend bool) ([]byte, error) {
+	defer C.vips_thread_shutdown()
+
+	baseImage, imageType, err := vipsRead(base)
+	if err != nil {
+		return nil, err
+	}
+
+	overlayImage, _, err := vipsRead(overlay)
+	if err != nil {
+		C.g_object_unref(C.gpointer(baseImage))
+		return nil, err
+	}
+
+	maskImage, _, err := vipsRead(mask)
+	if err != nil {
+		C.g_object_unref(C.gpointer(baseImage))
+		C.g_object_unref(C.gpointer(overlayImage))
+		return nil, err
+	}
+
+	if baseImage.Xsize != overlayImage.Xsize || baseImage.Ysize != overlayImage.Ysize ||
+		baseImage.Xsize != maskImage.Xsize || baseImage.Ysize != maskImage.Ysize {
+		C.g_object_unref(C.gpointer(baseImage))
+		C.g_object_unref(C.gpointer(overlayImage))
+		C.g_object_unref(C.gpointer(maskImage))
+		return nil, errors.New("Images must have the same dimensions")
+	}
+
+	if maskImage.Bands > 1 {
+		maskImage, err = vipsMaskBand(maskImage)
+		if err != nil {
+			C.g_object_unref(C.gpointer(baseImage))
+			C.g_object_unref(C.gpointer(overlayImage))
+			return nil, err
+		}
+	}
+
+	image, err := vipsIthenelse(maskImage, overlayImage, baseImage, blend)
+	if err != nil {
+		return nil, err
+	}
+
+	o := applyDefaults(Options{}, imageType)
+	return saveImage(image, o)
+}
+
+// FlattenOver composites the given overlay image, usually with transparency,
+// over the given background image, instead of a solid color. The background
+// is resized to cover the overlay image, cropping its centre, so the resultant
+// image keeps the overlay size. It's opaque unless the background itself has
+// an alpha channel, and encoded in the overlay image format, or as JPEG if the
+// image format cannot be saved. Requires libvips 8.6+.
+func FlattenOver(overlay, background []byte) ([]byte, error) {
+	defer C.vips_thread_shutdown()
+
+	overlayImage, imageType, err := vipsRead(overlay)
+	if err != nil {
+		return nil, err
+	}
+
+	backgroundImage, _, err := vipsRead(background)
+	if err != nil {
+		C.g_object_unref(C.gpointer(overlayImage))
+		return nil, err
+	}
+
+	image, err := vipsFlattenOver(overlayImage, backgroundImage)
+	if err != nil {
+		return nil, err
+	}
+
+	format := imageType
+	if !IsTypeSupportedSave(format) {
+		format = JPEG
+	}
+
+	o := applyDefaults(Options{Type: format}, imageType)
+	return saveImage(image, o)
+}
+
+// Compose composites the given image layers from bottom to top, in a single
+// pipeline, so the layers are only decoded once and the resultant image is
+// only encoded once. The first layer is the bottom one and defines the image
+// size, its position and blend mode are ignored. Each layer above is placed
+// at its left and top coordinates, faded by its opacity (between 0 and 1, or
+// 0 to keep the layer opacity unchanged) and blended with the layers below
+// using its blend mode. The resultant image is opaque unless the bottom layer
+// has an alpha channel, and encoded in the bottom layer image format, or as
+// JPEG if the image format cannot be saved. Requires libvips 8.6+.
+func Compose(layers []Layer) ([]byte, error) {
+	defer C.vips_thread_shutdown()
+
+	if len(layers) == 0 {
+		return nil, errors.New("No layers to compose")
+	}
+	for _, layer := range layers {
+		if layer.Opacity < 0 || layer.Opacity > 1 {
+			return nil, errors.New("Layer opacity must be between 0 and 1")
+		}
+	}
+
+	image, imageType, err := vipsRead(layers[0].Buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	if layers[0].Opacity > 0 && layers[0].Opacity < 1 {
+		image, err = vipsOpacity(image, layers[0].Opacity)
+		if err != nil {
+			return nil, err
+		}
+	}
+	opaque := !vipsHasAlpha(image)
+
+	for _, layer := range layers[1:] {
+		overlay, _, err := vipsRead(layer.Buffer)
+		if err != nil {
+			C.g_object_unref(C.gpointer(image))
+			return nil, err
+		}
+
+		if layer.Opacity > 0 && layer.Opacity < 1 {
+			overlay, err = vipsOpacity(overlay, layer.Opacity)
+			if err != nil {
+				C.g_object_unref(C.gpointer(image))
+				return nil, err
+			}
+		}
+
+		blend := layer.Blend
+		if blend == 0 {
+			blend = BlendOver
+		}
+
+		image, err = vipsComposite2(image, overlay, blend, layer.Left, layer.Top)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Composited images always have an alpha channel
+	if opaque && len(layers) > 1 {
+		image, err = vipsExtractBand(image, 0, 3)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	format := imageType
+	if !IsTypeSupportedSave(format) {
+		format = JPEG
+	}
+
+	o := applyDefaults(Options{Type: format}, imageType)
+	return saveImage(image, o)
+}
+
+// SetOpacity scales the opacity of the given image by the given factor (from
+// 0 for fully transparent to 1 for unchanged), multiplying its alpha channel.
+// Images with no alpha channel get a uniform one at the given opacity. The
+// resultant image is encoded in the same image format, or as PNG if the
+// image format doesn't support transparency.
+func SetOpacity(buf []byte, factor float64) ([]byte, error) {
+	defer C.vips_thread_shutdown()
+
+	if factor < 0 || factor > 1 {
+		return nil, errors.New("Opacity factor must be between 0 and 1")
+	}
+
+	image, imageType, err := vipsRead(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	image, err = vipsOpacity(image, factor)
+	if err != nil {
+		return nil, err
+	}
+
+	format := imageType
+	if !IsTypeSupportedSave(format) || !typeSupportsAlpha(format) {
+		format = PNG
+	}
+
+	o := applyDefaults(Options{Type: format}, imageType)
+	return saveImage(image, o)
+}
+
+func vipsMaskBand(image *C.VipsImage) (*C.VipsImage, error) {
+	image, err := vipsColourspace(image, InterpretationBW)
+	if err != nil {
+		return nil, err
+	}
+	return vipsExtractBand(image, 0, 1)
+}
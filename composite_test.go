@@ -0,0 +1,208 @@
+package bimg
+
+import (
+	"testing"
+)
+
+func TestMaskComposite(t *testing.T) {
+	base, _ := NewImageColor(300, 200, Color{255, 0, 0}, PNG)
+	overlay, _ := NewImageColor(300, 200, Color{0, 0, 255}, PNG)
+
+	tests := []struct {
+		mask  Color
+		blend bool
+		pixel []byte
+	}{
+		{Color{255, 255, 255}, false, []byte{0, 0, 255}},
+		{Color{0, 0, 0}, false, []byte{255, 0, 0}},
+		{Color{128, 128, 128}, false, []byte{0, 0, 255}},
+		{Color{255, 255, 255}, true, []byte{0, 0, 255}},
+		{Color{0, 0, 0}, true, []byte{255, 0, 0}},
+	}
+
+	for _, test := range tests {
+		mask, _ := NewImageColor(300, 200, test.mask, PNG)
+
+		buf, err := MaskComposite(base, overlay, mask, test.blend)
+		if err != nil {
+			t.Fatalf("Cannot composite the image: %#v", err)
+		}
+
+		if DetermineImageType(buf) != PNG {
+			t.Fatal("Image is not png")
+		}
+
+		pixels, width, height, _, err := ToRaw(buf, Options{})
+		if err != nil {
+			t.Fatalf("Cannot read the image: %#v", err)
+		}
+		if width != 300 || height != 200 {
+			t.Fatalf("Invalid image size: %dx%d", width, height)
+		}
+		for i, value := range test.pixel {
+			if pixels[i] != value {
+				t.Fatalf("Invalid pixel for mask %#v: %v", test.mask, pixels[:3])
+			}
+		}
+	}
+}
+
+func TestMaskCompositeBlend(t *testing.T) {
+	base, _ := NewImageColor(300, 200, Color{0, 0, 0}, PNG)
+	overlay, _ := NewImageColor(300, 200, Color{255, 255, 255}, PNG)
+	mask, _ := NewImageColor(300, 200, Color{128, 128, 128}, PNG)
+
+	buf, err := MaskComposite(base, overlay, mask, true)
+	if err != nil {
+		t.Fatalf("Cannot composite the image: %#v", err)
+	}
+
+	pixels, _, _, _, err := ToRaw(buf, Options{})
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+	if pixels[0] < 120 || pixels[0] > 136 {
+		t.Fatalf("Invalid blended pixel value: %d", pixels[0])
+	}
+}
+
+func TestMaskCompositeDimensionMismatch(t *testing.T) {
+	base, _ := Read("fixtures/test.jpg")
+	overlay, _ := Read("fixtures/test.jpg")
+	mask, _ := NewImageColor(300, 200, Color{255, 255, 255}, PNG)
+
+	_, err := MaskComposite(base, overlay, mask, false)
+	if err == nil || err.Error() != "Images must have the same dimensions" {
+		t.Fatalf("Expected dimension mismatch error, got: %#v", err)
+	}
+}
+
+func TestFlattenOver(t *testing.T) {
+	overlay, _ := NewImageColorAlpha(300, 200, ColorAlpha{0, 0, 255, 0}, PNG)
+	background, _ := NewImageColor(600, 300, Color{255, 0, 0}, JPEG)
+
+	buf, err := FlattenOver(overlay, background)
+	if err != nil {
+		t.Fatalf("Cannot flatten the image: %#v", err)
+	}
+
+	pixels, width, height, bands, err := ToRaw(buf, Options{})
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+	if width != 300 || height != 200 {
+		t.Fatalf("Invalid image size: %dx%d", width, height)
+	}
+	if bands != 3 {
+		t.Fatalf("Invalid number of bands: %d", bands)
+	}
+	if pixels[0] < 250 || pixels[1] > 5 || pixels[2] > 5 {
+		t.Fatalf("Invalid background pixel: %v", pixels[:3])
+	}
+
+	opaque, _ := NewImageColor(300, 200, Color{0, 0, 255}, PNG)
+	buf, err = FlattenOver(opaque, background)
+	if err != nil {
+		t.Fatalf("Cannot flatten the image: %#v", err)
+	}
+	if pixels, _, _, _, _ := ToRaw(buf, Options{}); pixels[2] < 250 {
+		t.Fatalf("Invalid overlay pixel: %v", pixels[:3])
+	}
+}
+
+func TestSetOpacity(t *testing.T) {
+	opaque, _ := NewImageColor(300, 200, Color{255, 0, 0}, JPEG)
+	transparent, _ := NewImageColorAlpha(300, 200, ColorAlpha{255, 0, 0, 200}, PNG)
+
+	tests := []struct {
+		buf   []byte
+		alpha byte
+	}{
+		{opaque, 127},
+		{transparent, 100},
+	}
+
+	for _, test := range tests {
+		buf, err := SetOpacity(test.buf, 0.5)
+		if err != nil {
+			t.Fatalf("Cannot set the image opacity: %#v", err)
+		}
+
+		if DetermineImageType(buf) != PNG {
+			t.Fatal("Image is not png")
+		}
+
+		pixels, _, _, bands, err := ToRaw(buf, Options{})
+		if err != nil {
+			t.Fatalf("Cannot read the image: %#v", err)
+		}
+		if bands != 4 {
+			t.Fatalf("Invalid number of bands: %d", bands)
+		}
+		if byteDiff(pixels[3], test.alpha) > 1 {
+			t.Fatalf("Invalid alpha value: %d != %d", pixels[3], test.alpha)
+		}
+	}
+}
+
+func TestSetOpacityInvalidFactor(t *testing.T) {
+	_, err := SetOpacity(readFile("test.png"), 1.5)
+	if err == nil {
+		t.Fatal("Expected error for invalid opacity factor")
+	}
+}
+
+func TestCompose(t *testing.T) {
+	background, _ := NewImageColor(300, 200, Color{255, 0, 0}, JPEG)
+	square, _ := NewImageColor(100, 100, Color{0, 0, 255}, PNG)
+	white, _ := NewImageColorAlpha(300, 200, ColorAlpha{255, 255, 255, 255}, PNG)
+
+	buf, err := Compose([]Layer{
+		{Buffer: background},
+		{Buffer: square, Left: 50, Top: 50},
+		{Buffer: white, Opacity: 0.5, Blend: BlendMultiply},
+	})
+	if err != nil {
+		t.Fatalf("Cannot compose the image: %#v", err)
+	}
+
+	if DetermineImageType(buf) != JPEG {
+		t.Fatal("Image is not jpeg")
+	}
+
+	pixels, width, height, bands, err := ToRaw(buf, Options{})
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+	if width != 300 || height != 200 || bands != 3 {
+		t.Fatalf("Invalid image: %dx%d with %d bands", width, height, bands)
+	}
+
+	// Multiplying by white keeps the colors below
+	tests := []struct {
+		x, y  int
+		pixel []byte
+	}{
+		{10, 10, []byte{255, 0, 0}},
+		{100, 100, []byte{0, 0, 255}},
+	}
+	for _, test := range tests {
+		offset := (test.y*width + test.x) * bands
+		for i, value := range test.pixel {
+			if byteDiff(pixels[offset+i], value) > 8 {
+				t.Fatalf("Invalid pixel at %d,%d: %v", test.x, test.y, pixels[offset:offset+3])
+			}
+		}
+	}
+}
+
+func TestComposeInvalidLayers(t *testing.T) {
+	if _, err := Compose(nil); err == nil {
+		t.Error("Expected error for no layers")
+	}
+
+	buf := readFile("test.png")
+	if _, err := Compose([]Layer{{Buffer: buf}, {Buffer: buf, Opacity: 2}}); err == nil {
+		t.Error("Expected error for invalid layer opacity")
+	}
+}
@@ -5,6 +5,15 @@ type Image struct {
 	buffer []byte
 }
 
+// ImageInfo represents the input and output image details of a processing.
+type ImageInfo struct {
+	InputType  ImageType
+	OutputType ImageType
+	InputSize  ImageSize
+	OutputSize ImageSize
+	Plan       ResizePlan
+}
+
 // NewImage creates a new Image struct with method DSL.
 func NewImage(buf []byte) *Image {
 	return &Image{buf}
@@ -78,6 +87,32 @@ func (i *Image) EnlargeAndCrop(width, height int) ([]byte, error) {
 	return i.Process(options)
 }
 
+// FitPad resizes the image to fit within width and height, without enlarging
+// it, and centres it on a canvas of the exact size filled with the background.
+func (i *Image) FitPad(width, height int, background Color) ([]byte, error) {
+	options := Options{
+		Width:      width,
+		Height:     height,
+		FitPad:     true,
+		Background: background,
+	}
+	return i.Process(options)
+}
+
+// EnlargeAndFitPad resizes the image to fit within width and height, enlarging
+// it if necessary, and centres it on a canvas of the exact size filled with
+// the background.
+func (i *Image) EnlargeAndFitPad(width, height int, background Color) ([]byte, error) {
+	options := Options{
+		Width:      width,
+		Height:     height,
+		Enlarge:    true,
+		FitPad:     true,
+		Background: background,
+	}
+	return i.Process(options)
+}
+
 // Crop crops the image to the exact size specified.
 func (i *Image) Crop(width, height int, gravity Gravity) ([]byte, error) {
 	options := Options{
@@ -155,18 +190,55 @@ func (i *Image) Flop() ([]byte, error) {
 	return i.Process(options)
 }
 
+// Shear skews the image by the given angles in degrees along the X and Y axes,
+// filling the exposed area with the given background color.
+func (i *Image) Shear(x, y float64, background Color) ([]byte, error) {
+	options := Options{Shear: Shear{X: x, Y: y}, Background: background}
+	return i.Process(options)
+}
+
+// Perspective warps the image moving each one of the four source points
+// into its destination point.
+func (i *Image) Perspective(p Perspective) ([]byte, error) {
+	options := Options{Perspective: p}
+	return i.Process(options)
+}
+
 // Convert converts image to another format.
 func (i *Image) Convert(t ImageType) ([]byte, error) {
 	options := Options{Type: t}
 	return i.Process(options)
 }
 
+// Quantize converts the image into an indexed PNG image of at most the given
+// number of colors, with the given amount of dithering (from 0 to 1).
+func (i *Image) Quantize(colors int, dither float64) ([]byte, error) {
+	options := Options{Type: PNG, PaletteColors: colors, Dither: dither}
+	return i.Process(options)
+}
+
+// FirstFrame converts the first frame of the image into a static JPEG preview.
+func (i *Image) FirstFrame() ([]byte, error) {
+	options := Options{FirstFrame: true}
+	return i.Process(options)
+}
+
 // Colourspace performs a color space conversion bsaed on the given interpretation.
 func (i *Image) Colourspace(c Interpretation) ([]byte, error) {
 	options := Options{Interpretation: c}
 	return i.Process(options)
 }
 
+// SetOpacity scales the image opacity by the given factor, from 0 to 1.
+func (i *Image) SetOpacity(factor float64) ([]byte, error) {
+	image, err := SetOpacity(i.buffer, factor)
+	if err != nil {
+		return nil, err
+	}
+	i.buffer = image
+	return image, nil
+}
+
 // Process processes the image based on the given transformation options,
 // talking with libvips bindings accordingly and returning the resultant
 // image buffer.
@@ -179,6 +251,17 @@ func (i *Image) Process(o Options) ([]byte, error) {
 	return image, nil
 }
 
+// ProcessWithMeta processes the image like Process, additionally returning
+// the input and output image types and sizes, and the resize plan.
+func (i *Image) ProcessWithMeta(o Options) ([]byte, ImageInfo, error) {
+	image, info, err := resizeWithMeta(i.buffer, o)
+	if err != nil {
+		return nil, info, err
+	}
+	i.buffer = image
+	return image, info, nil
+}
+
 // Metadata returns the image metadata (size, alpha channel, profile, EXIF rotation).
 func (i *Image) Metadata() (ImageMetadata, error) {
 	return Metadata(i.buffer)
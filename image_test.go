@@ -146,6 +146,73 @@ func TestImageThumbnail(t *testing.T) {
 	Write("fixtures/test_thumbnail_out.jpg", buf)
 }
 
+func TestImageQuantize(t *testing.T) {
+	buf, err := initImage("test.jpg").Quantize(16, 0.5)
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+
+	if DetermineImageType(buf) != PNG {
+		t.Fatal("Image is not png")
+	}
+	if err := assertSize(buf, 1680, 1050); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := initImage("test.jpg").Quantize(1000, 0.5); err == nil {
+		t.Fatal("Expected error for invalid palette colors")
+	}
+	if _, err := initImage("test.jpg").Quantize(16, 2); err == nil {
+		t.Fatal("Expected error for invalid dither")
+	}
+
+	Write("fixtures/test_quantize_out.png", buf)
+}
+
+func TestImageFitPad(t *testing.T) {
+	logo, _ := NewImageColor(50, 25, Color{0, 0, 255}, PNG)
+
+	tests := []struct {
+		enlarge bool
+		width   int
+	}{
+		{false, 50},
+		{true, 400},
+	}
+
+	for _, test := range tests {
+		var buf []byte
+		var err error
+		if test.enlarge {
+			buf, err = NewImage(logo).EnlargeAndFitPad(400, 400, Color{255, 255, 255})
+		} else {
+			buf, err = NewImage(logo).FitPad(400, 400, Color{255, 255, 255})
+		}
+		if err != nil {
+			t.Fatalf("Cannot process the image: %#v", err)
+		}
+		if err := assertSize(buf, 400, 400); err != nil {
+			t.Fatal(err)
+		}
+
+		// Measure the logo width along the middle row
+		pixels, width, height, bands, err := ToRaw(buf, Options{})
+		if err != nil {
+			t.Fatalf("Cannot read the image: %#v", err)
+		}
+		row := pixels[height/2*width*bands : (height/2+1)*width*bands]
+		logoWidth := 0
+		for x := 0; x < width; x++ {
+			if row[x*bands] < 128 {
+				logoWidth++
+			}
+		}
+		if logoWidth < test.width-2 || logoWidth > test.width+2 {
+			t.Errorf("Invalid logo width: %d != %d", logoWidth, test.width)
+		}
+	}
+}
+
 func TestImageWatermark(t *testing.T) {
 	image := initImage("test.jpg")
 	_, err := image.Crop(800, 600, GravityNorth)
@@ -284,6 +351,40 @@ func TestImageRotate(t *testing.T) {
 	Write("fixtures/test_image_rotate_out.jpg", buf)
 }
 
+func TestImageShear(t *testing.T) {
+	buf, err := initImage("test.png").Shear(20, 0, Color{255, 255, 255})
+	if err != nil {
+		t.Errorf("Cannot process the image: %#v", err)
+	}
+
+	size, err := Size(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size.Width <= 400 || size.Height != 300 {
+		t.Errorf("Invalid image size: %dx%d", size.Width, size.Height)
+	}
+
+	Write("fixtures/test_image_shear_out.png", buf)
+}
+
+func TestImagePerspective(t *testing.T) {
+	buf, err := initImage("test.jpg").Perspective(Perspective{
+		Source:      [4]Point{{100, 100}, {1580, 50}, {1600, 1000}, {50, 950}},
+		Destination: [4]Point{{0, 0}, {1680, 0}, {1680, 1050}, {0, 1050}},
+	})
+	if err != nil {
+		t.Errorf("Cannot process the image: %#v", err)
+	}
+
+	err = assertSize(buf, 1680, 1050)
+	if err != nil {
+		t.Error(err)
+	}
+
+	Write("fixtures/test_image_perspective_out.jpg", buf)
+}
+
 func TestImageConvert(t *testing.T) {
 	buf, err := initImage("test.jpg").Convert(PNG)
 	if err != nil {
@@ -292,6 +393,17 @@ func TestImageConvert(t *testing.T) {
 	Write("fixtures/test_image_convert_out.png", buf)
 }
 
+func TestImageFirstFrame(t *testing.T) {
+	buf, err := initImage("test.webp").FirstFrame()
+	if err != nil {
+		t.Errorf("Cannot process the image: %#v", err)
+	}
+	if DetermineImageType(buf) != JPEG {
+		t.Fatal("Image is not jpeg")
+	}
+	Write("fixtures/test_image_first_frame_out.jpg", buf)
+}
+
 func TestTransparentImageConvert(t *testing.T) {
 	image := initImage("transparent.png")
 	options := Options{
@@ -305,6 +417,28 @@ func TestTransparentImageConvert(t *testing.T) {
 	Write("fixtures/test_transparent_image_convert_out.jpg", buf)
 }
 
+func TestImageProcessWithMeta(t *testing.T) {
+	buf, info, err := initImage("test.jpg").ProcessWithMeta(Options{Width: 300, Height: 200, Crop: true, Type: PNG})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+
+	if info.InputType != JPEG || info.OutputType != PNG {
+		t.Errorf("Invalid image types: %s -> %s", ImageTypeName(info.InputType), ImageTypeName(info.OutputType))
+	}
+	if info.InputSize.Width != 1680 || info.InputSize.Height != 1050 {
+		t.Errorf("Invalid input size: %dx%d", info.InputSize.Width, info.InputSize.Height)
+	}
+	if info.OutputSize.Width != 300 || info.OutputSize.Height != 200 {
+		t.Errorf("Invalid output size: %dx%d", info.OutputSize.Width, info.OutputSize.Height)
+	}
+
+	err = assertSize(buf, 300, 200)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
 func TestImageMetadata(t *testing.T) {
 	data, err := initImage("test.png").Metadata()
 	if err != nil {
@@ -6,6 +6,14 @@ package bimg
 */
 import "C"
 
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
 // ImageSize represents the image width and height values
 type ImageSize struct {
 	Width  int
@@ -22,6 +30,8 @@ type ImageMetadata struct {
 	Space       string
 	Colourspace string
 	Size        ImageSize
+	ResolutionX float64
+	ResolutionY float64
 }
 
 // Size returns the image size by width and height pixels.
@@ -71,7 +81,622 @@ func Metadata(buf []byte) (ImageMetadata, error) {
 		Profile:     vipsHasProfile(image),
 		Space:       vipsSpace(image),
 		Type:        ImageTypeName(imageType),
+		ResolutionX: vipsResolutionDPI(image.Xres),
+		ResolutionY: vipsResolutionDPI(image.Yres),
 	}
 
 	return metadata, nil
 }
+
+// ColorModel returns the color model of the given image, as a normalized
+// label: "rgb", "rgba", "grayscale" (with or without alpha), "cmyk" or "lab"
+// (including LCh). An error is returned for the other color models (e.g:
+// multiband images).
+func ColorModel(buf []byte) (string, error) {
+	defer C.vips_thread_shutdown()
+
+	image, _, err := vipsRead(buf)
+	if err != nil {
+		return "", err
+	}
+	defer C.g_object_unref(C.gpointer(image))
+
+	model := colorModel(vipsInterpretation(image), vipsHasAlpha(image))
+	if model == "" {
+		return "", errors.New("Unsupported color model")
+	}
+
+	return model, nil
+}
+
+// colorModel maps the given libvips interpretation to its color model label.
+func colorModel(interpretation Interpretation, alpha bool) string {
+	switch interpretation {
+	case InterpretationSRGB, InterpretationRGB, InterpretationRGB16, InterpretationScRGB:
+		if alpha {
+			return "rgba"
+		}
+		return "rgb"
+	case InterpretationBW, InterpretationGREY16:
+		return "grayscale"
+	case InterpretationCMYK:
+		return "cmyk"
+	case InterpretationLAB, InterpretationLCH:
+		return "lab"
+	}
+	return ""
+}
+
+// IsOpaque returns true if the given image has no alpha channel, or if its
+// alpha channel is fully opaque (all the pixels have the maximum alpha value),
+// hence it can be flattened with no visible change.
+func IsOpaque(buf []byte) (bool, error) {
+	defer C.vips_thread_shutdown()
+
+	image, _, err := vipsRead(buf)
+	if err != nil {
+		return false, err
+	}
+	defer C.g_object_unref(C.gpointer(image))
+
+	return vipsIsOpaque(image)
+}
+
+// AnimationInfo returns the number of frames of the given animated image (GIF
+// or WebP), the number of times the animation loops (0 means forever) and the
+// delay of each frame, in milliseconds. Static images are reported as a single
+// frame with no delays. Frame delays require libvips 8.9+.
+func AnimationInfo(buf []byte) (frames int, loop int, delays []int, err error) {
+	defer C.vips_thread_shutdown()
+
+	// Load all the frames, which only reads the image headers
+	imageType := DetermineImageType(buf)
+	var image *C.VipsImage
+	if imageType == GIF || imageType == WEBP {
+		image, _, err = vipsReadType(buf, imageType, map[string]string{"n": "-1"})
+	} else {
+		image, _, err = vipsRead(buf)
+	}
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer C.g_object_unref(C.gpointer(image))
+
+	return vipsAnimationInfo(image)
+}
+
+// PhysicalSize returns the physical size of the given image, in millimetres,
+// derived from its size in pixels and its embedded resolution. DefaultDPI is
+// assumed for the images with no embedded resolution.
+func PhysicalSize(buf []byte) (widthMM, heightMM float64, err error) {
+	metadata, err := Metadata(buf)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	widthMM, heightMM = physicalSize(metadata.Size, metadata.ResolutionX, metadata.ResolutionY)
+	return widthMM, heightMM, nil
+}
+
+// physicalSize converts the given size into millimetres. libvips reports
+// 1 pixel per millimetre (25.4 DPI) for the images with no resolution.
+func physicalSize(size ImageSize, xdpi, ydpi float64) (float64, float64) {
+	if xdpi <= 0 || math.Abs(xdpi-25.4) < 0.01 {
+		xdpi = DefaultDPI
+	}
+	if ydpi <= 0 || math.Abs(ydpi-25.4) < 0.01 {
+		ydpi = DefaultDPI
+	}
+
+	return float64(size.Width) * 25.4 / xdpi, float64(size.Height) * 25.4 / ydpi
+}
+
+// IsAnimated returns true if the given image is animated: a GIF or WebP image
+// with more than one frame, or an APNG image. Only the image headers are
+// read, the frames are not decoded. Other image types are never animated.
+func IsAnimated(buf []byte) (bool, error) {
+	switch vipsImageType(buf) {
+	case UNKNOWN:
+		return false, errors.New("Unsupported image format")
+	case PNG:
+		return IsAnimatedPNG(buf), nil
+	case GIF, WEBP:
+	default:
+		return false, nil
+	}
+
+	defer C.vips_thread_shutdown()
+
+	image, _, err := vipsRead(buf)
+	if err != nil {
+		return false, err
+	}
+	defer C.g_object_unref(C.gpointer(image))
+
+	frames, _, _, err := vipsAnimationInfo(image)
+	if err != nil {
+		return false, err
+	}
+
+	return frames > 1, nil
+}
+
+// Entropy returns the Shannon entropy of the given image, in bits, computed
+// from the histogram of each 8 bits colour band and averaged across them,
+// ignoring the alpha channel: from 0 for blank images to 8 for noise.
+func Entropy(buf []byte) (float64, error) {
+	defer C.vips_thread_shutdown()
+
+	image, _, err := vipsRead(buf)
+	if err != nil {
+		return 0, err
+	}
+	defer C.g_object_unref(C.gpointer(image))
+
+	return vipsEntropy(image)
+}
+
+// Sharpness returns the focus measure of the given image: the variance of
+// the Laplacian of its luminance, where the luminance is scaled from 0 to 1
+// so the score doesn't depend on the image bit depth. Blurry images have
+// fewer edges, hence lower scores, e.g: to reject the out-of-focus scans. The
+// score depends on the image scale, so images must be compared at the same
+// resolution.
+func Sharpness(buf []byte) (float64, error) {
+	defer C.vips_thread_shutdown()
+
+	image, _, err := vipsRead(buf)
+	if err != nil {
+		return 0, err
+	}
+	defer C.g_object_unref(C.gpointer(image))
+
+	return vipsSharpness(image)
+}
+
+// XMP returns the XMP metadata packet (an XML document) embedded in the
+// given image, or an empty buffer if the image has no XMP metadata.
+func XMP(buf []byte) ([]byte, error) {
+	defer C.vips_thread_shutdown()
+
+	image, _, err := vipsRead(buf)
+	if err != nil {
+		return nil, err
+	}
+	defer C.g_object_unref(C.gpointer(image))
+
+	return vipsImageBlob(image, "xmp-data")
+}
+
+// IPTC returns the IPTC-IIM application record (2) datasets embedded in the
+// given image, by name (e.g: Keywords, By-line or CopyrightNotice), or by
+// record and dataset numbers (e.g: 2:200) for the unknown ones. Repeatable
+// datasets, like Keywords, are joined by semicolons. An empty map is returned
+// if the image has no IPTC metadata.
+func IPTC(buf []byte) (map[string]string, error) {
+	defer C.vips_thread_shutdown()
+
+	image, _, err := vipsRead(buf)
+	if err != nil {
+		return nil, err
+	}
+	defer C.g_object_unref(C.gpointer(image))
+
+	data, err := vipsImageBlob(image, "iptc-data")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseIPTC(data), nil
+}
+
+// IsProgressive returns true if the given JPEG image is progressive or the
+// given PNG image is interlaced. Only the image headers are inspected.
+// An error is returned for any other image type.
+func IsProgressive(buf []byte) (bool, error) {
+	switch vipsImageType(buf) {
+	case JPEG:
+		return isProgressiveJPEG(buf)
+	case PNG:
+		return isInterlacedPNG(buf)
+	}
+	return false, errors.New("Progressive detection is only supported for JPEG and PNG images")
+}
+
+// isProgressiveJPEG walks over the JPEG segments until the start of frame
+// marker is found, which defines the encoding process.
+func isProgressiveJPEG(buf []byte) (bool, error) {
+	progressive, found := false, false
+	err := jpegSegments(buf, func(marker byte, segment []byte) bool {
+		// Start of frame markers, excluding DHT, JPG and DAC
+		if marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC {
+			progressive = marker == 0xC2 || marker == 0xC6 || marker == 0xCA || marker == 0xCE
+			found = true
+		}
+		return found
+	})
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		return false, errors.New("JPEG start of frame marker not found")
+	}
+	return progressive, nil
+}
+
+// jpegSegments calls fn with the marker and the payload of every JPEG segment
+// preceding the start of scan, until fn returns true. An error is returned if
+// the markers or the segment lengths are invalid.
+func jpegSegments(buf []byte, fn func(marker byte, segment []byte) bool) error {
+	for i := 2; i+1 < len(buf); {
+		if buf[i] != 0xFF {
+			return errors.New("Invalid JPEG marker")
+		}
+
+		marker := buf[i+1]
+		switch {
+		// Fill bytes
+		case marker == 0xFF:
+			i++
+			continue
+		// Markers without payload
+		case marker == 0x01 || marker == 0xD8 || (marker >= 0xD0 && marker <= 0xD7):
+			i += 2
+			continue
+		// Start of scan or end of image reached
+		case marker == 0xDA || marker == 0xD9:
+			return nil
+		}
+
+		if i+3 >= len(buf) {
+			break
+		}
+		length := int(buf[i+2])<<8 | int(buf[i+3])
+		if length < 2 || i+2+length > len(buf) {
+			return errors.New("Invalid JPEG segment length")
+		}
+		if fn(marker, buf[i+4:i+2+length]) {
+			return nil
+		}
+
+		i += 2 + length
+	}
+
+	return nil
+}
+
+// isInterlacedPNG reads the interlace method from the PNG IHDR chunk.
+func isInterlacedPNG(buf []byte) (bool, error) {
+	// PNG signature (8) + chunk length (4) + chunk type (4) + IHDR data (13)
+	if len(buf) < 29 || string(buf[12:16]) != "IHDR" {
+		return false, errors.New("Invalid PNG header")
+	}
+	return buf[28] == 1, nil
+}
+
+// IsAnimatedPNG returns true if the given buffer is an APNG (animated PNG)
+// image, which defines its animation control (acTL) chunk before the image
+// data chunks. Only the image headers are inspected.
+func IsAnimatedPNG(buf []byte) bool {
+	if vipsImageType(buf) != PNG {
+		return false
+	}
+
+	// Walk over the chunks: length (4) + type (4) + data + CRC (4)
+	for i := 8; i+8 <= len(buf); {
+		length := int(buf[i])<<24 | int(buf[i+1])<<16 | int(buf[i+2])<<8 | int(buf[i+3])
+		switch string(buf[i+4 : i+8]) {
+		case "acTL":
+			return true
+		case "IDAT", "IEND":
+			return false
+		}
+		i += 12 + length
+	}
+
+	return false
+}
+
+// ExifThumbnail returns the JPEG thumbnail embedded in the EXIF metadata of
+// the given JPEG image, or in its JFIF extension (JFXX) segment, which is
+// usually good enough for gallery previews. Only the image headers are
+// inspected: the image itself is not decoded at all. An error is returned
+// if the image embeds no JPEG thumbnail.
+func ExifThumbnail(buf []byte) ([]byte, error) {
+	if vipsImageType(buf) != JPEG {
+		return nil, errors.New("Embedded thumbnails are only supported for JPEG images")
+	}
+
+	var thumbnail []byte
+	err := jpegSegments(buf, func(marker byte, segment []byte) bool {
+		switch {
+		// Metadata segments always precede the start of scan
+		case marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")):
+			thumbnail = exifThumbnail(segment[6:])
+		// JFXX extension with the thumbnail coded using JPEG
+		case marker == 0xE0 && len(segment) > 6 && bytes.HasPrefix(segment, []byte("JFXX\x00")) && segment[5] == 0x10:
+			thumbnail = append([]byte(nil), segment[6:]...)
+		}
+		return thumbnail != nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if thumbnail != nil {
+		return thumbnail, nil
+	}
+
+	return nil, errors.New("Embedded thumbnail not found")
+}
+
+// exifThumbnail reads the thumbnail defined by the JPEGInterchangeFormat
+// (offset) and JPEGInterchangeFormatLength tags of the IFD1 (the second
+// image file directory) of the given EXIF TIFF structure.
+func exifThumbnail(tiff []byte) []byte {
+	order, entries := exifEntries(tiff, 1)
+
+	offset, length := 0, 0
+	for _, entry := range entries {
+		switch order.Uint16(entry) {
+		case 0x0201:
+			offset = int(order.Uint32(entry[8:]))
+		case 0x0202:
+			length = int(order.Uint32(entry[8:]))
+		}
+	}
+
+	if offset <= 0 || length < 4 || offset+length > len(tiff) || vipsImageType(tiff[offset:offset+length]) != JPEG {
+		return nil
+	}
+
+	return append([]byte(nil), tiff[offset:offset+length]...)
+}
+
+// exifOrientation reads the orientation (0x0112) tag of the IFD0 (main image
+// file directory) of the given EXIF metadata, with or without its "Exif"
+// header, or returns 0 if not defined.
+func exifOrientation(exif []byte) int {
+	order, entries := exifEntries(bytes.TrimPrefix(exif, []byte("Exif\x00\x00")), 0)
+
+	for _, entry := range entries {
+		// SHORT value, stored in the first 2 bytes of the value field
+		if order.Uint16(entry) == 0x0112 {
+			if orientation := int(order.Uint16(entry[8:])); orientation <= 8 {
+				return orientation
+			}
+			return 0
+		}
+	}
+
+	return 0
+}
+
+// exifEntries returns the byte order of the given EXIF TIFF structure and the
+// 12 bytes entries (tag, type, count and value) of its image file directory
+// at the given index: 0 for the main image, 1 for the thumbnail. No entries
+// are returned if the structure or the directory is invalid.
+func exifEntries(tiff []byte, index int) (binary.ByteOrder, [][]byte) {
+	if len(tiff) < 8 {
+		return nil, nil
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, nil
+	}
+
+	// Skip the previous directories entries to reach the directory offset
+	ifd := int(order.Uint32(tiff[4:8]))
+	for ; index > 0; index-- {
+		if ifd < 8 || ifd+2 > len(tiff) {
+			return nil, nil
+		}
+		next := ifd + 2 + 12*int(order.Uint16(tiff[ifd:]))
+		if next+4 > len(tiff) {
+			return nil, nil
+		}
+		ifd = int(order.Uint32(tiff[next:]))
+	}
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return nil, nil
+	}
+
+	n := int(order.Uint16(tiff[ifd:]))
+	if ifd+2+12*n > len(tiff) {
+		return nil, nil
+	}
+	entries := make([][]byte, n)
+	for i := range entries {
+		entry := ifd + 2 + 12*i
+		entries[i] = tiff[entry : entry+12]
+	}
+
+	return order, entries
+}
+
+// jpegLuminanceTable is the standard (Annex K) JPEG luminance quantization
+// table, used by libjpeg at quality 50 and scaled for the other qualities.
+var jpegLuminanceTable = [64]int{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}
+
+// EstimateJPEGQuality returns the quality (from 1 to 100) the given JPEG image
+// was likely encoded at, inferred from the scaling of its luminance
+// quantization table compared to the standard libjpeg table. Only the image
+// headers are inspected. Images encoded with custom tables (e.g: mozjpeg
+// QuantTable) are estimated at the closest libjpeg quality.
+func EstimateJPEGQuality(buf []byte) (int, error) {
+	if vipsImageType(buf) != JPEG {
+		return 0, errors.New("Quality estimation is only supported for JPEG images")
+	}
+
+	var table [64]int
+	var found bool
+	var tableErr error
+	err := jpegSegments(buf, func(marker byte, segment []byte) bool {
+		// Define quantization tables (DQT) segment: precision and
+		// destination (1), then 64 values of 8 or 16 bits each. The
+		// quantization tables always precede the start of scan
+		for j := 0; marker == 0xDB && j < len(segment); {
+			precision, destination := int(segment[j]>>4), segment[j]&0x0F
+			size := 64 * (precision + 1)
+			if j+1+size > len(segment) {
+				tableErr = errors.New("Invalid JPEG quantization table")
+				return true
+			}
+
+			if destination == 0 {
+				for k := range table {
+					if precision == 0 {
+						table[k] = int(segment[j+1+k])
+					} else {
+						table[k] = int(binary.BigEndian.Uint16(segment[j+1+2*k:]))
+					}
+				}
+				found = true
+				return true
+			}
+
+			j += 1 + size
+		}
+		return false
+	})
+	if err == nil {
+		err = tableErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if found {
+		return estimateJPEGQuality(table), nil
+	}
+
+	return 0, errors.New("Quantization table not found")
+}
+
+// estimateJPEGQuality inverts the libjpeg quality scaling of the given
+// luminance quantization table. The table order doesn't matter since
+// only the sum of its values is compared.
+func estimateJPEGQuality(table [64]int) int {
+	sum, standard := 0, 0
+	for i := range table {
+		sum += table[i]
+		standard += jpegLuminanceTable[i]
+	}
+
+	// libjpeg scales the table by 5000 / quality below 50, or by
+	// 200 - 2 * quality above, as a percentage
+	scale := float64(sum) * 100 / float64(standard)
+	quality := 5000 / scale
+	if scale <= 100 {
+		quality = (200 - scale) / 2
+	}
+
+	return int(math.Max(1, math.Min(100, math.Round(quality))))
+}
+
+// iptcDatasets defines the names of the common IPTC-IIM application record datasets.
+var iptcDatasets = map[byte]string{
+	5:   "ObjectName",
+	7:   "EditStatus",
+	10:  "Urgency",
+	15:  "Category",
+	20:  "SupplementalCategories",
+	25:  "Keywords",
+	40:  "SpecialInstructions",
+	55:  "DateCreated",
+	60:  "TimeCreated",
+	80:  "By-line",
+	85:  "By-lineTitle",
+	90:  "City",
+	92:  "Sub-location",
+	95:  "Province-State",
+	100: "Country-PrimaryLocationCode",
+	101: "Country-PrimaryLocationName",
+	103: "OriginalTransmissionReference",
+	105: "Headline",
+	110: "Credit",
+	115: "Source",
+	116: "CopyrightNotice",
+	118: "Contact",
+	120: "Caption-Abstract",
+	122: "Writer-Editor",
+}
+
+// parseIPTC reads the IPTC-IIM datasets, either stored as they are or within
+// the IPTC (0x0404) resource of a Photoshop image resource block (8BIM).
+func parseIPTC(data []byte) map[string]string {
+	fields := map[string]string{}
+
+	data = bytes.TrimPrefix(data, []byte("Photoshop 3.0\x00"))
+	if bytes.HasPrefix(data, []byte("8BIM")) {
+		data = photoshopResource(data, 0x0404)
+	}
+
+	// Each dataset: tag marker (0x1C), record, dataset, length (2) and data
+	for i := 0; i+5 <= len(data) && data[i] == 0x1C; {
+		record, dataset := data[i+1], data[i+2]
+		length := int(data[i+3])<<8 | int(data[i+4])
+		if i+5+length > len(data) {
+			break
+		}
+
+		value := string(data[i+5 : i+5+length])
+		i += 5 + length
+
+		if record != 2 || dataset == 0 {
+			continue
+		}
+
+		name, ok := iptcDatasets[dataset]
+		if !ok {
+			name = fmt.Sprintf("2:%d", dataset)
+		}
+		if previous, ok := fields[name]; ok {
+			value = previous + ";" + value
+		}
+		fields[name] = value
+	}
+
+	return fields
+}
+
+// photoshopResource returns the data of the given resource
+// from the Photoshop image resource block.
+func photoshopResource(data []byte, id int) []byte {
+	// Each resource: signature (4), id (2), padded pascal name, size (4) and padded data
+	for i := 0; i+8 <= len(data) && string(data[i:i+4]) == "8BIM"; {
+		resource := int(data[i+4])<<8 | int(data[i+5])
+		name := int(data[i+6]) + 1
+		name += name % 2
+
+		offset := i + 6 + name
+		if offset+4 > len(data) {
+			break
+		}
+		size := int(binary.BigEndian.Uint32(data[offset:]))
+		offset += 4
+		if size < 0 || offset+size > len(data) {
+			break
+		}
+
+		if resource == id {
+			return data[offset : offset+size]
+		}
+		i = offset + size + size%2
+	}
+
+	return nil
+}
@@ -1,7 +1,13 @@
 package bimg
 
 import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
 	"io/ioutil"
+	"math"
 	"os"
 	"path"
 	"testing"
@@ -116,6 +122,547 @@ func TestColourspaceIsSupported(t *testing.T) {
 	}
 }
 
+func TestIsProgressive(t *testing.T) {
+	files := []struct {
+		name string
+		t    ImageType
+	}{
+		{"test.jpg", JPEG},
+		{"test.png", PNG},
+	}
+
+	for _, file := range files {
+		for _, interlace := range []bool{true, false} {
+			buf, err := Resize(readFile(file.name), Options{Width: 300, Type: file.t, Interlace: interlace})
+			if err != nil {
+				t.Fatalf("Cannot process the image: %s -> %s", file.name, err)
+			}
+
+			progressive, err := IsProgressive(buf)
+			if err != nil {
+				t.Fatalf("Cannot read the image: %s -> %s", file.name, err)
+			}
+			if progressive != interlace {
+				t.Fatalf("Unexpected progressive value: %s -> %t", file.name, progressive)
+			}
+		}
+	}
+
+	if _, err := IsProgressive(readFile("test.webp")); err == nil {
+		t.Fatal("Expected error for webp image")
+	}
+}
+
+func TestIsProgressiveFormatOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  Options
+		expected bool
+	}{
+		{"test.jpg", Options{Width: 300, Type: JPEG, JPEGProgressive: true}, true},
+		{"test.jpg", Options{Width: 300, Type: JPEG, PNGInterlace: true}, false},
+		{"test.png", Options{Width: 300, Type: PNG, PNGInterlace: true}, true},
+		{"test.png", Options{Width: 300, Type: PNG, JPEGProgressive: true}, false},
+	}
+
+	for _, test := range tests {
+		buf, err := Resize(readFile(test.name), test.options)
+		if err != nil {
+			t.Fatalf("Cannot process the image: %s -> %s", test.name, err)
+		}
+
+		progressive, err := IsProgressive(buf)
+		if err != nil {
+			t.Fatalf("Cannot read the image: %s -> %s", test.name, err)
+		}
+		if progressive != test.expected {
+			t.Fatalf("Unexpected progressive value: %#v -> %t", test.options, progressive)
+		}
+	}
+}
+
+func TestColorModel(t *testing.T) {
+	gray, _ := initImage("test.jpg").Colourspace(InterpretationBW)
+
+	tests := []struct {
+		buf   []byte
+		model string
+	}{
+		{readFile("test.jpg"), "rgb"},
+		{readFile("transparent.png"), "rgba"},
+		{gray, "grayscale"},
+	}
+
+	for _, test := range tests {
+		model, err := ColorModel(test.buf)
+		if err != nil {
+			t.Fatalf("Cannot read the image: %#v", err)
+		}
+		if model != test.model {
+			t.Errorf("Invalid color model: %s != %s", model, test.model)
+		}
+	}
+
+	if _, err := ColorModel([]byte("not an image")); err == nil {
+		t.Fatal("Expected error for an unsupported image format")
+	}
+}
+
+func TestColorModelLabels(t *testing.T) {
+	tests := []struct {
+		interpretation Interpretation
+		alpha          bool
+		model          string
+	}{
+		{InterpretationSRGB, false, "rgb"},
+		{InterpretationRGB16, true, "rgba"},
+		{InterpretationGREY16, true, "grayscale"},
+		{InterpretationCMYK, false, "cmyk"},
+		{InterpretationLCH, false, "lab"},
+		{InterpretationMultiband, false, ""},
+	}
+
+	for _, test := range tests {
+		if model := colorModel(test.interpretation, test.alpha); model != test.model {
+			t.Errorf("Invalid color model for %d: %s != %s", test.interpretation, model, test.model)
+		}
+	}
+}
+
+func TestIsOpaque(t *testing.T) {
+	files := []struct {
+		name   string
+		opaque bool
+	}{
+		{"test.jpg", true},
+		{"test.png", true},
+		{"transparent.png", false},
+	}
+
+	for _, file := range files {
+		opaque, err := IsOpaque(readFile(file.name))
+		if err != nil {
+			t.Fatalf("Cannot read the image: %s -> %s", file.name, err)
+		}
+		if opaque != file.opaque {
+			t.Errorf("Unexpected opacity: %s -> %t", file.name, opaque)
+		}
+	}
+
+	// An alpha channel with no transparency at all
+	pixels := bytes.Repeat([]byte{255}, 10*10*4)
+	image, err := NewImageFromRaw(pixels, 10, 10, 4)
+	if err != nil {
+		t.Fatalf("Cannot create the image: %#v", err)
+	}
+	if opaque, _ := IsOpaque(image.Image()); !opaque {
+		t.Error("Expected an opaque image")
+	}
+}
+
+func TestIsAnimatedPNG(t *testing.T) {
+	buf := readFile("test.png")
+	if IsAnimatedPNG(buf) {
+		t.Fatal("Expected a non animated PNG image")
+	}
+
+	// Insert an acTL chunk right after the IHDR chunk
+	acTL := []byte{0, 0, 0, 8, 'a', 'c', 'T', 'L', 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0}
+	animated := append(append(append([]byte{}, buf[:33]...), acTL...), buf[33:]...)
+	if !IsAnimatedPNG(animated) {
+		t.Fatal("Expected an animated PNG image")
+	}
+
+	if IsAnimatedPNG(readFile("test.jpg")) {
+		t.Fatal("Expected a non PNG image")
+	}
+}
+
+func TestResizePreserveAnimationGIF(t *testing.T) {
+	if !IsTypeSupported(GIF) {
+		t.Skip("GIF load is not supported by the current libvips compilation")
+	}
+
+	for _, format := range []ImageType{WEBP, GIF} {
+		if !IsTypeSupportedSave(format) {
+			continue
+		}
+
+		buf, err := Resize(animatedGIF(t), Options{Width: 5, PreserveAnimation: true, Type: format})
+		if err != nil {
+			t.Fatalf("Cannot resize the image as %s: %#v", ImageTypeName(format), err)
+		}
+
+		frames, _, delays, err := AnimationInfo(buf)
+		if err != nil {
+			t.Fatalf("Cannot read the animation: %#v", err)
+		}
+		if frames != 2 || len(delays) != 2 || delays[0] != 100 || delays[1] != 200 {
+			t.Errorf("Invalid %s animation: %d frames, delays %v", ImageTypeName(format), frames, delays)
+		}
+
+		size, _ := Size(buf)
+		if size.Width != 5 {
+			t.Errorf("Invalid %s frame width: %d", ImageTypeName(format), size.Width)
+		}
+	}
+
+	if IsTypeSupportedSave(GIF) {
+		buf, err := Resize(animatedGIF(t), Options{Width: 5, PreserveAnimation: true})
+		if err != nil {
+			t.Fatalf("Cannot resize the image: %#v", err)
+		}
+		animation, err := gif.DecodeAll(bytes.NewReader(buf))
+		if err != nil {
+			t.Fatalf("Cannot decode the animation: %#v", err)
+		}
+		if len(animation.Image) != 2 || animation.Delay[0] != 10 || animation.Delay[1] != 20 {
+			t.Errorf("Invalid GIF animation: %d frames, delays %v", len(animation.Image), animation.Delay)
+		}
+	}
+
+	if _, err := Resize(animatedGIF(t), Options{Width: 5, PreserveAnimation: true, Type: PNG}); err == nil {
+		t.Error("Expected error for animated PNG output")
+	}
+
+	// Only the first frame is resized without PreserveAnimation
+	buf, err := Resize(animatedGIF(t), Options{Width: 5, Type: PNG})
+	if err != nil {
+		t.Fatalf("Cannot resize the image: %#v", err)
+	}
+	if err := assertSize(buf, 5, 5); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExifThumbnail(t *testing.T) {
+	buf := readFile("test.jpg")
+	if _, err := ExifThumbnail(buf); err == nil {
+		t.Fatal("Expected error for image without embedded thumbnail")
+	}
+
+	thumbnail, err := Resize(buf, Options{Width: 100})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+
+	// TIFF header, IFD0 with no entries and IFD1 with the thumbnail offset and length
+	tiff := []byte{'I', 'I', 0x2A, 0, 8, 0, 0, 0, 0, 0, 14, 0, 0, 0, 2, 0}
+	for _, entry := range [][2]uint32{{0x0201, 44}, {0x0202, uint32(len(thumbnail))}} {
+		field := make([]byte, 12)
+		binary.LittleEndian.PutUint16(field, uint16(entry[0]))
+		binary.LittleEndian.PutUint16(field[2:], 4)
+		binary.LittleEndian.PutUint32(field[4:], 1)
+		binary.LittleEndian.PutUint32(field[8:], entry[1])
+		tiff = append(tiff, field...)
+	}
+	tiff = append(append(tiff, 0, 0, 0, 0), thumbnail...)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, byte((len(segment) + 2) >> 8), byte(len(segment) + 2)}
+	image := append(append(append(append([]byte{}, buf[:2]...), app1...), segment...), buf[2:]...)
+
+	embedded, err := ExifThumbnail(image)
+	if err != nil {
+		t.Fatalf("Cannot read the embedded thumbnail: %#v", err)
+	}
+	if !bytes.Equal(embedded, thumbnail) {
+		t.Fatal("Invalid embedded thumbnail")
+	}
+
+	if _, err := ExifThumbnail(readFile("test.png")); err == nil {
+		t.Fatal("Expected error for non JPEG image")
+	}
+}
+
+func TestExifOrientation(t *testing.T) {
+	little := []byte{'I', 'I', 0x2A, 0, 8, 0, 0, 0, 2, 0,
+		0x0F, 0x01, 2, 0, 6, 0, 0, 0, 0, 0, 0, 0,
+		0x12, 0x01, 3, 0, 1, 0, 0, 0, 6, 0, 0, 0}
+	big := []byte{'M', 'M', 0, 0x2A, 0, 0, 0, 8, 0, 1,
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, 8, 0, 0}
+
+	tests := []struct {
+		exif        []byte
+		orientation int
+	}{
+		{little, 6},
+		{append([]byte("Exif\x00\x00"), little...), 6},
+		{big, 8},
+		{little[:20], 0},
+		{[]byte("Exif\x00\x00"), 0},
+		{nil, 0},
+	}
+
+	for i, test := range tests {
+		if orientation := exifOrientation(test.exif); orientation != test.orientation {
+			t.Errorf("Invalid orientation for test %d: %d != %d", i, orientation, test.orientation)
+		}
+	}
+}
+
+func TestAnimationInfo(t *testing.T) {
+	frames, loop, delays, err := AnimationInfo(readFile("test.jpg"))
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+	if frames != 1 || loop != 0 || len(delays) != 0 {
+		t.Fatalf("Unexpected static image animation: %d, %d, %v", frames, loop, delays)
+	}
+
+	if !IsTypeSupported(GIF) {
+		t.Skip("GIF load is not supported by the current libvips compilation")
+	}
+
+	frames, loop, delays, err = AnimationInfo(animatedGIF(t))
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+	if frames != 2 || loop != 0 {
+		t.Fatalf("Unexpected animation: %d frames, %d loops", frames, loop)
+	}
+	if len(delays) > 0 && (len(delays) != 2 || delays[0] != 100 || delays[1] != 200) {
+		t.Fatalf("Unexpected frame delays: %v", delays)
+	}
+}
+
+func TestPhysicalSize(t *testing.T) {
+	buf, err := Resize(readFile("test.jpg"), Options{Width: 300})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+
+	metadata, _ := Metadata(buf)
+	widthMM, heightMM, err := PhysicalSize(buf)
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+
+	expectedWidth, expectedHeight := physicalSize(metadata.Size, metadata.ResolutionX, metadata.ResolutionY)
+	if widthMM != expectedWidth || heightMM != expectedHeight {
+		t.Fatalf("Invalid physical size: %fx%f", widthMM, heightMM)
+	}
+
+	if _, _, err := PhysicalSize([]byte("not an image")); err == nil {
+		t.Fatal("Expected error for an unsupported image format")
+	}
+}
+
+func TestCalculatePhysicalSize(t *testing.T) {
+	tests := []struct {
+		xdpi, ydpi        float64
+		widthMM, heightMM float64
+		defaultDPI        float64
+	}{
+		{300, 300, 25.4, 50.8, 72},
+		{300, 150, 25.4, 101.6, 72},
+		{0, 0, 105.833, 211.667, 72},
+		{25.4, 25.4, 50.8, 101.6, 150},
+	}
+
+	defer func(dpi float64) { DefaultDPI = dpi }(DefaultDPI)
+	for _, test := range tests {
+		DefaultDPI = test.defaultDPI
+		widthMM, heightMM := physicalSize(ImageSize{Width: 300, Height: 600}, test.xdpi, test.ydpi)
+		if math.Abs(widthMM-test.widthMM) > 0.001 || math.Abs(heightMM-test.heightMM) > 0.001 {
+			t.Errorf("Invalid physical size for %f/%f DPI: %fx%f", test.xdpi, test.ydpi, widthMM, heightMM)
+		}
+	}
+}
+
+func TestIsAnimated(t *testing.T) {
+	for _, file := range []string{"test.jpg", "test.png", "test.webp"} {
+		animated, err := IsAnimated(readFile(file))
+		if err != nil {
+			t.Fatalf("Cannot read the image %s: %#v", file, err)
+		}
+		if animated {
+			t.Fatalf("Image %s is not animated", file)
+		}
+	}
+
+	if _, err := IsAnimated([]byte("not an image")); err == nil {
+		t.Fatal("Expected error for an unsupported image format")
+	}
+
+	if !IsTypeSupported(GIF) {
+		t.Skip("GIF load is not supported by the current libvips compilation")
+	}
+
+	animated, err := IsAnimated(animatedGIF(t))
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+	if !animated {
+		t.Fatal("Image is animated")
+	}
+}
+
+func TestEstimateJPEGQuality(t *testing.T) {
+	for _, quality := range []int{50, 75, 90} {
+		buf, err := Resize(readFile("test.jpg"), Options{Width: 300, Quality: quality})
+		if err != nil {
+			t.Fatalf("Cannot process the image: %#v", err)
+		}
+
+		estimate, err := EstimateJPEGQuality(buf)
+		if err != nil {
+			t.Fatalf("Cannot estimate the image quality: %#v", err)
+		}
+		if estimate < quality-2 || estimate > quality+2 {
+			t.Errorf("Invalid quality estimate: %d != %d", estimate, quality)
+		}
+	}
+
+	// Minimal JPEG headers with a 16 bits luminance table
+	dqt := []byte{0xFF, 0xD8, 0xFF, 0xDB, 0x00, 0x83, 0x10}
+	for _, value := range jpegLuminanceTable {
+		dqt = append(dqt, 0, byte(value))
+	}
+	dqt = append(dqt, 0xFF, 0xDA)
+	if estimate, err := EstimateJPEGQuality(dqt); err != nil || estimate != 50 {
+		t.Errorf("Invalid quality estimate: %d, %#v", estimate, err)
+	}
+
+	if _, err := EstimateJPEGQuality(readFile("test.png")); err == nil {
+		t.Fatal("Expected error for a non-JPEG image")
+	}
+}
+
+func TestEstimateJPEGQualityTable(t *testing.T) {
+	for _, quality := range []int{25, 50, 75, 85, 95, 100} {
+		scale := 200 - 2*quality
+		if quality < 50 {
+			scale = 5000 / quality
+		}
+
+		var table [64]int
+		for i, value := range jpegLuminanceTable {
+			table[i] = int(math.Max(1, float64((value*scale+50)/100)))
+		}
+
+		if estimate := estimateJPEGQuality(table); estimate < quality-1 || estimate > quality {
+			t.Errorf("Invalid quality estimate: %d != %d", estimate, quality)
+		}
+	}
+}
+
+func TestSharpness(t *testing.T) {
+	buf := readFile("test.jpg")
+	sharpness, err := Sharpness(buf)
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+	if sharpness <= 0 {
+		t.Fatalf("Invalid sharpness: %f", sharpness)
+	}
+
+	blurred, err := Resize(buf, Options{GaussianBlur: GaussianBlur{Sigma: 5}})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	if blurredSharpness, _ := Sharpness(blurred); blurredSharpness >= sharpness {
+		t.Fatalf("Blurred image is not less sharp: %f >= %f", blurredSharpness, sharpness)
+	}
+
+	blank, _ := NewImageColor(100, 100, Color{128, 128, 128}, PNG)
+	if blankSharpness, _ := Sharpness(blank); blankSharpness > 1e-6 {
+		t.Fatalf("Invalid blank image sharpness: %f", blankSharpness)
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	entropy, err := Entropy(readFile("test.jpg"))
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+	if entropy < 5 || entropy > 8 {
+		t.Errorf("Unexpected entropy: %f", entropy)
+	}
+
+	// 16-bit images are scaled rather than clipped
+	buf, err := initImage("test.jpg").Process(Options{Type: PNG, Interpretation: InterpretationRGB16})
+	if err != nil {
+		t.Fatalf("Cannot convert the image: %#v", err)
+	}
+	if entropy16, _ := Entropy(buf); entropy16 < entropy-0.5 || entropy16 > entropy+0.5 {
+		t.Errorf("Unexpected 16-bit image entropy: %f, expected about %f", entropy16, entropy)
+	}
+
+	// Blank image
+	image, err := NewImageFromRaw(bytes.Repeat([]byte{128}, 10*10*3), 10, 10, 3)
+	if err != nil {
+		t.Fatalf("Cannot create the image: %#v", err)
+	}
+	if entropy, _ := Entropy(image.Image()); entropy != 0 {
+		t.Errorf("Unexpected blank image entropy: %f", entropy)
+	}
+}
+
+func TestXMP(t *testing.T) {
+	xmp, err := XMP(readFile("test.jpg"))
+	if err != nil {
+		t.Fatalf("Cannot read the XMP metadata: %#v", err)
+	}
+	if len(xmp) != 0 {
+		t.Fatalf("Unexpected XMP metadata: %s", xmp)
+	}
+}
+
+func TestIPTC(t *testing.T) {
+	fields, err := IPTC(readFile("test.jpg"))
+	if err != nil {
+		t.Fatalf("Cannot read the IPTC metadata: %#v", err)
+	}
+	if len(fields) != 0 {
+		t.Fatalf("Unexpected IPTC metadata: %v", fields)
+	}
+}
+
+func TestParseIPTC(t *testing.T) {
+	dataset := func(number byte, value string) []byte {
+		return append([]byte{0x1C, 2, number, 0, byte(len(value))}, value...)
+	}
+
+	iim := append(append(append(dataset(25, "sea"), dataset(25, "sky")...), dataset(116, "(c) bimg")...), dataset(200, "custom")...)
+	resource := append([]byte("8BIM\x04\x04\x00\x00"), 0, 0, 0, byte(len(iim)))
+	data := append(append([]byte("Photoshop 3.0\x00"), resource...), iim...)
+
+	fields := parseIPTC(data)
+	if fields["Keywords"] != "sea;sky" {
+		t.Errorf("Invalid keywords: %s", fields["Keywords"])
+	}
+	if fields["CopyrightNotice"] != "(c) bimg" {
+		t.Errorf("Invalid copyright notice: %s", fields["CopyrightNotice"])
+	}
+	if fields["2:200"] != "custom" {
+		t.Errorf("Invalid custom dataset: %s", fields["2:200"])
+	}
+
+	if fields := parseIPTC(nil); len(fields) != 0 {
+		t.Errorf("Unexpected fields: %v", fields)
+	}
+}
+
+// animatedGIF encodes a 10x10 animated GIF image of two frames, white and
+// black, displayed for 100 and 200 milliseconds and looping forever.
+func animatedGIF(t *testing.T) []byte {
+	animation := &gif.GIF{Delay: []int{10, 20}}
+	for _, c := range []color.Color{color.White, color.Black} {
+		frame := image.NewPaletted(image.Rect(0, 0, 10, 10), color.Palette{color.White, color.Black})
+		for i := range frame.Pix {
+			frame.Pix[i] = uint8(frame.Palette.Index(c))
+		}
+		animation.Image = append(animation.Image, frame)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, animation); err != nil {
+		t.Fatalf("Cannot encode the animation: %#v", err)
+	}
+	return buf.Bytes()
+}
+
 func readFile(file string) []byte {
 	data, _ := os.Open(path.Join("fixtures", file))
 	buf, _ := ioutil.ReadAll(data)
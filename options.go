@@ -3,6 +3,20 @@ package bimg
 /*
 #cgo pkg-config: vips
 #include "vips/vips.h"
+
+// The blend modes are only defined since libvips 8.6, which Compose requires
+#if (VIPS_MAJOR_VERSION < 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION < 6))
+#define VIPS_BLEND_MODE_OVER 2
+#define VIPS_BLEND_MODE_ADD 12
+#define VIPS_BLEND_MODE_MULTIPLY 14
+#define VIPS_BLEND_MODE_SCREEN 15
+#define VIPS_BLEND_MODE_OVERLAY 16
+#define VIPS_BLEND_MODE_DARKEN 17
+#define VIPS_BLEND_MODE_LIGHTEN 18
+#define VIPS_BLEND_MODE_HARD_LIGHT 21
+#define VIPS_BLEND_MODE_SOFT_LIGHT 22
+#define VIPS_BLEND_MODE_DIFFERENCE 23
+#endif
 */
 import "C"
 
@@ -11,8 +25,34 @@ const (
 	Quality = 80
 	// MaxSize defines the maximum pixels width or height supported.
 	MaxSize = 16383
+	// TrimThreshold defines the default trim threshold to be used.
+	TrimThreshold = 10
+	// MaxQuantTable defines the last JPEG quantization table preset supported.
+	MaxQuantTable = 8
+)
+
+// Default output settings used when the Options leave them undefined (zero).
+// They can be changed to define a house standard, before processing any image.
+var (
+	// DefaultJPEGQuality defines the default JPEG quality.
+	DefaultJPEGQuality = Quality
+	// DefaultWebPQuality defines the default WebP quality.
+	DefaultWebPQuality = 75
+	// DefaultPNGCompression defines the default PNG compression level.
+	DefaultPNGCompression = 6
 )
 
+// DefaultDPI defines the resolution assumed by PhysicalSize
+// for the images with no embedded resolution.
+var DefaultDPI = 72.0
+
+// minAntiAliasSigma defines the minimum sigma worth blurring the image for.
+const minAntiAliasSigma = 0.3
+
+// maxTrimDeviation defines the maximum difference between the corner
+// pixels, per 8 bits channel, to consider they share the same background.
+const maxTrimDeviation = 48
+
 // Gravity represents the image gravity value.
 type Gravity int
 
@@ -27,6 +67,17 @@ const (
 	GravitySouth
 	// GravityWest represents the west value used for image gravity orientation.
 	GravityWest
+	// GravitySmart represents the smart value used for image gravity orientation,
+	// which crops to the area the libvips attention model considers the most interesting.
+	GravitySmart
+	// GravityNorthEast represents the north east value used for image gravity orientation.
+	GravityNorthEast
+	// GravitySouthEast represents the south east value used for image gravity orientation.
+	GravitySouthEast
+	// GravitySouthWest represents the south west value used for image gravity orientation.
+	GravitySouthWest
+	// GravityNorthWest represents the north west value used for image gravity orientation.
+	GravityNorthWest
 )
 
 // Interpolator represents the image interpolation value.
@@ -51,6 +102,62 @@ func (i Interpolator) String() string {
 	return interpolations[i]
 }
 
+// Kernel represents the image resampling kernel used by libvips resize.
+type Kernel int
+
+const (
+	// KernelLanczos3 resampling kernel value.
+	KernelLanczos3 Kernel = iota
+	// KernelNearest resampling kernel value.
+	KernelNearest
+	// KernelLinear resampling kernel value.
+	KernelLinear
+	// KernelCubic resampling kernel value.
+	KernelCubic
+	// KernelLanczos2 resampling kernel value.
+	KernelLanczos2
+)
+
+var kernels = map[Kernel]string{
+	KernelLanczos3: "lanczos3",
+	KernelNearest:  "nearest",
+	KernelLinear:   "linear",
+	KernelCubic:    "cubic",
+	KernelLanczos2: "lanczos2",
+}
+
+func (k Kernel) String() string {
+	return kernels[k]
+}
+
+// QualityPreset represents a format independent image quality level.
+type QualityPreset int
+
+const (
+	// PresetDefault uses the default quality and compression of each image type.
+	PresetDefault QualityPreset = iota
+	// PresetLow quality preset value.
+	PresetLow
+	// PresetMedium quality preset value.
+	PresetMedium
+	// PresetHigh quality preset value.
+	PresetHigh
+	// PresetMax quality preset value.
+	PresetMax
+)
+
+var qualityPresets = map[QualityPreset]string{
+	PresetDefault: "default",
+	PresetLow:     "low",
+	PresetMedium:  "medium",
+	PresetHigh:    "high",
+	PresetMax:     "max",
+}
+
+func (p QualityPreset) String() string {
+	return qualityPresets[p]
+}
+
 // Angle represents the image rotation angle value.
 type Angle int
 
@@ -102,6 +209,8 @@ const (
 	InterpretationLAB Interpretation = C.VIPS_INTERPRETATION_LAB
 	// InterpretationXYZ points to its libvips interpretation equivalent type.
 	InterpretationXYZ Interpretation = C.VIPS_INTERPRETATION_XYZ
+	// InterpretationLCH points to its libvips interpretation equivalent type.
+	InterpretationLCH Interpretation = C.VIPS_INTERPRETATION_LCH
 )
 
 // Extend represents the image extend mode, used when the edges
@@ -124,8 +233,78 @@ const (
 	ExtendBackground Extend = C.VIPS_EXTEND_BACKGROUND
 	// ExtendLast extend with last pixel.
 	ExtendLast Extend = C.VIPS_EXTEND_LAST
+	// ExtendEdgeBlur copy the image edges and blur them, e.g: for ambient backgrounds.
+	ExtendEdgeBlur Extend = ExtendLast + 1
+)
+
+// TextAlign represents the alignment of the watermark text lines.
+type TextAlign int
+
+const (
+	// TextAlignStart aligns the lines to the start of the text direction:
+	// left for left-to-right text, right for right-to-left text.
+	TextAlignStart TextAlign = C.VIPS_ALIGN_LOW
+	// TextAlignCentre centres the lines.
+	TextAlignCentre TextAlign = C.VIPS_ALIGN_CENTRE
+	// TextAlignEnd aligns the lines to the end of the text direction.
+	TextAlignEnd TextAlign = C.VIPS_ALIGN_HIGH
 )
 
+// BlendMode represents the blend mode used to composite an image layer
+// over the layers below it. A zero BlendMode is handled as BlendOver.
+type BlendMode int
+
+const (
+	// BlendOver places the layer over the layers below, using its alpha channel.
+	BlendOver BlendMode = C.VIPS_BLEND_MODE_OVER
+	// BlendMultiply multiplies the layer colors, darkening the result.
+	BlendMultiply BlendMode = C.VIPS_BLEND_MODE_MULTIPLY
+	// BlendScreen inverts, multiplies and inverts the layer colors, lightening the result.
+	BlendScreen BlendMode = C.VIPS_BLEND_MODE_SCREEN
+	// BlendOverlay multiplies or screens the layer colors, depending on the colors below.
+	BlendOverlay BlendMode = C.VIPS_BLEND_MODE_OVERLAY
+	// BlendDarken keeps the darkest colors.
+	BlendDarken BlendMode = C.VIPS_BLEND_MODE_DARKEN
+	// BlendLighten keeps the lightest colors.
+	BlendLighten BlendMode = C.VIPS_BLEND_MODE_LIGHTEN
+	// BlendSoftLight darkens or lightens the colors below, depending on the layer colors.
+	BlendSoftLight BlendMode = C.VIPS_BLEND_MODE_SOFT_LIGHT
+	// BlendHardLight multiplies or screens the colors below, depending on the layer colors.
+	BlendHardLight BlendMode = C.VIPS_BLEND_MODE_HARD_LIGHT
+	// BlendDifference subtracts the darkest colors from the lightest ones.
+	BlendDifference BlendMode = C.VIPS_BLEND_MODE_DIFFERENCE
+	// BlendAdd adds the layer colors to the colors below.
+	BlendAdd BlendMode = C.VIPS_BLEND_MODE_ADD
+)
+
+// Layer represents an image layer composited by Compose.
+type Layer struct {
+	Buffer  []byte
+	Left    int
+	Top     int
+	Opacity float64
+	Blend   BlendMode
+}
+
+// OutputProfile represents the standard ICC profile, and colour space, of
+// the output images.
+type OutputProfile int
+
+const (
+	// ProfileSRGB keeps the output images in sRGB, the default.
+	ProfileSRGB OutputProfile = iota
+	// ProfileDisplayP3 transforms the output images into Display P3, embedding
+	// the libvips built-in profile. Requires libvips 8.14+.
+	ProfileDisplayP3
+	// ProfileAdobeRGB transforms the output images into Adobe RGB (1998),
+	// embedding the profile read from AdobeRGBProfileFile.
+	ProfileAdobeRGB
+)
+
+// AdobeRGBProfileFile defines the path of the Adobe RGB (1998) ICC profile
+// used by ProfileAdobeRGB, since libvips has no built-in Adobe RGB profile.
+var AdobeRGBProfileFile = ""
+
 // WatermarkFont defines the default watermark font to be used.
 var WatermarkFont = "sans 10"
 
@@ -137,22 +316,60 @@ type Color struct {
 // ColorBlack is a shortcut to black RGB color representation.
 var ColorBlack = Color{0, 0, 0}
 
+// ColorAlpha represents an RGB color scheme with an alpha channel,
+// where an alpha of 0 is fully transparent and 255 is fully opaque.
+type ColorAlpha struct {
+	R, G, B, A uint8
+}
+
 // Watermark represents the text-based watermark supported options.
+//
+// FontFile loads the given font file (e.g: TrueType or OpenType) before
+// rendering the text, so custom fonts work without any fontconfig setup.
+// Font must still name the family provided by the file (e.g: "Brand Bold
+// 12"). An error is returned if the font file is missing or cannot be
+// loaded, but bimg does not check that the file provides the family named
+// by Font: pango silently falls back to a default font if it doesn't.
+// Requires libvips 8.9+.
+//
+// Text can span multiple lines separated by "\n" (or "\r\n"), and lines
+// longer than Width pixels are wrapped. Align defines how the lines are
+// aligned relative to each other. The text direction is detected from its
+// script, RTL forces right-to-left paragraphs (e.g: for Arabic or Hebrew
+// captions starting with neutral characters like digits).
+//
+// MarginTop, MarginRight, MarginBottom and MarginLeft define the inset of the
+// text within each replicated tile, hence the spacing between the tiles, or
+// the position of the text from the top-left corner with NoReplicate. If none
+// is defined, the text is offset by 100 pixels within tiles Margin pixels
+// larger than the text.
 type Watermark struct {
-	Width       int
-	DPI         int
-	Margin      int
-	Opacity     float32
-	NoReplicate bool
-	Text        string
-	Font        string
-	Background  Color
+	Width        int
+	DPI          int
+	Margin       int
+	MarginTop    int
+	MarginRight  int
+	MarginBottom int
+	MarginLeft   int
+	Opacity      float32
+	NoReplicate  bool
+	Text         string
+	Font         string
+	FontFile     string
+	Align        TextAlign
+	RTL          bool
+	Background   Color
 }
 
 // GaussianBlur represents the gaussian image transformation values.
+// Edge defines how the image edges are extended before blurring, in order to
+// avoid a dark halo on the image borders (e.g: ExtendMirror, ExtendCopy or
+// ExtendBackground, which uses the background color). ExtendBlack, the
+// default, keeps the libvips edge handling.
 type GaussianBlur struct {
 	Sigma   float64
 	MinAmpl float64
+	Edge    Extend
 }
 
 // Sharpen represents the image sharp transformation options.
@@ -165,37 +382,355 @@ type Sharpen struct {
 	M2     float64
 }
 
+// ColorAdjust represents the color adjustments made in the perceptually
+// uniform LCh color space, independently of each other, before converting the
+// image back into sRGB. Lightness is added to the lightness (from 0 to 100),
+// Chroma is the relative chroma change (e.g: -1 for grayscale, 0.2 for 20%
+// more saturated colors) and Hue rotates the hue angle, in degrees.
+type ColorAdjust struct {
+	Lightness float64
+	Chroma    float64
+	Hue       float64
+}
+
+// Shear represents the image shear transformation options, defined as the
+// skew angles in degrees along the horizontal (X) and vertical (Y) axes.
+// The output image grows to fit the whole transformed image: the width
+// increases by height * tan(X) and the height by width * tan(Y), and the
+// exposed area is filled with the background color.
+type Shear struct {
+	X float64
+	Y float64
+}
+
+// Point represents a pixel position within an image.
+type Point struct {
+	X float64
+	Y float64
+}
+
+// Perspective represents the perspective (4-point) transformation options.
+// Each source point is moved to its destination point, and the rest of the
+// image is warped accordingly. The output image keeps the input size and the
+// area not covered by the transformed image is filled in black.
+type Perspective struct {
+	Source      [4]Point
+	Destination [4]Point
+}
+
+// CropRelative represents an image area relative to the image size, where
+// every value is a fraction of the image width or height, from 0 to 1.
+type CropRelative struct {
+	Left   float64
+	Top    float64
+	Width  float64
+	Height float64
+}
+
 // Options represents the supported image transformation options.
 type Options struct {
-	Height         int
-	Width          int
-	AreaHeight     int
-	AreaWidth      int
-	Top            int
-	Left           int
-	Quality        int
-	Compression    int
-	Zoom           int
-	Crop           bool
-	Enlarge        bool
-	Embed          bool
-	Flip           bool
-	Flop           bool
-	Force          bool
-	NoAutoRotate   bool
-	NoProfile      bool
-	Interlace      bool
-	Extend         Extend
-	Rotate         Angle
-	Background     Color
-	Gravity        Gravity
-	Watermark      Watermark
-	Type           ImageType
-	Interpolator   Interpolator
-	Interpretation Interpretation
-	GaussianBlur   GaussianBlur
-	Sharpen        Sharpen
-	Insert         Insert
+	Height               int
+	Width                int
+	AreaHeight           int
+	AreaWidth            int
+	Top                  int
+	Left                 int
+	Quality              int
+	Compression          int
+	Zoom                 int
+	ShrinkOnLoad         int
+	Crop                 bool
+	Enlarge              bool
+	Flip                 bool
+	Flop                 bool
+	Force                bool
+	NoAutoRotate         bool
+	NoProfile            bool
+	NoColourspaceConvert bool
+	Rotate               Angle
+	Background           Color
+	Gravity              Gravity
+	Watermark            Watermark
+	Type                 ImageType
+	Interpolator         Interpolator
+	Interpretation       Interpretation
+	GaussianBlur         GaussianBlur
+	Sharpen              Sharpen
+	ColorAdjust          ColorAdjust
+	Shear                Shear
+	Perspective          Perspective
+	Insert               Insert
+
+	// JPEGProgressive saves JPEG images as progressive and PNGInterlace saves
+	// PNG images as interlaced (Adam7), leaving the other image types
+	// unaffected. Interlace is deprecated: it enables both JPEGProgressive and
+	// PNGInterlace.
+	JPEGProgressive bool
+	PNGInterlace    bool
+	Interlace       bool
+
+	// Embed resizes the image to fit within Width and Height and extends it to
+	// that size with the Extend mode. Since images are never enlarged unless
+	// Enlarge is set, the images smaller than both Width and Height are
+	// returned at their size though, neither enlarged nor extended.
+	// ExtendEdgeBlur fills the padding with the image edges, replicated and
+	// blurred, so it blends seamlessly with the image instead of a fixed color.
+	Embed  bool
+	Extend Extend
+
+	// FitPad resizes the image, preserving its aspect ratio, to fit within
+	// Width and Height, then centres it on a canvas of exactly Width x Height
+	// filled with the Background color, e.g: for uniform thumbnail grids. Both
+	// Width and Height are required. As usual, smaller images are only
+	// enlarged to fit if Enlarge is set, otherwise they are centred on the
+	// canvas at their size, so small images (e.g: logos) don't get blurry:
+	// FitPad alone never upscales but always pads, while FitPad with Enlarge
+	// always fits the canvas.
+	FitPad bool
+
+	// MaxArea scales the image down, preserving its aspect ratio, so its area
+	// (width * height, in pixels) does not exceed the given value. Smaller
+	// images are left untouched. It's only used when neither Width nor Height
+	// are defined.
+	MaxArea int
+
+	// TargetWidth resizes the image to exactly the given width, preserving its
+	// aspect ratio and enlarging it if necessary, while MaxHeight caps the
+	// resultant height, cropping the overflow according to Gravity (e.g: for
+	// banner images of any aspect ratio). TargetWidth takes precedence over
+	// Width and Height, while MaxHeight has no effect without TargetWidth.
+	TargetWidth int
+	MaxHeight   int
+
+	// UseResize resizes the image in a single step with libvips resize, which
+	// combines the integral shrink and the residual reduction with the given
+	// Kernel (lanczos3 by default), instead of the legacy shrink plus affine
+	// transformation with the given Interpolator. Requires libvips 8.3+.
+	UseResize bool
+	Kernel    Kernel
+
+	// SmoothUpscale enlarges the image with libvips resize and the given
+	// UpscaleKernel (lanczos3 by default, or linear for a bilinear upscale),
+	// while the image reduction keeps using Kernel or Interpolator. It also
+	// applies to Zoom, which otherwise replicates the pixels (nearest
+	// neighbour). Requires libvips 8.3+.
+	SmoothUpscale bool
+	UpscaleKernel Kernel
+
+	// ScaleResolution scales the image resolution (DPI) proportionally to the
+	// zoom and resize factors, so the image keeps its physical print size:
+	// e.g. a 300 DPI image resized to half its width reports 150 DPI, and 600
+	// DPI once zoomed in by 2. By default the resolution metadata is left as
+	// it is.
+	ScaleResolution bool
+
+	// Focus defines the focal point of the image, as coordinates relative to
+	// its size (from 0 to 1, e.g: 0.25 is a quarter of the width or height,
+	// and 0 the left or top edge), to keep it in frame when the image is
+	// cropped: the crop area is centred on it, as far as the image bounds
+	// allow it, taking precedence over Gravity. No focal point is used if nil.
+	Focus *Point
+
+	// AntiAlias blurs the image right before the residual reduction (the
+	// affine transformation), trading some sharpness for fewer aliasing
+	// artifacts (e.g: moiré on fine patterns) on large downscales. The
+	// gaussian blur sigma is AntiAliasSigma or, when not defined, proportional
+	// to the reduction: half the residual downscale factor minus one. It has
+	// no effect along with UseResize, which already uses an anti-aliasing
+	// kernel.
+	AntiAlias      bool
+	AntiAliasSigma float64
+
+	// AutoSharpen sharpens the downscaled images with an amount proportional
+	// to the downscale factor, since the larger downscales look softer: the
+	// sharpening slope (Sharpen.M2) is 1 plus the log2 of the factor, up to 4.
+	// It has no effect on the images which are not downscaled, or if Sharpen
+	// is explicitly defined.
+	AutoSharpen bool
+
+	// UseThumbnail crops and resizes the image in a single step with libvips
+	// thumbnail (the fastest path for square thumbnails), as long as the image
+	// is cropped with GravityCentre or GravitySmart and no other geometric
+	// operation (rotation, flip, zoom, area extraction...) is required.
+	// Otherwise the image is processed as usual. Requires libvips 8.6+.
+	UseThumbnail bool
+
+	// StrictLoad rejects the images libvips emits any warning about while
+	// loading them (e.g: premature end of file or bad markers), returning the
+	// warning text as error. By default, libvips tolerates the decoding
+	// errors, so the valid part of truncated images (e.g: the top portion of a
+	// JPEG image) is salvaged instead. Non JPEG images require libvips 8.12+.
+	StrictLoad bool
+
+	// Preset maps a quality level into the quality and compression settings
+	// appropriate for the output image type: JPEG and TIFF quality 60, 75, 85
+	// or 95 and WebP quality 50, 70, 80 or 90 for low, medium, high or max
+	// presets. PNG images are lossless, so the presets map into the zlib
+	// compression level instead: 9, 6, 4 or 1, trading a larger file size for
+	// a faster encoding. Quality and Compression take precedence over Preset
+	// when defined.
+	Preset QualityPreset
+
+	// QuantTable selects the quantization table preset used to save JPEG
+	// images, from 0 to 8: 0 is the default libjpeg table, 2 (ImageMagick)
+	// and 3 (MSSIM tuned) usually look better at the same file size. Requires
+	// libvips 8.5+ built with mozjpeg, otherwise the default table is used.
+	QuantTable int
+
+	// JPEGRestartInterval inserts a restart marker every given number of MCUs
+	// (minimum coded units, e.g: 16x16 pixel blocks with the default chroma
+	// subsampling), not MCU rows, so the decoders can resynchronize after
+	// corrupt data or decode the image partially or in parallel. No restart
+	// markers are inserted by default. Requires libvips 8.13+, an error is
+	// returned otherwise.
+	JPEGRestartInterval int
+
+	// Lossless saves WebP and JPEG 2000 (JP2K) images with lossless
+	// compression, ignoring Quality. Other image types are not affected.
+	Lossless bool
+
+	// JP2KTileWidth and JP2KTileHeight define the JPEG 2000 images tile size
+	// (512 by default). Saving JPEG 2000 images requires libvips 8.11+ built
+	// with OpenJPEG: use IsTypeSupportedSave(JP2K) to check it.
+	JP2KTileWidth  int
+	JP2KTileHeight int
+
+	// PaletteColors saves PNG images as indexed (palette) images of at most
+	// the given number of colors (2 to 256), usually much smaller for flat
+	// graphics. Dither defines the amount of Floyd-Steinberg dithering used to
+	// preserve gradients, from 0 (no dithering) to 1 (full dithering).
+	// Requires libvips 8.7+ built with libimagequant, otherwise the images are
+	// saved as usual.
+	PaletteColors int
+	Dither        float64
+
+	// Trim removes the image borders made of the TrimBackground color, where
+	// the pixels differ from it less than TrimThreshold (10 by default),
+	// before resizing the image. TrimAuto trims the image too, but infers the
+	// background color and threshold from the four image corners instead: the
+	// background is their average color, and the threshold grows with how
+	// much they differ, so slightly varying borders (e.g: scanned pages) are
+	// fully removed. Images whose corners are too different to share a
+	// background are left untrimmed. Requires libvips 8.6+.
+	Trim           bool
+	TrimAuto       bool
+	TrimBackground Color
+	TrimThreshold  float64
+
+	// CropRelative extracts the given area of the image, defined as fractions
+	// of its width and height (e.g: Left 0.25 and Width 0.5 keep the central
+	// half of the columns), before resizing it. The area is clamped to the
+	// image bounds, and a zero Width or Height extends it to the right or
+	// bottom image edges.
+	CropRelative CropRelative
+
+	// InputType forces the libvips loader of the given image type, bypassing
+	// the detection of the input image type by its signature, e.g: to load the
+	// images with unusual headers or the formats only supported by ImageMagick
+	// (MAGICK). The image type must be loadable by the current libvips
+	// compilation.
+	InputType ImageType
+
+	// DisableCache disables the libvips operation cache while the image is
+	// processed, dropping the cached operations, which only waste memory when
+	// processing unique images (e.g: one-shot batches). The cache is global,
+	// hence it's disabled for the concurrent calls too, until the last call
+	// that disabled it is done and the previous cache size is restored.
+	DisableCache bool
+
+	// MaxMemory limits the memory, in bytes, an image can use while processed.
+	// libvips has no per-operation memory limit, so the memory is estimated
+	// from the size of the decoded input image, which is kept in memory by the
+	// random access loaders, and of the output image if it's enlarged. An
+	// error is returned before processing the image if the estimate exceeds
+	// the limit.
+	MaxMemory int
+
+	// LoadOptions are passed as they are to the libvips image loader, by
+	// name, e.g: {"shrink": "2"} for JPEG, {"page": "1", "n": "1"} for
+	// multi-page images or {"dpi": "300"} for PDF and {"scale": "2"} for SVG
+	// images. See the libvips documentation of each loader for the supported
+	// options.
+	LoadOptions map[string]string
+
+	// HighQualityRotate reduces the quality loss when rotating JPEG images by
+	// multiples of 90 degrees (including the EXIF based auto-rotation), as
+	// long as the output is JPEG too. The rotation is not lossless: libvips
+	// does not implement jpegtran-like transformations in the DCT domain, so
+	// the image is still decoded and re-encoded, with no chroma subsampling
+	// and quality 100 unless Quality is defined. Other image types are rotated
+	// as usual.
+	HighQualityRotate bool
+
+	// AssignSRGBProfile embeds the standard sRGB ICC profile into the sRGB
+	// output images with no profile, so viewers that assume another colour
+	// space for the untagged images render the colors consistently. The
+	// profile is kept while any other metadata (EXIF, XMP, IPTC...) is
+	// stripped as usual. It has no effect along with NoProfile. Requires
+	// libvips 8.7+.
+	AssignSRGBProfile bool
+
+	// OutputProfile transforms the sRGB output images into the given standard
+	// colour space (e.g: Display P3, for wide gamut screens) and embeds its
+	// ICC profile, keeping it while any other metadata is stripped as usual.
+	// The input embedded profile, if any, is used as the source profile, or
+	// sRGB otherwise. It has no effect along with NoProfile or
+	// NoColourspaceConvert, or on grayscale and CMYK images. Requires libvips
+	// 8.7+.
+	OutputProfile OutputProfile
+
+	// NoPremultiplyAlpha disables the premultiplication of the image colors by
+	// the alpha channel while the image is resized, which prevents the colors
+	// of the transparent pixels from bleeding into the visible ones (e.g: dark
+	// or white halos along the hard alpha edges). Only images with an alpha
+	// channel are premultiplied. Requires libvips 8.1+, otherwise nothing is
+	// premultiplied.
+	NoPremultiplyAlpha bool
+
+	// KeepInterpretation keeps the grayscale images (8 or 16 bits) in
+	// grayscale, saving them with a single band (plus alpha) instead of
+	// converting them into sRGB, unless Interpretation is defined. The 16 bits
+	// images keep 16 bits if the output image type supports it (e.g: PNG).
+	// Color images are converted as usual.
+	KeepInterpretation bool
+
+	// ForceRGB converts the output image into sRGB with exactly 3 bands:
+	// grayscale and CMYK images are converted and the alpha channel, if any,
+	// is dropped as is. Set BackgroundAlpha, or Background for PNG images,
+	// to flatten it first instead. It takes precedence over Interpretation.
+	ForceRGB bool
+
+	// PreserveAnimation transforms all the frames of animated GIF and WebP
+	// images one by one, instead of only their first frame, keeping their
+	// delays and loop count, and saves them as GIF (requires libvips 8.12+) or
+	// WebP. The frames are decoded already composited according to their
+	// disposal method, hence they are saved as full frames. APNG (animated
+	// PNG) images are not supported, since libvips only decodes their default
+	// image: use IsAnimatedPNG to detect them and serve them as they are.
+	PreserveAnimation bool
+
+	// SkipColourspaceCheck bypasses the check of whether the image colour
+	// space can be converted before saving it. Only use it when the input
+	// images are known to be sRGB (or any colour space supported by libvips),
+	// otherwise the image save will fail.
+	SkipColourspaceCheck bool
+
+	// BackgroundAlpha flattens the image alpha channel over the given color
+	// with alpha, taking precedence over Background. Semi-transparent
+	// backgrounds keep the alpha channel, which results from compositing the
+	// image over the background layer, as long as the output type supports it
+	// (png, webp or tiff); other output types are flattened over the opaque
+	// color. Any color, including the transparent black zero value, is used
+	// as long as it's not nil.
+	BackgroundAlpha *ColorAlpha
+
+	// FirstFrame produces a static preview of animated or multi-page WebP and
+	// TIFF images, or GIF images as long as the libvips compilation can load
+	// them (use IsTypeSupported(GIF) to check it): only the first frame is
+	// ever loaded, and its alpha channel, if any, is flattened over the
+	// background color. The output type defaults to JPEG instead of the input
+	// image type.
+	FirstFrame bool
 }
 
 // Insert represents the insert supported options.
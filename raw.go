@@ -0,0 +1,76 @@
+package bimg
+
+/*
+#cgo pkg-config: vips
+#include "vips/vips.h"
+*/
+import "C"
+
+// ToRaw processes the given image buffer with the passed options and returns
+// the resultant decoded pixels instead of an encoded image.
+//
+// Pixels are returned as 8 bits per sample, with bands interleaved (e.g:
+// RGBRGB... or RGBARGBA...) and rows stored top to bottom with no padding,
+// so the row stride is always width * bands bytes.
+func ToRaw(buf []byte, o Options) (pixels []byte, width, height, bands int, err error) {
+	defer C.vips_thread_shutdown()
+
+	if o.DisableCache {
+		defer vipsDisableCache()()
+	}
+
+	image, o, _, err := resizeImage(buf, o)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+
+	// Apply the output colour space, like it's done before saving
+	if !o.NoColourspaceConvert && vipsColourspaceIsSupported(image) {
+		image, err = vipsColourspace(image, o.Interpretation)
+		if err != nil {
+			return nil, 0, 0, 0, err
+		}
+	}
+
+	image, err = vipsCast(image, C.VIPS_FORMAT_UCHAR)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+
+	width, height, bands = int(image.Xsize), int(image.Ysize), int(image.Bands)
+
+	pixels, err = vipsWriteToMemory(image)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+
+	return pixels, width, height, bands, nil
+}
+
+// NewImageFromRaw creates a new Image from raw pixels, using the same layout
+// returned by ToRaw: 8 bits per sample, interleaved bands and rows stored top
+// to bottom. One or two bands are handled as grayscale (plus alpha), three or
+// four bands as sRGB (plus alpha).
+//
+// The pixels are losslessly encoded as PNG, so the resultant Image can be
+// processed and converted into any other supported format.
+func NewImageFromRaw(pixels []byte, width, height, bands int) (*Image, error) {
+	defer C.vips_thread_shutdown()
+
+	image, err := vipsReadRaw(pixels, width, height, bands)
+	if err != nil {
+		return nil, err
+	}
+
+	interpretation := InterpretationSRGB
+	if bands < 3 {
+		interpretation = InterpretationBW
+	}
+
+	buf, err := vipsSave(image, vipsSaveOptions{Type: PNG, Compression: 6, Interpretation: interpretation})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewImage(buf), nil
+}
@@ -0,0 +1,120 @@
+package bimg
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestToRaw(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+	options := Options{Width: 300, Height: 200, Crop: true}
+
+	pixels, width, height, bands, err := ToRaw(buf, options)
+	if err != nil {
+		t.Fatalf("ToRaw(imgData, %#v) error: %#v", options, err)
+	}
+
+	if width != 300 || height != 200 {
+		t.Fatalf("Invalid image size: %dx%d", width, height)
+	}
+	if bands != 3 {
+		t.Fatalf("Invalid number of bands: %d", bands)
+	}
+	if len(pixels) != width*height*bands {
+		t.Fatalf("Invalid pixels length: %d", len(pixels))
+	}
+}
+
+func TestToRawWithAlpha(t *testing.T) {
+	buf, _ := Read("fixtures/transparent.png")
+
+	pixels, width, height, bands, err := ToRaw(buf, Options{})
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+
+	if bands != 4 {
+		t.Fatalf("Invalid number of bands: %d", bands)
+	}
+	if len(pixels) != width*height*bands {
+		t.Fatalf("Invalid pixels length: %d", len(pixels))
+	}
+}
+
+func TestToRawForceRGB(t *testing.T) {
+	gray, _ := initImage("test.jpg").Colourspace(InterpretationBW)
+
+	tests := [][]byte{gray, readFile("transparent.png"), readFile("test.jpg")}
+
+	for _, buf := range tests {
+		pixels, width, height, bands, err := ToRaw(buf, Options{ForceRGB: true, NoColourspaceConvert: true})
+		if err != nil {
+			t.Fatalf("Cannot read the image: %#v", err)
+		}
+		if bands != 3 {
+			t.Fatalf("Invalid number of bands: %d", bands)
+		}
+		if len(pixels) != width*height*bands {
+			t.Fatalf("Invalid pixels length: %d", len(pixels))
+		}
+	}
+}
+
+func TestToRawForceRGBDropsAlpha(t *testing.T) {
+	buf := readFile("transparent.png")
+
+	rgba, _, _, bands, err := ToRaw(buf, Options{NoColourspaceConvert: true})
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+	if bands != 4 {
+		t.Fatalf("Invalid number of bands: %d", bands)
+	}
+
+	rgb, _, _, _, err := ToRaw(buf, Options{ForceRGB: true, NoColourspaceConvert: true})
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+	if len(rgb)*4 != len(rgba)*3 {
+		t.Fatalf("Invalid pixels length: %d", len(rgb))
+	}
+
+	for i := 0; i < len(rgb)/3; i++ {
+		if !bytes.Equal(rgb[i*3:i*3+3], rgba[i*4:i*4+3]) {
+			t.Fatalf("Pixel %d was altered: %v != %v", i, rgb[i*3:i*3+3], rgba[i*4:i*4+3])
+		}
+	}
+}
+
+func TestNewImageFromRaw(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+
+	pixels, width, height, bands, err := ToRaw(buf, Options{Width: 300, Height: 200, Crop: true})
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+
+	image, err := NewImageFromRaw(pixels, width, height, bands)
+	if err != nil {
+		t.Fatalf("Cannot create the image: %#v", err)
+	}
+
+	buf, err = image.Convert(JPEG)
+	if err != nil {
+		t.Errorf("Cannot process the image: %#v", err)
+	}
+
+	err = assertSize(buf, 300, 200)
+	if err != nil {
+		t.Error(err)
+	}
+
+	Write("fixtures/test_raw_out.jpg", buf)
+}
+
+func TestNewImageFromRawInvalidLength(t *testing.T) {
+	_, err := NewImageFromRaw(make([]byte, 10), 300, 200, 3)
+	if err == nil {
+		t.Fatal("Expected error for invalid raw pixels length")
+	}
+}
@@ -0,0 +1,90 @@
+package bimg
+
+/*
+#cgo pkg-config: vips
+#include "vips/vips.h"
+*/
+import "C"
+
+// ShouldReencode returns true if processing the given image buffer with the
+// given options would actually change the image, plus the reason why, so the
+// original image can be served as it is otherwise. Reasons are: "unreadable",
+// "type", "orientation", "rotation", "size", "area", "zoom", "effects",
+// "colourspace", "flatten", "profile" and "palette".
+//
+// Only the image header is inspected. The encoding parameters, such as the
+// quality or the compression level, are not taken into account since they
+// cannot be read from the original image.
+func ShouldReencode(buf []byte, o Options) (bool, string) {
+	defer C.vips_thread_shutdown()
+
+	if len(buf) == 0 {
+		return true, "unreadable"
+	}
+
+	image, imageType, err := vipsRead(buf)
+	if err != nil {
+		return true, "unreadable"
+	}
+	defer C.g_object_unref(C.gpointer(image))
+
+	o = applyDefaults(keepInterpretation(o, image), imageType)
+	width, height := int(image.Xsize), int(image.Ysize)
+
+	switch {
+	case o.Type != imageType:
+		return true, "type"
+	case !o.NoAutoRotate && vipsExifOrientation(image) > 1:
+		return true, "orientation"
+	case o.Rotate != 0 || o.Flip || o.Flop || o.Shear != (Shear{}) || o.Perspective != (Perspective{}):
+		return true, "rotation"
+	case shouldResizeImage(o, width, height):
+		return true, "size"
+	case o.AreaWidth > 0 || o.AreaHeight > 0 || o.Top != 0 || o.Left != 0 || o.CropRelative != (CropRelative{}):
+		return true, "area"
+	case o.Zoom > 0:
+		return true, "zoom"
+	case shouldApplyEffects(o) || o.Watermark.Text != "" || len(o.Insert.Image) > 0:
+		return true, "effects"
+	case !o.NoColourspaceConvert && vipsColourspaceIsSupported(image) && vipsInterpretation(image) != o.Interpretation:
+		return true, "colourspace"
+	case vipsHasAlpha(image) && (o.FirstFrame || o.BackgroundAlpha != nil || (imageType == PNG && o.Background != ColorBlack)):
+		return true, "flatten"
+	case o.NoProfile && vipsHasProfile(image):
+		return true, "profile"
+	case o.PaletteColors > 0:
+		return true, "palette"
+	}
+
+	return false, ""
+}
+
+// shouldResizeImage returns true if the requested output size differs
+// from the given image size, taking into account the enlarge rules.
+func shouldResizeImage(o Options, width, height int) bool {
+	if o.TargetWidth > 0 {
+		_, _, crop := calculateTargetWidthSize(width, height, o.TargetWidth, o.MaxHeight)
+		return width != o.TargetWidth || crop
+	}
+
+	if o.MaxArea > 0 && o.Width == 0 && o.Height == 0 {
+		return width*height > o.MaxArea
+	}
+
+	outWidth, outHeight := o.Width, o.Height
+	switch {
+	case outWidth > 0 && outHeight == 0:
+		outHeight = height * outWidth / width
+	case outHeight > 0 && outWidth == 0:
+		outWidth = width * outHeight / height
+	case outWidth == 0 && outHeight == 0:
+		return false
+	}
+
+	// Images are not enlarged by default
+	if !o.Enlarge && !o.Force && width < outWidth && height < outHeight {
+		return false
+	}
+
+	return outWidth != width || outHeight != height
+}
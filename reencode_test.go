@@ -0,0 +1,43 @@
+package bimg
+
+import (
+	"testing"
+)
+
+func TestShouldReencode(t *testing.T) {
+	tests := []struct {
+		file     string
+		options  Options
+		expected bool
+		reason   string
+	}{
+		{"test.jpg", Options{}, false, ""},
+		{"test.jpg", Options{Type: JPEG, Quality: 90}, false, ""},
+		{"test.jpg", Options{Width: 1680}, false, ""},
+		{"test.jpg", Options{Width: 2000, Height: 2000}, false, ""},
+		{"test.jpg", Options{Width: 2000, Enlarge: true}, true, "size"},
+		{"test.jpg", Options{Width: 800}, true, "size"},
+		{"test.jpg", Options{MaxArea: 1000000}, true, "size"},
+		{"test.jpg", Options{TargetWidth: 1680, MaxHeight: 1200}, false, ""},
+		{"test.jpg", Options{TargetWidth: 1680, MaxHeight: 600}, true, "size"},
+		{"test.jpg", Options{CropRelative: CropRelative{Width: 0.5}}, true, "area"},
+		{"test.jpg", Options{Type: PNG}, true, "type"},
+		{"test.jpg", Options{Rotate: D90}, true, "rotation"},
+		{"test.jpg", Options{Zoom: 1}, true, "zoom"},
+		{"test.jpg", Options{GaussianBlur: GaussianBlur{Sigma: 5}}, true, "effects"},
+		{"test.jpg", Options{Interpretation: InterpretationBW}, true, "colourspace"},
+		{"transparent.png", Options{Background: Color{255, 255, 255}}, true, "flatten"},
+		{"transparent.png", Options{PaletteColors: 16}, true, "palette"},
+	}
+
+	for _, test := range tests {
+		reencode, reason := ShouldReencode(readFile(test.file), test.options)
+		if reencode != test.expected || reason != test.reason {
+			t.Errorf("Invalid result for %s %#v: %t %s", test.file, test.options, reencode, reason)
+		}
+	}
+
+	if reencode, reason := ShouldReencode([]byte{}, Options{}); !reencode || reason != "unreadable" {
+		t.Errorf("Invalid result for empty buffer: %t %s", reencode, reason)
+	}
+}
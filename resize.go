@@ -9,6 +9,8 @@ import "C"
 import (
 	"errors"
 	"math"
+	"os"
+	"strings"
 )
 
 func FixRotation(buf []byte, o Options) ([]byte, error) {
@@ -23,6 +25,9 @@ func FixRotation(buf []byte, o Options) ([]byte, error) {
 		return nil, err
 	}
 
+	// Keep the grayscale images interpretation, if required
+	o = keepInterpretation(o, image)
+
 	// Clone and define default options
 	o = applyDefaults(o, imageType)
 
@@ -46,22 +51,48 @@ func FixRotation(buf []byte, o Options) ([]byte, error) {
 		}
 	}
 
-	saveOptions := vipsSaveOptions{
-		Quality:        o.Quality,
-		Type:           o.Type,
-		Compression:    o.Compression,
-		Interlace:      o.Interlace,
-		NoProfile:      o.NoProfile,
-		Interpretation: o.Interpretation,
+	// Finally get the resultant buffer
+	return saveImage(image, o)
+}
+
+// AutoRotate rotates and flips the given image based on its EXIF orientation,
+// without applying any other transformation, and encodes it in the same
+// image format. The given buffer is returned as it is if the image has no
+// orientation to fix.
+func AutoRotate(buf []byte) ([]byte, error) {
+	defer C.vips_thread_shutdown()
+
+	if len(buf) == 0 {
+		return nil, errors.New("Image buffer is empty")
 	}
 
-	// Finally get the resultant buffer
-	return vipsSave(image, saveOptions)
+	image, imageType, err := vipsRead(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if vipsExifOrientation(image) <= 1 {
+		C.g_object_unref(C.gpointer(image))
+		return buf, nil
+	}
+
+	o := applyDefaults(Options{}, imageType)
+
+	image, _, err = rotateAndFlipImage(image, o)
+	if err != nil {
+		return nil, err
+	}
+
+	// The orientation is already applied to the pixels
+	vipsRemoveOrientation(image)
+
+	return saveImage(image, o)
 }
 
-// Resize is used to transform a given image as byte buffer
-// with the passed options.
-func Resize(buf []byte, o Options) ([]byte, error) {
+// ToJPEG converts the given image buffer into JPEG with the given quality,
+// flattening the alpha channel, if present, over the given background color.
+// A quality of 0 uses the default JPEG quality.
+func ToJPEG(buf []byte, background Color, quality int) ([]byte, error) {
 	defer C.vips_thread_shutdown()
 
 	if len(buf) == 0 {
@@ -73,32 +104,720 @@ func Resize(buf []byte, o Options) ([]byte, error) {
 		return nil, err
 	}
 
+	o := applyDefaults(Options{Type: JPEG, Quality: quality}, imageType)
+
+	flatten, err := vipsFlattenBackground(image, background)
+	if err != nil {
+		C.g_object_unref(C.gpointer(image))
+		return nil, err
+	}
+
+	return saveImage(flatten, o)
+}
+
+// ssimMaxIterations caps the number of encodings tried by EncodeToSSIM,
+// which is enough to binary search the whole quality range.
+const ssimMaxIterations = 8
+
+// EncodeToSSIM encodes the given image buffer into the given lossy image
+// format (JPEG, WEBP or TIFF) with the lowest quality whose SSIM against the
+// original image meets the given target, from 0 to 1, returning the encoded
+// image and the quality used. The image is encoded with the maximum quality
+// if the target cannot be met.
+func EncodeToSSIM(buf []byte, target float64, format ImageType) ([]byte, int, error) {
+	if target <= 0 || target > 1 {
+		return nil, 0, errors.New("SSIM target must be between 0 and 1")
+	}
+	if _, ok := presetQualities[format]; !ok || !IsTypeSupportedSave(format) {
+		return nil, 0, errors.New("Unsupported lossy image output type")
+	}
+
+	original, width, height, err := ssimLuma(buf)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var best []byte
+	low, high := 1, 100
+	for i := 0; i < ssimMaxIterations && low < high; i++ {
+		quality := (low + high) / 2
+
+		encoded, err := Resize(buf, Options{Type: format, Quality: quality})
+		if err != nil {
+			return nil, 0, err
+		}
+
+		luma, _, _, err := ssimLuma(encoded)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if ssim(original, luma, width, height) >= target {
+			best, high = encoded, quality
+		} else {
+			low = quality + 1
+		}
+	}
+
+	// The target was never met, or the upper bound was never encoded
+	if best == nil {
+		best, err = Resize(buf, Options{Type: format, Quality: high})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return best, high, nil
+}
+
+// Optimize re-encodes the given image buffer to minimize its size, without
+// resizing it: the metadata is stripped (except the ICC profile, unless
+// NoProfile is set), JPEG images use optimized Huffman tables, and PNG images
+// the maximum compression level, unless defined otherwise. Lossy images are
+// encoded with the given quality, or the default one. The image is auto
+// rotated based on its EXIF orientation, which would be stripped otherwise,
+// unless NoAutoRotate is set. The original buffer is returned if it's
+// already smaller, as long as the image type and orientation are the same.
+// The transformation options (size, crop, effects...) are ignored.
+func Optimize(buf []byte, o Options) ([]byte, error) {
+	defer C.vips_thread_shutdown()
+
+	if len(buf) == 0 {
+		return nil, errors.New("Image buffer is empty")
+	}
+
+	image, imageType, err := vipsRead(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Compression == 0 {
+		o.Compression = 9
+	}
+	o = applyDefaults(o, imageType)
+
+	if !IsTypeSupportedSave(o.Type) {
+		C.g_object_unref(C.gpointer(image))
+		return nil, errors.New("Unsupported image output type")
+	}
+
+	image, rotated, err := rotateAndFlipImage(image, Options{NoAutoRotate: o.NoAutoRotate})
+	if err != nil {
+		return nil, err
+	}
+
+	// The orientation is already applied to the pixels
+	vipsRemoveOrientation(image)
+
+	optimized, err := saveImage(image, o)
+	if err != nil {
+		return nil, err
+	}
+
+	if !rotated && o.Type == imageType && len(optimized) >= len(buf) {
+		return buf, nil
+	}
+
+	return optimized, nil
+}
+
+// CropToAspect crops the largest region of the image matching the given aspect
+// ratio (e.g: 16:9 or 1:1), positioned according to the given gravity, without
+// resizing it. The image is auto rotated based on its EXIF orientation first,
+// so the aspect ratio applies to the image as it's displayed.
+func CropToAspect(buf []byte, wRatio, hRatio int, gravity Gravity) ([]byte, error) {
+	defer C.vips_thread_shutdown()
+
+	if wRatio <= 0 || hRatio <= 0 {
+		return nil, errors.New("Aspect ratio must be greater than zero")
+	}
+
+	image, imageType, err := vipsRead(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	image, _, err = rotateAndFlipImage(image, Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	inWidth, inHeight := int(image.Xsize), int(image.Ysize)
+	width, height := calculateAspectCrop(inWidth, inHeight, wRatio, hRatio)
+	left, top := calculateCrop(inWidth, inHeight, width, height, gravity)
+	if gravity == GravitySmart {
+		x, y, err := vipsSmartCropAttention(image, width, height)
+		if err != nil {
+			C.g_object_unref(C.gpointer(image))
+			return nil, err
+		}
+		left, top = clamp(x-width/2, 0, inWidth-width), clamp(y-height/2, 0, inHeight-height)
+	}
+
+	image, err = vipsExtract(image, left, top, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	// The orientation is already applied to the pixels
+	vipsRemoveOrientation(image)
+
+	o := applyDefaults(Options{}, imageType)
+	return saveImage(image, o)
+}
+
+// RemoveLetterbox crops the solid color bars (letterboxing and pillarboxing)
+// along the edges of the given image, e.g: the black bars of the video frames.
+// Unlike Trim, only the full rows and columns of a uniform color are removed,
+// so the content itself is never cropped. The tolerance defines the maximum
+// difference, in 8 bits levels, of the pixels of the bars (TrimThreshold if 0).
+// The image is auto rotated based on its EXIF orientation first.
+func RemoveLetterbox(buf []byte, tolerance float64) ([]byte, error) {
+	defer C.vips_thread_shutdown()
+
+	if tolerance < 0 {
+		return nil, errors.New("Letterbox tolerance must be positive")
+	}
+	if tolerance == 0 {
+		tolerance = TrimThreshold
+	}
+
+	image, imageType, err := vipsRead(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	image, _, err = rotateAndFlipImage(image, Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	// The tolerance is defined in 8 bits levels
+	if image.BandFmt == C.VIPS_FORMAT_USHORT {
+		tolerance *= 257
+	}
+
+	left, top, cropWidth, cropHeight, err := calculateLetterbox(image, tolerance)
+	if err != nil {
+		C.g_object_unref(C.gpointer(image))
+		return nil, err
+	}
+	if cropWidth != int(image.Xsize) || cropHeight != int(image.Ysize) {
+		image, err = vipsExtract(image, left, top, cropWidth, cropHeight)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The orientation is already applied to the pixels
+	vipsRemoveOrientation(image)
+
+	o := applyDefaults(Options{}, imageType)
+	return saveImage(image, o)
+}
+
+// calculateLetterbox returns the region of the given image left once the
+// uniform rows and columns along the edges are removed. Each edge is a bar
+// if its first line is uniform in every colour band, and while the following
+// lines are uniform with the same color, within the given tolerance.
+func calculateLetterbox(image *C.VipsImage, tolerance float64) (left, top, cropWidth, cropHeight int, err error) {
+	width, height := int(image.Xsize), int(image.Ysize)
+
+	// uniform returns the color of the given area if it's uniform
+	uniform := func(left, top, width, height int) ([]float64, bool) {
+		if err != nil {
+			return nil, false
+		}
+		var means, deviations []float64
+		means, deviations, err = vipsAreaStats(image, left, top, width, height)
+		for _, deviation := range deviations {
+			if deviation > tolerance {
+				return nil, false
+			}
+		}
+		return means, err == nil
+	}
+
+	// bar returns the number of uniform lines from the first given line
+	bar := func(count int, line func(i int) ([]float64, bool)) int {
+		reference, ok := line(0)
+		if !ok {
+			return 0
+		}
+		n := 1
+		for ; n < count; n++ {
+			color, ok := line(n)
+			if !ok || !similarColor(color, reference, tolerance) {
+				break
+			}
+		}
+		return n
+	}
+
+	top = bar(height, func(i int) ([]float64, bool) { return uniform(0, i, width, 1) })
+	bottom := bar(height, func(i int) ([]float64, bool) { return uniform(0, height-1-i, width, 1) })
+	if err != nil || top+bottom >= height {
+		return 0, 0, width, height, err
+	}
+
+	rows := height - top - bottom
+	left = bar(width, func(i int) ([]float64, bool) { return uniform(i, top, 1, rows) })
+	right := bar(width, func(i int) ([]float64, bool) { return uniform(width-1-i, top, 1, rows) })
+	if err != nil || left+right >= width {
+		return 0, 0, width, height, err
+	}
+
+	return left, top, width - left - right, rows, nil
+}
+
+// similarColor returns true if every band of the given colors differs less
+// than the given tolerance.
+func similarColor(a, b []float64, tolerance float64) bool {
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+// calculateAspectCrop returns the size of the largest region
+// of the given image size matching the given aspect ratio.
+func calculateAspectCrop(inWidth, inHeight, wRatio, hRatio int) (int, int) {
+	if inWidth*hRatio > inHeight*wRatio {
+		return int(math.Max(float64(inHeight*wRatio/hRatio), 1)), inHeight
+	}
+	return inWidth, int(math.Max(float64(inWidth*hRatio/wRatio), 1))
+}
+
+// Resize is used to transform a given image as byte buffer
+// with the passed options.
+func Resize(buf []byte, o Options) ([]byte, error) {
+	defer C.vips_thread_shutdown()
+
+	if o.DisableCache {
+		defer vipsDisableCache()()
+	}
+
+	image, o, _, err := resizeImage(buf, o)
+	if err != nil {
+		return nil, err
+	}
+
+	// Finally get the resultant buffer
+	return saveImage(image, o)
+}
+
+// Convert converts the given image buffer into the given image type, which
+// must be supported for saving, processing it with the given options as
+// Resize does. The alpha channel, if any, is flattened over the Background
+// color (black, unless defined) when the target image type cannot store it
+// (e.g: JPEG), instead of leaving the transparent pixels to the encoder. As
+// usual, the metadata is stripped, except the ICC profile unless NoProfile
+// is set.
+func Convert(buf []byte, target ImageType, o Options) ([]byte, error) {
+	defer C.vips_thread_shutdown()
+
+	if !IsTypeSupportedSave(target) {
+		return nil, errors.New("Unsupported image output type")
+	}
+
+	if o.DisableCache {
+		defer vipsDisableCache()()
+	}
+
+	o.Type = target
+	image, o, _, err := resizeImage(buf, o)
+	if err != nil {
+		return nil, err
+	}
+
+	if !typeSupportsAlpha(target) {
+		flatten, err := vipsFlattenBackground(image, o.Background)
+		if err != nil {
+			C.g_object_unref(C.gpointer(image))
+			return nil, err
+		}
+		image = flatten
+	}
+
+	return saveImage(image, o)
+}
+
+// ProcessResponsive processes the given image buffer with the given options
+// as Resize does, but decoding and transforming it only once, and encodes the
+// result both as WebP and as the fallback for the clients not supporting it
+// (e.g: for the HTML picture element): PNG if the image has an alpha channel,
+// otherwise JPEG. The Type option is ignored, while Quality, if defined, is
+// used for both images.
+func ProcessResponsive(buf []byte, o Options) (webp []byte, fallback []byte, err error) {
+	defer C.vips_thread_shutdown()
+
+	if !IsTypeSupportedSave(WEBP) {
+		return nil, nil, errors.New("Unsupported image output type")
+	}
+
+	if o.DisableCache {
+		defer vipsDisableCache()()
+	}
+
+	quality := o.Quality
+	o.Type = WEBP
+	image, o, _, err := resizeImage(buf, o)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fallbackOptions := o
+	fallbackOptions.Type, fallbackOptions.Quality = JPEG, quality
+	if vipsHasAlpha(image) {
+		fallbackOptions.Type = PNG
+	}
+	fallbackOptions = applyDefaults(fallbackOptions, fallbackOptions.Type)
+
+	// saveImage releases its input image, hence keep a reference for the fallback
+	C.g_object_ref(C.gpointer(image))
+	webp, err = saveImage(image, o)
+	if err != nil {
+		C.g_object_unref(C.gpointer(image))
+		return nil, nil, err
+	}
+
+	fallback, err = saveImage(image, fallbackOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return webp, fallback, nil
+}
+
+// ResizeOperation represents a resize operation chosen to process an image.
+type ResizeOperation int
+
+const (
+	// ResizeThumbnail represents the libvips thumbnail fast path.
+	ResizeThumbnail ResizeOperation = iota
+	// ResizeShrinkOnLoad represents the libjpeg shrink-on-load.
+	ResizeShrinkOnLoad
+	// ResizeShrink represents the integral block shrink.
+	ResizeShrink
+	// ResizeAffine represents the residual affine transformation.
+	ResizeAffine
+	// ResizeReduce represents the libvips resize, used with UseResize, or to
+	// enlarge the images with SmoothUpscale.
+	ResizeReduce
+	// ResizeZoom represents the zoom (pixel replication) enlargement.
+	ResizeZoom
+)
+
+var resizeOperations = map[ResizeOperation]string{
+	ResizeThumbnail:    "thumbnail",
+	ResizeShrinkOnLoad: "shrink-on-load",
+	ResizeShrink:       "shrink",
+	ResizeAffine:       "affine",
+	ResizeReduce:       "resize",
+	ResizeZoom:         "zoom",
+}
+
+func (r ResizeOperation) String() string {
+	return resizeOperations[r]
+}
+
+// ResizeStep represents a resize operation with its factor: the shrink
+// (reduction) factor for the thumbnail, shrink-on-load, shrink and resize
+// operations, the residual scale for the affine one and the zoom level.
+// Kernel is the resampling kernel used by the resize operation only.
+type ResizeStep struct {
+	Operation ResizeOperation
+	Factor    float64
+	Kernel    Kernel
+}
+
+// ResizePlan represents the resize operations chosen to process an image,
+// in order, with the overall reduction factor (input size / output size).
+// An image processed with no resize operation at all has no steps.
+type ResizePlan struct {
+	Factor float64
+	Steps  []ResizeStep
+}
+
+// ResizeExplain transforms the given image like Resize, additionally
+// returning the resize operations chosen for it, with their factors.
+func ResizeExplain(buf []byte, o Options) ([]byte, ResizePlan, error) {
+	output, info, err := resizeWithMeta(buf, o)
+	return output, info.Plan, err
+}
+
+func resizeWithMeta(buf []byte, o Options) ([]byte, ImageInfo, error) {
+	defer C.vips_thread_shutdown()
+
+	if o.DisableCache {
+		defer vipsDisableCache()()
+	}
+
+	image, o, info, err := resizeImage(buf, o)
+	if err != nil {
+		return nil, info, err
+	}
+
+	buf, err = saveImage(image, o)
+	if err != nil {
+		return nil, info, err
+	}
+
+	return buf, info, nil
+}
+
+// resizeImage runs the transformation pipeline over the given buffer and
+// returns the resultant libvips image, not encoded yet, plus the options
+// after applying the defaults and the input/output image details.
+func resizeImage(buf []byte, o Options) (*C.VipsImage, Options, ImageInfo, error) {
+	var info ImageInfo
+
+	if len(buf) == 0 {
+		return nil, o, info, errors.New("Image buffer is empty")
+	}
+
+	if o.Focus != nil && (o.Focus.X < 0 || o.Focus.X > 1 || o.Focus.Y < 0 || o.Focus.Y > 1) {
+		return nil, o, info, errors.New("Focus point coordinates must be between 0 and 1")
+	}
+
+	if o.TargetWidth < 0 || o.MaxHeight < 0 {
+		return nil, o, info, errors.New("Target width and maximum height must be positive")
+	}
+
+	if o.ShrinkOnLoad != 0 && o.ShrinkOnLoad != 1 && o.ShrinkOnLoad != 2 && o.ShrinkOnLoad != 4 && o.ShrinkOnLoad != 8 {
+		return nil, o, info, errors.New("Shrink on load factor must be 1, 2, 4 or 8")
+	}
+
+	if o.FitPad && (o.Width == 0 || o.Height == 0) {
+		return nil, o, info, errors.New("FitPad requires both width and height")
+	}
+
+	inputType := o.InputType
+	if inputType == UNKNOWN {
+		inputType = vipsImageType(buf)
+	}
+
+	image, imageType, err := vipsReadType(buf, o.InputType, loadOptions(o, inputType))
+	if err != nil {
+		return nil, o, info, err
+	}
+
+	// Static previews are encoded as JPEG, unless defined otherwise
+	if o.FirstFrame && o.Type == 0 {
+		o.Type = JPEG
+	}
+
+	// Keep the grayscale images interpretation, if required
+	o = keepInterpretation(o, image)
+
 	// Clone and define default options
+	qualityDefined := o.Quality != 0
 	o = applyDefaults(o, imageType)
 
+	info.InputType = imageType
+	info.OutputType = o.Type
+	info.InputSize = ImageSize{Width: int(image.Xsize), Height: int(image.Ysize)}
+
 	if IsTypeSupported(o.Type) == false {
-		return nil, errors.New("Unsupported image output type")
+		return nil, o, info, errors.New("Unsupported image output type")
+	}
+
+	if o.FitPad {
+		o.Embed, o.Extend = true, ExtendBackground
+		o.Crop, o.Force = false, false
+	}
+
+	if o.MaxMemory > 0 && estimateMemory(image, o) > int64(o.MaxMemory) {
+		C.g_object_unref(C.gpointer(image))
+		return nil, o, info, errors.New("Image exceeds the memory limit")
 	}
 
 	debug("Options: %#v", o)
 
+	// Transform every frame of the animated images, if required
+	if shouldPreserveAnimation(o, imageType) && vipsPageHeight(image) < int(image.Ysize) {
+		image, o, err = transformAnimation(image, buf, imageType, o, &info)
+	} else {
+		image, o, err = processImage(image, buf, imageType, o, &info)
+	}
+	if err != nil {
+		return nil, o, info, err
+	}
+
+	// Save the rotated JPEG images with the maximum quality, unless defined
+	if o.HighQualityRotate && !qualityDefined {
+		o.Quality = 100
+	}
+
+	info.OutputSize = ImageSize{Width: int(image.Xsize), Height: int(image.Ysize)}
+
+	return image, o, info, nil
+}
+
+// processImage resizes, transforms and applies the effects to the given image
+// once read, returning the resultant image plus the updated options.
+func processImage(image *C.VipsImage, buf []byte, imageType ImageType, o Options, info *ImageInfo) (*C.VipsImage, Options, error) {
+	var err error
+
+	// Use the libvips thumbnail fast path, if possible
+	if shouldUseThumbnail(o) {
+		C.g_object_unref(C.gpointer(image))
+		image, err = vipsThumbnail(buf, o.Width, o.Height, o.Gravity, o.NoAutoRotate, o.Enlarge)
+		if err == nil {
+			factor := float64(info.InputSize.Width) / float64(image.Xsize)
+			info.Plan = ResizePlan{Factor: factor, Steps: []ResizeStep{{Operation: ResizeThumbnail, Factor: factor}}}
+		}
+	} else {
+		image, o, err = transformPipeline(image, buf, imageType, o, &info.Plan)
+	}
+	if err != nil {
+		return nil, o, err
+	}
+
+	// Flatten the first frame alpha channel for static previews
+	if o.FirstFrame {
+		image, err = vipsFlattenBackground(image, o.Background)
+		if err != nil {
+			return nil, o, err
+		}
+	}
+
+	// Shear image, if necessary
+	image, err = shearImage(image, o)
+	if err != nil {
+		return nil, o, err
+	}
+
+	// Apply perspective transformation, if necessary
+	image, err = perspectiveImage(image, o)
+	if err != nil {
+		return nil, o, err
+	}
+
+	// Sharpen the downscaled image, unless explicitly sharpened
+	if o.AutoSharpen && o.Sharpen == (Sharpen{}) {
+		o.Sharpen = calculateAutoSharpen(info.Plan.Factor)
+	}
+
+	// Apply effects, if necessary
+	if shouldApplyEffects(o) {
+		image, err = applyEffects(image, o)
+		if err != nil {
+			return nil, o, err
+		}
+	}
+
+	// Insert image, if necessary
+	image, err = insertImage(image, o.Insert)
+	if err != nil {
+		return nil, o, err
+	}
+
+	// Add watermark, if necessary
+	image, err = watermarkImage(image, o.Watermark)
+	if err != nil {
+		return nil, o, err
+	}
+
+	// Flatten image on a background, if necessary
+	image, err = imageFlatten(image, imageType, o)
+	if err != nil {
+		return nil, o, err
+	}
+
+	// Convert image into 3 bands sRGB, if necessary
+	if o.ForceRGB {
+		o.Interpretation = InterpretationSRGB
+		image, err = forceRGBImage(image)
+		if err != nil {
+			return nil, o, err
+		}
+	}
+
+	return image, o, nil
+}
+
+func saveImage(image *C.VipsImage, o Options) ([]byte, error) {
+	saveOptions := vipsSaveOptions{
+		Quality:              o.Quality,
+		Type:                 o.Type,
+		Compression:          o.Compression,
+		JPEGProgressive:      o.Interlace || o.JPEGProgressive,
+		PNGInterlace:         o.Interlace || o.PNGInterlace,
+		NoProfile:            o.NoProfile,
+		AssignSRGBProfile:    o.AssignSRGBProfile,
+		OutputProfile:        o.OutputProfile,
+		NoColourspaceConvert: o.NoColourspaceConvert,
+		SkipColourspaceCheck: o.SkipColourspaceCheck,
+		NoSubsample:          o.HighQualityRotate && o.Type == JPEG,
+		QuantTable:           o.QuantTable,
+		RestartInterval:      o.JPEGRestartInterval,
+		Lossless:             o.Lossless,
+		TileWidth:            o.JP2KTileWidth,
+		TileHeight:           o.JP2KTileHeight,
+		PaletteColors:        o.PaletteColors,
+		Dither:               o.Dither,
+		Interpretation:       o.Interpretation,
+	}
+
+	return vipsSave(image, saveOptions)
+}
+
+// transformPipeline rotates, shrinks and resizes the given image, returning
+// the transformed image plus the options updated by the size calculations.
+func transformPipeline(image *C.VipsImage, buf []byte, imageType ImageType, o Options, plan *ResizePlan) (*C.VipsImage, Options, error) {
 	// Auto rotate image based on EXIF orientation header
 	image, rotated, err := rotateAndFlipImage(image, o)
 	if err != nil {
-		return nil, err
+		return nil, o, err
+	}
+
+	// Minimize the quality loss of rotated JPEG images, if required
+	o.HighQualityRotate = o.HighQualityRotate && rotated && imageType == JPEG && o.Type == JPEG
+
+	// Trim the image borders, if required
+	cropped := false
+	if o.Trim || o.TrimAuto {
+		image, cropped, err = trimImage(image, o)
+		if err != nil {
+			return nil, o, err
+		}
+	}
+
+	// Extract the relative image area, if required
+	if o.CropRelative != (CropRelative{}) {
+		var relCropped bool
+		image, relCropped, err = cropRelativeImage(image, o.CropRelative)
+		if err != nil {
+			return nil, o, err
+		}
+		cropped = cropped || relCropped
 	}
 
 	// If JPEG image, retrieve the buffer
 	if rotated && imageType == JPEG && !o.NoAutoRotate {
 		buf, err = getImageBuffer(image)
 		if err != nil {
-			return nil, err
+			return nil, o, err
 		}
 	}
 
 	inWidth := int(image.Xsize)
 	inHeight := int(image.Ysize)
 
+	// Scale down to fit the maximum area, if necessary
+	if o.MaxArea > 0 && o.Width == 0 && o.Height == 0 && inWidth*inHeight > o.MaxArea {
+		o.Width, o.Height = calculateMaxAreaSize(inWidth, inHeight, o.MaxArea)
+	}
+
+	// Fit to the target width, cropping the height overflow, if necessary
+	if o.TargetWidth > 0 {
+		o.Width, o.Height, o.Crop = calculateTargetWidthSize(inWidth, inHeight, o.TargetWidth, o.MaxHeight)
+		o.Enlarge, o.Force, o.Embed = true, false, false
+	}
+
 	// Infer the required operation based on the in/out image sizes for a coherent transformation
 	normalizeOperation(&o, inWidth, inHeight)
 
@@ -114,86 +833,120 @@ func Resize(buf []byte, o Options) ([]byte, error) {
 			factor = 1.0
 			shrink = 1
 			residual = 0
-			o.Width = inWidth
-			o.Height = inHeight
+			// Keep the canvas size to pad the image to
+			if !o.FitPad {
+				o.Width = inWidth
+				o.Height = inHeight
+			}
 		}
 	}
 
-	// Try to use libjpeg shrink-on-load
-	if imageType == JPEG && shrink >= 2 {
-		tmpImage, factor, err := shrinkJpegImage(buf, image, factor, shrink)
+	plan.Factor = factor
+	reduction := factor
+
+	// Try to use libjpeg shrink-on-load, unless loaded with custom load options,
+	// strictly loaded, trimmed or cropped, since the image is loaded again with the default options
+	if imageType == JPEG && (shrink >= 2 || o.ShrinkOnLoad > 1) && o.ShrinkOnLoad != 1 && len(o.LoadOptions) == 0 && !o.StrictLoad && !cropped {
+		tmpImage, shrunkFactor, err := shrinkJpegImage(buf, image, factor, shrink, o.ShrinkOnLoad)
 		if err != nil {
-			return nil, err
+			return nil, o, err
 		}
+		plan.Steps = append(plan.Steps, ResizeStep{Operation: ResizeShrinkOnLoad, Factor: math.Round(factor / shrunkFactor)})
 
+		// A forced shrink-on-load factor may leave the image
+		// smaller than required, hence the residual would enlarge it
 		image = tmpImage
-		factor = math.Max(factor, 1.0)
-		shrink = int(math.Floor(factor))
-		residual = float64(shrink) / factor
+		reduction = shrunkFactor
+		shrink = int(math.Max(math.Floor(shrunkFactor), 1))
+		residual = float64(shrink) / shrunkFactor
 	}
 
 	// Zoom image, if necessary
-	image, err = zoomImage(image, o.Zoom)
+	image, err = zoomImage(image, o)
 	if err != nil {
-		return nil, err
+		return nil, o, err
+	}
+	if o.Zoom > 0 && o.SmoothUpscale {
+		plan.Steps = append(plan.Steps, ResizeStep{ResizeReduce, 1 / float64(o.Zoom+1), o.UpscaleKernel})
+	} else if o.Zoom > 0 {
+		plan.Steps = append(plan.Steps, ResizeStep{Operation: ResizeZoom, Factor: float64(o.Zoom)})
 	}
+	zoomedWidth, zoomedHeight := int(image.Xsize), int(image.Ysize)
 
 	// Transform image, if necessary
 	if shouldTransformImage(o, inWidth, inHeight) {
-		image, err = transformImage(image, o, shrink, residual)
+		plan.Steps = append(plan.Steps, transformSteps(o, inWidth, inHeight, reduction, shrink, residual)...)
+		image, err = premultipliedTransformImage(image, o, shrink, residual)
 		if err != nil {
-			return nil, err
+			return nil, o, err
 		}
 	}
-
-	// Apply effects, if necessary
-	if shouldApplyEffects(o) {
-		image, err = applyEffects(image, o)
+
+	// Scale the image resolution, if necessary
+	if o.ScaleResolution {
+		scalex, scaley := calculateResolutionScale(o, inWidth, inHeight, zoomedWidth, zoomedHeight)
+		image, err = vipsSetResolution(image, float64(image.Xres)*scalex, float64(image.Yres)*scaley)
 		if err != nil {
-			return nil, err
+			return nil, o, err
 		}
 	}
 
-	// Insert image, if necessary
-	image, err = insertImage(image, o.Insert)
-	if err != nil {
-		return nil, err
+	return image, o, nil
+}
+
+// shouldUseThumbnail returns true if the requested transformation is a
+// centred or smart crop that libvips thumbnail can perform in a single step.
+func shouldUseThumbnail(o Options) bool {
+	return o.UseThumbnail && o.Crop && o.Width > 0 && o.Height > 0 &&
+		(o.Gravity == GravityCentre || o.Gravity == GravitySmart) &&
+		o.Rotate == 0 && !o.Flip && !o.Flop && o.Zoom == 0 && o.ShrinkOnLoad == 0 && o.TargetWidth == 0 &&
+		o.AreaWidth == 0 && o.AreaHeight == 0 && o.Top == 0 && o.Left == 0 &&
+		!o.Force && !o.Embed && !o.UseResize && !o.ScaleResolution && len(o.LoadOptions) == 0 && !o.StrictLoad &&
+		!o.Trim && !o.TrimAuto && o.CropRelative == (CropRelative{}) && o.InputType == UNKNOWN && o.Focus == nil
+}
+
+// loadOptions returns the libvips loader options for the given image type,
+// adding the strict loading and the animation frames ones, if required, to
+// the user defined ones.
+func loadOptions(o Options, imageType ImageType) map[string]string {
+	animated := shouldPreserveAnimation(o, imageType)
+	if !o.StrictLoad && !animated {
+		return o.LoadOptions
 	}
 
-	// Add watermark, if necessary
-	image, err = watermarkImage(image, o.Watermark)
-	if err != nil {
-		return nil, err
+	options := make(map[string]string, len(o.LoadOptions)+2)
+	for name, value := range o.LoadOptions {
+		options[name] = value
 	}
 
-	// Flatten image on a background, if necessary
-	image, err = imageFlatten(image, imageType, o)
-	if err != nil {
-		return nil, err
+	// Load all the frames of the animated images
+	if _, ok := options["n"]; animated && !ok {
+		options["n"] = "-1"
 	}
 
-	saveOptions := vipsSaveOptions{
-		Quality:        o.Quality,
-		Type:           o.Type,
-		Compression:    o.Compression,
-		Interlace:      o.Interlace,
-		NoProfile:      o.NoProfile,
-		Interpretation: o.Interpretation,
+	if !o.StrictLoad {
+		return options
 	}
 
-	// Finally get the resultant buffer
-	return vipsSave(image, saveOptions)
+	// Only the JPEG loader supports the fail option before libvips 8.12
+	if vipsVersionAtLeast(8, 12) {
+		options["fail_on"] = "warning"
+	} else if imageType == JPEG {
+		options["fail"] = "true"
+	}
+
+	return options
 }
 
 func applyDefaults(o Options, imageType ImageType) Options {
+	if o.Type == 0 {
+		o.Type = imageType
+	}
 	if o.Quality == 0 {
-		o.Quality = Quality
+		o.Quality = presetQuality(o.Preset, o.Type)
 	}
 	if o.Compression == 0 {
-		o.Compression = 6
-	}
-	if o.Type == 0 {
-		o.Type = imageType
+		o.Compression = presetCompression(o.Preset)
 	}
 	if o.Interpretation == 0 {
 		o.Interpretation = InterpretationSRGB
@@ -201,6 +954,61 @@ func applyDefaults(o Options, imageType ImageType) Options {
 	return o
 }
 
+// keepInterpretation defines the grayscale output interpretation for the
+// grayscale images if KeepInterpretation is set, unless already defined.
+func keepInterpretation(o Options, image *C.VipsImage) Options {
+	if !o.KeepInterpretation || o.Interpretation != 0 {
+		return o
+	}
+	if interpretation := vipsInterpretation(image); interpretation == InterpretationBW || interpretation == InterpretationGREY16 {
+		o.Interpretation = interpretation
+	}
+	return o
+}
+
+// defaultQuality returns the quality used when no explicit
+// quality is defined for the given output image type.
+func defaultQuality(t ImageType) int {
+	switch t {
+	case WEBP:
+		return DefaultWebPQuality
+	case TIFF:
+		return 75
+	default:
+		return DefaultJPEGQuality
+	}
+}
+
+// presetQualities defines the quality of the lossy image types
+// for the low, medium, high and max presets.
+var presetQualities = map[ImageType][4]int{
+	JPEG: {60, 75, 85, 95},
+	TIFF: {60, 75, 85, 95},
+	WEBP: {50, 70, 80, 90},
+}
+
+// presetCompressions defines the PNG compression level
+// for the low, medium, high and max presets.
+var presetCompressions = [4]int{9, 6, 4, 1}
+
+// presetQuality returns the quality used for the given preset
+// and output image type, falling back to the default quality.
+func presetQuality(p QualityPreset, t ImageType) int {
+	if qualities, ok := presetQualities[t]; ok && p >= PresetLow && p <= PresetMax {
+		return qualities[p-PresetLow]
+	}
+	return defaultQuality(t)
+}
+
+// presetCompression returns the compression level used for the
+// given preset, falling back to the default compression level.
+func presetCompression(p QualityPreset) int {
+	if p >= PresetLow && p <= PresetMax {
+		return presetCompressions[p-PresetLow]
+	}
+	return DefaultPNGCompression
+}
+
 func normalizeOperation(o *Options, inWidth, inHeight int) {
 	if !o.Force && !o.Crop && !o.Embed && !o.Enlarge && o.Rotate == 0 && (o.Width > 0 || o.Height > 0) {
 		o.Force = true
@@ -213,12 +1021,82 @@ func shouldTransformImage(o Options, inWidth, inHeight int) bool {
 }
 
 func shouldApplyEffects(o Options) bool {
-	return o.GaussianBlur.Sigma > 0 || o.GaussianBlur.MinAmpl > 0 || o.Sharpen.Radius > 0 && o.Sharpen.Y2 > 0 || o.Sharpen.Y3 > 0
+	return o.GaussianBlur.Sigma > 0 || o.GaussianBlur.MinAmpl > 0 || o.Sharpen.Radius > 0 && o.Sharpen.Y2 > 0 || o.Sharpen.Y3 > 0 || o.ColorAdjust != (ColorAdjust{})
+}
+
+// transformSteps returns the resize steps transformImage runs, where factor
+// is the reduction left to apply after the shrink-on-load, if any.
+func transformSteps(o Options, inWidth, inHeight int, factor float64, shrink int, residual float64) []ResizeStep {
+	// Enlarged images are resized with the UpscaleKernel, if required
+	enlarged := residual > 1
+	if o.Force {
+		enlarged = o.Width > inWidth || o.Height > inHeight
+	}
+	kernel := o.Kernel
+	if o.SmoothUpscale && enlarged {
+		kernel = o.UpscaleKernel
+	}
+
+	if o.UseResize {
+		return []ResizeStep{{ResizeReduce, factor, kernel}}
+	}
+
+	var steps []ResizeStep
+	if shrink > 1 {
+		steps = append(steps, ResizeStep{Operation: ResizeShrink, Factor: float64(shrink)})
+	}
+	if o.SmoothUpscale && enlarged {
+		steps = append(steps, ResizeStep{ResizeReduce, 1 / residual, kernel})
+	} else if o.Force || residual != 0 {
+		steps = append(steps, ResizeStep{Operation: ResizeAffine, Factor: residual})
+	}
+	return steps
+}
+
+// antiAliasImage blurs the image with the AntiAliasSigma, or with a sigma
+// proportional to the given downscale factor when not defined, replicating
+// the edge pixels so the image borders are not darkened.
+func antiAliasImage(image *C.VipsImage, o Options, scale float64) (*C.VipsImage, error) {
+	sigma := o.AntiAliasSigma
+	if sigma == 0 && scale > 0 && scale < 1 {
+		sigma = (1/scale - 1) / 2
+	}
+	if sigma < minAntiAliasSigma {
+		return image, nil
+	}
+
+	return vipsGaussianBlur(image, GaussianBlur{Sigma: sigma, Edge: ExtendCopy}, o.Background)
+}
+
+// premultipliedTransformImage transforms the image with its colors
+// premultiplied by its alpha channel, if any, unless NoPremultiplyAlpha is
+// set, so the transparent pixels colors do not bleed into the edges.
+func premultipliedTransformImage(image *C.VipsImage, o Options, shrink int, residual float64) (*C.VipsImage, error) {
+	if o.NoPremultiplyAlpha || !vipsHasAlpha(image) || !vipsVersionAtLeast(8, 1) {
+		return transformImage(image, o, shrink, residual)
+	}
+
+	format := image.BandFmt
+	image, err := vipsPremultiply(image)
+	if err != nil {
+		return nil, err
+	}
+
+	image, err = transformImage(image, o, shrink, residual)
+	if err != nil {
+		return nil, err
+	}
+
+	return vipsUnpremultiply(image, format)
 }
 
 func transformImage(image *C.VipsImage, o Options, shrink int, residual float64) (*C.VipsImage, error) {
 	var err error
 
+	if o.UseResize {
+		return resizeTransformImage(image, o)
+	}
+
 	// Use vips_shrink with the integral reduction
 	if shrink > 1 {
 		image, residual, err = shrinkImage(image, o, residual, shrink)
@@ -233,7 +1111,20 @@ func transformImage(image *C.VipsImage, o Options, shrink int, residual float64)
 		residualy = float64(o.Height) / float64(image.Ysize)
 	}
 
-	if o.Force || residual != 0 {
+	// Blur the image before reducing it, if required, to prevent the aliasing
+	if o.AntiAlias && (o.Force || residual != 0) {
+		image, err = antiAliasImage(image, o, math.Min(residualx, residualy))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if o.SmoothUpscale && (residualx > 1 || residualy > 1) {
+		image, err = vipsResize(image, residualx, residualy, o.UpscaleKernel)
+		if err != nil {
+			return nil, err
+		}
+	} else if o.Force || residual != 0 {
 		image, err = vipsAffine(image, residualx, residualy, o.Interpolator)
 		if err != nil {
 			return nil, err
@@ -256,11 +1147,88 @@ func transformImage(image *C.VipsImage, o Options, shrink int, residual float64)
 	return image, nil
 }
 
+func resizeTransformImage(image *C.VipsImage, o Options) (*C.VipsImage, error) {
+	var err error
+
+	scalex := float64(o.Width) / float64(image.Xsize)
+	scaley := float64(o.Height) / float64(image.Ysize)
+
+	if !o.Force {
+		if o.Crop {
+			scalex = math.Max(scalex, scaley)
+		} else {
+			scalex = math.Min(scalex, scaley)
+		}
+		// Smaller images are padded at their size, unless enlarged
+		if o.FitPad && !o.Enlarge {
+			scalex = math.Min(scalex, 1)
+		}
+		scaley = scalex
+	}
+
+	kernel := o.Kernel
+	if o.SmoothUpscale && (scalex > 1 || scaley > 1) {
+		kernel = o.UpscaleKernel
+	}
+
+	// Use vips_resize in a single step
+	if scalex != 1 || scaley != 1 {
+		image, err = vipsResize(image, scalex, scaley, kernel)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if o.Force {
+		o.Crop = false
+		o.Embed = false
+	}
+
+	image, err = extractOrEmbedImage(image, o)
+	if err != nil {
+		return nil, err
+	}
+
+	debug("Transform: scalex=%v, scaley=%v, kernel=%v", scalex, scaley, kernel.String())
+
+	return image, nil
+}
+
+func shearImage(image *C.VipsImage, o Options) (*C.VipsImage, error) {
+	if o.Shear.X == 0 && o.Shear.Y == 0 {
+		return image, nil
+	}
+	if math.Abs(o.Shear.X) >= 90 || math.Abs(o.Shear.Y) >= 90 {
+		return nil, errors.New("Shear angles must be between -90 and 90 degrees")
+	}
+
+	debug("Shear: x=%v, y=%v", o.Shear.X, o.Shear.Y)
+
+	return vipsShear(image, o.Shear, o.Background, o.Interpolator)
+}
+
+func perspectiveImage(image *C.VipsImage, o Options) (*C.VipsImage, error) {
+	if o.Perspective == (Perspective{}) {
+		return image, nil
+	}
+
+	// Map destination points into source points, as libvips works
+	// by looking up every output pixel in the input image
+	matrix, err := calculateHomography(o.Perspective.Destination, o.Perspective.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	debug("Perspective: matrix=%v", matrix)
+
+	return vipsPerspective(image, matrix, o.Interpolator)
+}
+
 func applyEffects(image *C.VipsImage, o Options) (*C.VipsImage, error) {
 	var err error
 
 	if o.GaussianBlur.Sigma > 0 || o.GaussianBlur.MinAmpl > 0 {
-		image, err = vipsGaussianBlur(image, o.GaussianBlur)
+		image, err = vipsGaussianBlur(image, o.GaussianBlur, o.Background)
 		if err != nil {
 			return nil, err
 		}
@@ -273,6 +1241,13 @@ func applyEffects(image *C.VipsImage, o Options) (*C.VipsImage, error) {
 		}
 	}
 
+	if o.ColorAdjust != (ColorAdjust{}) {
+		image, err = vipsColorAdjust(image, o.ColorAdjust)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	debug("Effects: gaussSigma=%v, gaussMinAmpl=%v, sharpenRadius=%v",
 		o.GaussianBlur.Sigma, o.GaussianBlur.MinAmpl, o.Sharpen.Radius)
 
@@ -289,12 +1264,22 @@ func extractOrEmbedImage(image *C.VipsImage, o Options) (*C.VipsImage, error) {
 		width := int(math.Min(float64(inWidth), float64(o.Width)))
 		height := int(math.Min(float64(inHeight), float64(o.Height)))
 		left, top := calculateCrop(inWidth, inHeight, o.Width, o.Height, o.Gravity)
+		if o.Gravity == GravitySmart {
+			x, y, err := vipsSmartCropAttention(image, width, height)
+			if err != nil {
+				return nil, err
+			}
+			left, top = clamp(x-width/2, 0, inWidth-width), clamp(y-height/2, 0, inHeight-height)
+		}
+		if o.Focus != nil {
+			left, top = calculateFocusCrop(inWidth, inHeight, width, height, *o.Focus)
+		}
 		left, top = int(math.Max(float64(left), 0)), int(math.Max(float64(top), 0))
 		image, err = vipsExtract(image, left, top, width, height)
 		break
 	case o.Embed:
 		left, top := (o.Width-inWidth)/2, (o.Height-inHeight)/2
-		image, err = vipsEmbed(image, left, top, o.Width, o.Height, o.Extend)
+		image, err = vipsEmbed(image, left, top, o.Width, o.Height, o.Extend, o.Background)
 		break
 	case o.Top != 0 || o.Left != 0:
 		if o.AreaWidth == 0 {
@@ -313,6 +1298,139 @@ func extractOrEmbedImage(image *C.VipsImage, o Options) (*C.VipsImage, error) {
 	return image, err
 }
 
+// cropRelativeImage extracts the given relative area of the image, clamped
+// to the image bounds, returning whether the image was cropped at all.
+func cropRelativeImage(image *C.VipsImage, area CropRelative) (*C.VipsImage, bool, error) {
+	inWidth, inHeight := int(image.Xsize), int(image.Ysize)
+	left, top, width, height := calculateCropRelative(inWidth, inHeight, area)
+	if width == inWidth && height == inHeight {
+		return image, false, nil
+	}
+
+	image, err := vipsExtract(image, left, top, width, height)
+	if err != nil {
+		return nil, false, err
+	}
+	return image, true, nil
+}
+
+// calculateCropRelative converts the given relative area into pixels of an
+// image of the given size, clamping it to the image bounds and keeping at
+// least one pixel in each dimension.
+func calculateCropRelative(inWidth, inHeight int, area CropRelative) (left, top, width, height int) {
+	left, width = relativeSpan(inWidth, area.Left, area.Width)
+	top, height = relativeSpan(inHeight, area.Top, area.Height)
+	return left, top, width, height
+}
+
+// relativeSpan converts the given relative offset and size into pixels of
+// the given length. A zero size spans up to the end.
+func relativeSpan(length int, offset, size float64) (int, int) {
+	start := clamp(int(math.Round(offset*float64(length))), 0, length-1)
+	end := length
+	if size > 0 {
+		end = clamp(int(math.Round((offset+size)*float64(length))), start+1, length)
+	}
+	return start, end - start
+}
+
+// trimImage removes the image borders of the background color, either the
+// given one or, in auto mode, the one sampled from the image corners.
+func trimImage(image *C.VipsImage, o Options) (*C.VipsImage, bool, error) {
+	background := [3]float64{float64(o.TrimBackground.R), float64(o.TrimBackground.G), float64(o.TrimBackground.B)}
+	threshold := o.TrimThreshold
+	if threshold == 0 {
+		threshold = TrimThreshold
+	}
+
+	// 16 bits images use the 0-65535 range
+	scale := 1.0
+	if interpretation := vipsInterpretation(image); interpretation == InterpretationRGB16 || interpretation == InterpretationGREY16 {
+		scale = 256
+	}
+
+	if o.TrimAuto {
+		corners, err := trimCorners(image)
+		if err != nil {
+			return nil, false, err
+		}
+
+		var uniform bool
+		background, threshold, uniform = calculateTrimThreshold(corners, scale)
+		if !uniform {
+			return image, false, nil
+		}
+	} else {
+		for i := range background {
+			background[i] *= scale
+		}
+		threshold *= scale
+	}
+
+	left, top, width, height, err := vipsFindTrim(image, background, threshold)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Leave as it is the image fully made of background or with no border at all
+	if width == 0 || height == 0 || (width == int(image.Xsize) && height == int(image.Ysize)) {
+		return image, false, nil
+	}
+
+	image, err = vipsExtract(image, left, top, width, height)
+	return image, err == nil, err
+}
+
+// trimCorners reads the pixels of the four image corners.
+func trimCorners(image *C.VipsImage) ([4][3]float64, error) {
+	var corners [4][3]float64
+	right, bottom := int(image.Xsize)-1, int(image.Ysize)-1
+
+	for i, point := range [4][2]int{{0, 0}, {right, 0}, {0, bottom}, {right, bottom}} {
+		pixel, err := vipsGetPoint(image, point[0], point[1])
+		if err != nil {
+			return corners, err
+		}
+		corners[i] = pixel
+	}
+
+	return corners, nil
+}
+
+// calculateTrimThreshold infers the trim background color, as the average of
+// the corner pixels, and threshold, from how much the corners differ from it.
+// Corners that differ too much to share a background are reported as not uniform.
+func calculateTrimThreshold(corners [4][3]float64, scale float64) ([3]float64, float64, bool) {
+	var background [3]float64
+	for _, corner := range corners {
+		for i, value := range corner {
+			background[i] += value / float64(len(corners))
+		}
+	}
+
+	deviation := 0.0
+	for _, corner := range corners {
+		for i, value := range corner {
+			deviation = math.Max(deviation, math.Abs(value-background[i]))
+		}
+	}
+
+	if deviation > maxTrimDeviation*scale {
+		return background, 0, false
+	}
+
+	return background, math.Max(TrimThreshold*scale, 2*deviation), true
+}
+
+// calculateFocusCrop returns the top-left corner of the crop area centred on
+// the given focal point, relative to the image size, clamped to the image
+// bounds. An undefined (zero) coordinate centres the crop along its axis.
+func calculateFocusCrop(inWidth, inHeight, width, height int, focus Point) (int, int) {
+	left := int(math.Round(focus.X*float64(inWidth) - float64(width)/2))
+	top := int(math.Round(focus.Y*float64(inHeight) - float64(height)/2))
+	return clamp(left, 0, inWidth-width), clamp(top, 0, inHeight-height)
+}
+
 func rotateAndFlipImage(image *C.VipsImage, o Options) (*C.VipsImage, bool, error) {
 	var err error
 	var rotated bool
@@ -375,7 +1493,7 @@ func insertImage(image *C.VipsImage, i Insert) (*C.VipsImage, error) {
 	}
 
 	// Transform input image to the size of the final image with a custom position
-	input, err := vipsEmbed(inputTmp, i.Left, i.Top, int(image.Xsize), int(image.Ysize), 0)
+	input, err := vipsEmbed(inputTmp, i.Left, i.Top, int(image.Xsize), int(image.Ysize), ExtendBlack, ColorBlack)
 	if err != nil {
 		return nil, err
 	}
@@ -490,6 +1608,18 @@ func watermarkImage(image *C.VipsImage, w Watermark) (*C.VipsImage, error) {
 		return image, nil
 	}
 
+	if w.MarginTop < 0 || w.MarginRight < 0 || w.MarginBottom < 0 || w.MarginLeft < 0 {
+		C.g_object_unref(C.gpointer(image))
+		return nil, errors.New("Watermark margins must be positive")
+	}
+
+	if w.FontFile != "" {
+		if _, err := os.Stat(w.FontFile); err != nil {
+			C.g_object_unref(C.gpointer(image))
+			return nil, errors.New("Watermark font file not found")
+		}
+	}
+
 	// Defaults
 	if w.Font == "" {
 		w.Font = WatermarkFont
@@ -509,6 +1639,8 @@ func watermarkImage(image *C.VipsImage, w Watermark) (*C.VipsImage, error) {
 		w.Opacity = 1
 	}
 
+	w.Text = watermarkText(w.Text, w.RTL)
+
 	image, err := vipsWatermark(image, w)
 	if err != nil {
 		return nil, err
@@ -517,7 +1649,34 @@ func watermarkImage(image *C.VipsImage, w Watermark) (*C.VipsImage, error) {
 	return image, nil
 }
 
+// watermarkText normalizes the line breaks of the given watermark text, and
+// starts every line with a right-to-left mark if rtl is set, since pango
+// detects the direction of each line (paragraph) from its first strong
+// character.
+func watermarkText(text string, rtl bool) string {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	text = strings.Replace(text, "\r", "\n", -1)
+
+	if rtl {
+		lines := strings.Split(text, "\n")
+		for i, line := range lines {
+			lines[i] = "\u200f" + line
+		}
+		text = strings.Join(lines, "\n")
+	}
+
+	return text
+}
+
 func imageFlatten(image *C.VipsImage, imageType ImageType, o Options) (*C.VipsImage, error) {
+	// Keep the alpha channel if the background is semi-transparent
+	if o.BackgroundAlpha != nil {
+		if typeSupportsAlpha(o.Type) {
+			return vipsFlattenBackgroundAlpha(image, *o.BackgroundAlpha)
+		}
+		return vipsFlattenBackground(image, Color{o.BackgroundAlpha.R, o.BackgroundAlpha.G, o.BackgroundAlpha.B})
+	}
+
 	// Only PNG images are supported for now
 	if imageType != PNG || o.Background == ColorBlack {
 		return image, nil
@@ -526,11 +1685,36 @@ func imageFlatten(image *C.VipsImage, imageType ImageType, o Options) (*C.VipsIm
 	return vipsFlattenBackground(image, o.Background)
 }
 
-func zoomImage(image *C.VipsImage, zoom int) (*C.VipsImage, error) {
-	if zoom == 0 {
+func forceRGBImage(image *C.VipsImage) (*C.VipsImage, error) {
+	var err error
+
+	if vipsInterpretation(image) != InterpretationSRGB {
+		image, err = vipsColourspace(image, InterpretationSRGB)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Drop the alpha channel, keeping the colour bands untouched
+	if image.Bands > 3 {
+		image, err = vipsExtractBand(image, 0, 3)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return image, nil
+}
+
+func zoomImage(image *C.VipsImage, o Options) (*C.VipsImage, error) {
+	if o.Zoom == 0 {
 		return image, nil
 	}
-	return vipsZoom(image, zoom+1)
+	if o.SmoothUpscale {
+		scale := float64(o.Zoom + 1)
+		return vipsResize(image, scale, scale, o.UpscaleKernel)
+	}
+	return vipsZoom(image, o.Zoom+1)
 }
 
 func shrinkImage(image *C.VipsImage, o Options, residual float64, shrink int) (*C.VipsImage, float64, error) {
@@ -553,13 +1737,16 @@ func shrinkImage(image *C.VipsImage, o Options, residual float64, shrink int) (*
 	return image, residual, nil
 }
 
-func shrinkJpegImage(buf []byte, input *C.VipsImage, factor float64, shrink int) (*C.VipsImage, float64, error) {
+func shrinkJpegImage(buf []byte, input *C.VipsImage, factor float64, shrink int, forceShrink int) (*C.VipsImage, float64, error) {
 	var image *C.VipsImage
 	var err error
 	shrinkOnLoad := 1
 
 	// Recalculate integral shrink and double residual
 	switch {
+	case forceShrink > 1:
+		factor = factor / float64(forceShrink)
+		shrinkOnLoad = forceShrink
 	case shrink >= 8:
 		factor = factor / 8
 		shrinkOnLoad = 8
@@ -610,6 +1797,76 @@ func imageCalculations(o *Options, inWidth, inHeight int) float64 {
 	return factor
 }
 
+// calculateResolutionScale returns the horizontal and vertical scale
+// factors applied to the image pixels by the zoom and resize operations,
+// where the resize applies to the zoomed image of the given size.
+func calculateResolutionScale(o Options, inWidth, inHeight, zoomedWidth, zoomedHeight int) (float64, float64) {
+	zoom := float64(o.Zoom + 1)
+	if !shouldTransformImage(o, inWidth, inHeight) {
+		return zoom, zoom
+	}
+
+	scalex := float64(o.Width) / float64(zoomedWidth)
+	scaley := float64(o.Height) / float64(zoomedHeight)
+	if !o.Force {
+		if o.Crop {
+			scalex = math.Max(scalex, scaley)
+		} else {
+			scalex = math.Min(scalex, scaley)
+		}
+		scaley = scalex
+	}
+	return zoom * scalex, zoom * scaley
+}
+
+// estimateMemory returns the estimated memory, in bytes, required to process
+// the given image: its decoded size, or the decoded output size if larger.
+func estimateMemory(image *C.VipsImage, o Options) int64 {
+	pixelSize := vipsPixelSize(image)
+	memory := int64(image.Xsize) * int64(image.Ysize) * pixelSize
+
+	if output := int64(o.Width) * int64(o.Height) * pixelSize; output > memory {
+		memory = output
+	}
+
+	return memory
+}
+
+// calculateAutoSharpen returns the sharpen options for the given downscale
+// factor, using the libvips defaults except for the jaggy areas slope.
+func calculateAutoSharpen(factor float64) Sharpen {
+	if factor <= 1 {
+		return Sharpen{}
+	}
+	return Sharpen{Radius: 1, X1: 2, Y2: 10, Y3: 20, M2: math.Min(1+math.Log2(factor), 4)}
+}
+
+// calculateMaxAreaSize returns the largest size with the same aspect ratio
+// as the given one whose area does not exceed the given maximum area.
+func calculateMaxAreaSize(inWidth, inHeight, maxArea int) (int, int) {
+	scale := math.Sqrt(float64(maxArea) / float64(inWidth*inHeight))
+	width := int(math.Max(math.Floor(float64(inWidth)*scale), 1))
+	height := int(math.Max(math.Floor(float64(inHeight)*scale), 1))
+
+	// Prevent floating point rounding errors
+	for width*height > maxArea && width > 1 {
+		width--
+	}
+
+	return width, height
+}
+
+// calculateTargetWidthSize returns the output size of the image resized to
+// the given width, and whether its height must be cropped to the given
+// maximum height (if any), because it would overflow.
+func calculateTargetWidthSize(inWidth, inHeight, targetWidth, maxHeight int) (int, int, bool) {
+	height := int(math.Max(math.Round(float64(inHeight)*float64(targetWidth)/float64(inWidth)), 1))
+	if maxHeight > 0 && height > maxHeight {
+		return targetWidth, maxHeight, true
+	}
+	return targetWidth, 0, false
+}
+
 func calculateCrop(inWidth, inHeight, outWidth, outHeight int, gravity Gravity) (int, int) {
 	left, top := 0, 0
 
@@ -624,6 +1881,14 @@ func calculateCrop(inWidth, inHeight, outWidth, outHeight int, gravity Gravity)
 		top = inHeight - outHeight
 	case GravityWest:
 		top = (inHeight - outHeight + 1) / 2
+	case GravityNorthEast:
+		left = inWidth - outWidth
+	case GravitySouthEast:
+		left = inWidth - outWidth
+		top = inHeight - outHeight
+	case GravitySouthWest:
+		top = inHeight - outHeight
+	case GravityNorthWest:
 	default:
 		left = (inWidth - outWidth + 1) / 2
 		top = (inHeight - outHeight + 1) / 2
@@ -696,3 +1961,50 @@ func getAngle(angle Angle) Angle {
 	}
 	return Angle(math.Min(float64(angle), 270))
 }
+
+// calculateHomography returns the 3x3 projective matrix, in row-major order,
+// which maps each one of the four from points into its to point.
+func calculateHomography(from, to [4]Point) ([9]float64, error) {
+	var matrix [9]float64
+	var a [8][9]float64
+
+	// Build the linear system for the eight unknown coefficients,
+	// with the last coefficient normalized to 1
+	for i := 0; i < 4; i++ {
+		x, y := from[i].X, from[i].Y
+		u, v := to[i].X, to[i].Y
+		a[i*2] = [9]float64{x, y, 1, 0, 0, 0, -u * x, -u * y, u}
+		a[i*2+1] = [9]float64{0, 0, 0, x, y, 1, -v * x, -v * y, v}
+	}
+
+	// Gaussian elimination with partial pivoting
+	for col := 0; col < 8; col++ {
+		pivot := col
+		for row := col + 1; row < 8; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return matrix, errors.New("Perspective points must not be collinear")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+
+		for row := 0; row < 8; row++ {
+			if row == col {
+				continue
+			}
+			factor := a[row][col] / a[col][col]
+			for k := col; k < 9; k++ {
+				a[row][k] -= factor * a[col][k]
+			}
+		}
+	}
+
+	for i := 0; i < 8; i++ {
+		matrix[i] = a[i][8] / a[i][i]
+	}
+	matrix[8] = 1
+
+	return matrix, nil
+}
@@ -1,7 +1,9 @@
 package bimg
 
 import (
+	"bytes"
 	"io/ioutil"
+	"math"
 	"os"
 	"path"
 	"strconv"
@@ -154,50 +156,1647 @@ func TestInvalidRotateDegrees(t *testing.T) {
 	Write("fixtures/test_rotate_invalid_out.jpg", newImg)
 }
 
+func TestAutoRotateWithoutOrientation(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+
+	newImg, err := AutoRotate(buf)
+	if err != nil {
+		t.Fatalf("AutoRotate(imgData) error: %#v", err)
+	}
+
+	if !bytes.Equal(buf, newImg) {
+		t.Fatal("Image without orientation should not be re-encoded")
+	}
+}
+
+func TestAutoRotateWithOrientation(t *testing.T) {
+	// 1680x1050 image with the EXIF orientation 6 (rotated by 90 degrees)
+	buf, _ := Read("fixtures/exif_orientation_6.jpg")
+
+	newImg, err := AutoRotate(buf)
+	if err != nil {
+		t.Fatalf("AutoRotate(imgData) error: %#v", err)
+	}
+
+	if err := assertSize(newImg, 1050, 1680); err != nil {
+		t.Error(err)
+	}
+
+	metadata, err := Metadata(newImg)
+	if err != nil {
+		t.Fatalf("Cannot read the metadata: %#v", err)
+	}
+	if metadata.Orientation != 0 {
+		t.Errorf("Expected the orientation to be removed: %d", metadata.Orientation)
+	}
+}
+
 func TestCorruptedImage(t *testing.T) {
 	options := Options{Width: 800, Height: 600}
 	buf, _ := Read("fixtures/corrupt.jpg")
 
-	newImg, err := Resize(buf, options)
+	newImg, err := Resize(buf, options)
+	if err != nil {
+		t.Errorf("Resize(imgData, %#v) error: %#v", options, err)
+	}
+
+	if DetermineImageType(newImg) != JPEG {
+		t.Fatal("Image is not jpeg")
+	}
+
+	size, _ := Size(newImg)
+	if size.Height != options.Height || size.Width != options.Width {
+		t.Fatalf("Invalid image size: %dx%d", size.Width, size.Height)
+	}
+
+	Write("fixtures/test_corrupt_out.jpg", newImg)
+}
+
+func TestNoColorProfile(t *testing.T) {
+	options := Options{Width: 800, Height: 600, NoProfile: true}
+	buf, _ := Read("fixtures/test.jpg")
+
+	newImg, err := Resize(buf, options)
+	if err != nil {
+		t.Errorf("Resize(imgData, %#v) error: %#v", options, err)
+	}
+
+	metadata, err := Metadata(newImg)
+	if metadata.Profile == true {
+		t.Fatal("Invalid profile data")
+	}
+
+	size, _ := Size(newImg)
+	if size.Height != options.Height || size.Width != options.Width {
+		t.Fatalf("Invalid image size: %dx%d", size.Width, size.Height)
+	}
+}
+
+func TestEmbedExtendColor(t *testing.T) {
+	options := Options{Width: 400, Height: 600, Crop: false, Embed: true, Extend: ExtendWhite, Background: Color{255, 20, 10}}
+	buf, _ := Read("fixtures/test_issue.jpg")
+
+	newImg, err := Resize(buf, options)
+	if err != nil {
+		t.Errorf("Resize(imgData, %#v) error: %#v", options, err)
+	}
+
+	size, _ := Size(newImg)
+	if size.Height != options.Height || size.Width != options.Width {
+		t.Fatalf("Invalid image size: %dx%d", size.Width, size.Height)
+	}
+
+	Write("fixtures/test_extend_white_out.jpg", newImg)
+}
+
+func TestEmbedExtendWithCustomColor(t *testing.T) {
+	options := Options{Width: 400, Height: 600, Crop: false, Embed: true, Extend: 5, Background: Color{255, 20, 10}}
+	buf, _ := Read("fixtures/test_issue.jpg")
+
+	newImg, err := Resize(buf, options)
+	if err != nil {
+		t.Errorf("Resize(imgData, %#v) error: %#v", options, err)
+	}
+
+	size, _ := Size(newImg)
+	if size.Height != options.Height || size.Width != options.Width {
+		t.Fatalf("Invalid image size: %dx%d", size.Width, size.Height)
+	}
+
+	Write("fixtures/test_extend_background_out.jpg", newImg)
+}
+
+func TestEmbedExtendEdgeBlur(t *testing.T) {
+	// Red left half and blue right half
+	raw := make([]byte, 100*100*3)
+	for i := 0; i < 100*100; i++ {
+		if i%100 < 50 {
+			raw[i*3] = 255
+		} else {
+			raw[i*3+2] = 255
+		}
+	}
+	image, err := NewImageFromRaw(raw, 100, 100, 3)
+	if err != nil {
+		t.Fatalf("Cannot create the image: %#v", err)
+	}
+
+	options := Options{Width: 100, Height: 300, Embed: true, Extend: ExtendEdgeBlur}
+	newImg, err := Resize(image.Image(), options)
+	if err != nil {
+		t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+	}
+
+	pixels, width, height, bands, err := ToRaw(newImg, Options{})
+	if err != nil {
+		t.Fatalf("Cannot read the image pixels: %#v", err)
+	}
+	if width != options.Width || height != options.Height {
+		t.Fatalf("Invalid image size: %dx%d", width, height)
+	}
+	pixel := func(x, y int) []byte {
+		i := (y*width + x) * bands
+		return pixels[i : i+3]
+	}
+
+	// The letterbox area follows the colors of the image edge
+	if p := pixel(10, 10); p[0] < 200 || p[2] > 55 {
+		t.Errorf("Invalid padding color on the red edge: %v", p)
+	}
+	if p := pixel(90, 290); p[2] < 200 || p[0] > 55 {
+		t.Errorf("Invalid padding color on the blue edge: %v", p)
+	}
+
+	// The letterbox area is blurred, mixing both colors where they meet
+	for _, x := range []int{49, 50} {
+		if p := pixel(x, 10); p[0] < 64 || p[0] > 192 || p[2] < 64 || p[2] > 192 {
+			t.Errorf("Padding is not blurred at %d: %v", x, p)
+		}
+	}
+
+	// The image itself is kept sharp
+	if p := pixel(49, 150); p[0] != 255 || p[2] != 0 {
+		t.Errorf("Invalid image color: %v", p)
+	}
+	if p := pixel(50, 150); p[0] != 0 || p[2] != 255 {
+		t.Errorf("Invalid image color: %v", p)
+	}
+}
+
+func TestResizeShrinkOnLoad(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+
+	for _, shrink := range []int{1, 2, 4, 8} {
+		options := Options{Width: 300, Height: 200, Crop: true, ShrinkOnLoad: shrink}
+		newImg, err := Resize(buf, options)
+		if err != nil {
+			t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+		}
+
+		size, _ := Size(newImg)
+		if size.Width != options.Width || size.Height != options.Height {
+			t.Fatalf("Invalid image size: %dx%d", size.Width, size.Height)
+		}
+	}
+
+	if _, err := Resize(buf, Options{Width: 300, ShrinkOnLoad: 3}); err == nil {
+		t.Fatal("Expected error for invalid shrink on load factor")
+	}
+}
+
+func TestResizeHighQualityRotate(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+
+	for _, angle := range []Angle{D90, D180, D270} {
+		options := Options{Rotate: angle, HighQualityRotate: true}
+		highQuality, err := Resize(buf, options)
+		if err != nil {
+			t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+		}
+
+		options.HighQualityRotate = false
+		lossy, err := Resize(buf, options)
+		if err != nil {
+			t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+		}
+
+		if len(highQuality) <= len(lossy) {
+			t.Fatalf("Expected a higher quality image for angle %d: %d <= %d", angle, len(highQuality), len(lossy))
+		}
+	}
+
+	// An explicit quality takes precedence
+	options := Options{Rotate: D90, Quality: 50}
+	lossy, err := Resize(buf, options)
+	if err != nil {
+		t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+	}
+	options.HighQualityRotate = true
+	explicit, err := Resize(buf, options)
+	if err != nil {
+		t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+	}
+	if len(explicit) >= len(lossy)*2 {
+		t.Fatalf("Expected the explicit quality to be used: %d >= 2 * %d", len(explicit), len(lossy))
+	}
+
+	// Other image types are rotated as usual
+	buf, _ = Read("fixtures/test.png")
+	newImg, err := Resize(buf, Options{Rotate: D90, HighQualityRotate: true})
+	if err != nil {
+		t.Fatalf("Cannot rotate the image: %#v", err)
+	}
+	if err := assertSize(newImg, 300, 400); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestToJPEG(t *testing.T) {
+	buf, _ := Read("fixtures/transparent.png")
+
+	newImg, err := ToJPEG(buf, Color{255, 255, 255}, 85)
+	if err != nil {
+		t.Fatalf("Cannot convert the image: %#v", err)
+	}
+
+	if DetermineImageType(newImg) != JPEG {
+		t.Fatal("Image is not jpeg")
+	}
+
+	metadata, err := Metadata(newImg)
+	if err != nil {
+		t.Fatalf("Cannot read the image metadata: %#v", err)
+	}
+	if metadata.Alpha {
+		t.Fatal("Image alpha channel was not flattened")
+	}
+
+	Write("fixtures/test_to_jpeg_out.jpg", newImg)
+}
+
+func TestToJPEGWithoutAlpha(t *testing.T) {
+	buf, _ := Read("fixtures/test.webp")
+
+	newImg, err := ToJPEG(buf, Color{255, 255, 255}, 0)
+	if err != nil {
+		t.Fatalf("Cannot convert the image: %#v", err)
+	}
+
+	if DetermineImageType(newImg) != JPEG {
+		t.Fatal("Image is not jpeg")
+	}
+	if err := assertSize(newImg, 550, 368); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResizeFirstFrame(t *testing.T) {
+	buf, _ := Read("fixtures/transparent.png")
+
+	newImg, err := Resize(buf, Options{Width: 200, FirstFrame: true, Background: Color{255, 255, 255}})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+
+	if DetermineImageType(newImg) != JPEG {
+		t.Fatal("Image is not jpeg")
+	}
+
+	metadata, err := Metadata(newImg)
+	if err != nil {
+		t.Fatalf("Cannot read the image metadata: %#v", err)
+	}
+	if metadata.Alpha || metadata.Size.Width != 200 {
+		t.Fatalf("Invalid static preview: %#v", metadata)
+	}
+
+	newImg, err = Resize(buf, Options{FirstFrame: true, Type: PNG})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	if DetermineImageType(newImg) != PNG {
+		t.Fatal("Image is not png")
+	}
+}
+
+func TestResizeBackgroundAlpha(t *testing.T) {
+	buf, _ := Read("fixtures/transparent.png")
+
+	options := Options{Type: PNG, BackgroundAlpha: &ColorAlpha{255, 255, 255, 128}}
+	newImg, err := Resize(buf, options)
+	if err != nil {
+		t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+	}
+
+	pixels, _, _, bands, err := ToRaw(newImg, Options{})
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+	if bands != 4 {
+		t.Fatalf("Invalid number of bands: %d", bands)
+	}
+	for i := 3; i < len(pixels); i += bands {
+		if pixels[i] < 127 {
+			t.Fatalf("Invalid alpha value: %d", pixels[i])
+		}
+	}
+
+	options.Type = JPEG
+	newImg, err = Resize(buf, options)
+	if err != nil {
+		t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+	}
+	metadata, _ := Metadata(newImg)
+	if metadata.Alpha {
+		t.Fatal("Image alpha channel was not flattened")
+	}
+
+	// The zero color is a fully transparent background, not an undefined one
+	options = Options{Type: PNG, BackgroundAlpha: &ColorAlpha{}}
+	if reencode, reason := ShouldReencode(buf, options); !reencode || reason != "flatten" {
+		t.Fatalf("Expected the image to be flattened: %t, %s", reencode, reason)
+	}
+}
+
+func TestResizeMaxArea(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+
+	options := Options{MaxArea: 250000}
+	newImg, err := Resize(buf, options)
+	if err != nil {
+		t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+	}
+
+	size, _ := Size(newImg)
+	if size.Width*size.Height > options.MaxArea {
+		t.Fatalf("Invalid image size: %dx%d", size.Width, size.Height)
+	}
+	if size.Width != 632 || size.Height != 395 {
+		t.Fatalf("Invalid image size: %dx%d", size.Width, size.Height)
+	}
+
+	// Smaller images are left untouched
+	newImg, err = Resize(buf, Options{MaxArea: 4000000})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	if err := assertSize(newImg, 1680, 1050); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResizeMaxMemory(t *testing.T) {
+	buf := readFile("test.jpg")
+
+	_, err := Resize(buf, Options{Width: 300, MaxMemory: 1024 * 1024})
+	if err == nil || err.Error() != "Image exceeds the memory limit" {
+		t.Fatalf("Expected memory limit error, got: %#v", err)
+	}
+
+	_, err = Resize(buf, Options{Width: 300, MaxMemory: 64 * 1024 * 1024})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+
+	_, err = Resize(buf, Options{Width: 8000, Height: 5000, Force: true, Enlarge: true, MaxMemory: 64 * 1024 * 1024})
+	if err == nil {
+		t.Fatal("Expected memory limit error for the enlarged image")
+	}
+}
+
+func TestResizeAutoSharpen(t *testing.T) {
+	buf := readFile("test.jpg")
+	options := Options{Width: 210, Height: 131, Force: true}
+
+	soft, err := Resize(buf, options)
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+
+	options.AutoSharpen = true
+	sharp, err := Resize(buf, options)
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	if err := assertSize(sharp, 210, 131); err != nil {
+		t.Fatal(err)
+	}
+
+	softSharpness, _ := Sharpness(soft)
+	sharpSharpness, _ := Sharpness(sharp)
+	if sharpSharpness <= softSharpness {
+		t.Errorf("Image is not sharpened: %f <= %f", sharpSharpness, softSharpness)
+	}
+}
+
+func TestCalculateAutoSharpen(t *testing.T) {
+	tests := []struct {
+		factor float64
+		m2     float64
+	}{
+		{0.5, 0},
+		{1, 0},
+		{2, 2},
+		{4, 3},
+		{8, 4},
+		{32, 4},
+	}
+
+	for _, test := range tests {
+		if sharpen := calculateAutoSharpen(test.factor); sharpen.M2 != test.m2 {
+			t.Errorf("Invalid sharpen slope for factor %f: %f != %f", test.factor, sharpen.M2, test.m2)
+		}
+	}
+}
+
+func TestCalculateMaxAreaSize(t *testing.T) {
+	tests := []struct {
+		width, height, maxArea int
+		outWidth, outHeight    int
+	}{
+		{1680, 1050, 250000, 632, 395},
+		{1000, 1000, 250000, 500, 500},
+		{100, 10000, 100, 1, 100},
+	}
+
+	for _, test := range tests {
+		width, height := calculateMaxAreaSize(test.width, test.height, test.maxArea)
+		if width != test.outWidth || height != test.outHeight {
+			t.Errorf("Invalid size for %#v: %dx%d", test, width, height)
+		}
+		if width*height > test.maxArea {
+			t.Errorf("Area exceeded for %#v: %dx%d", test, width, height)
+		}
+	}
+}
+
+func TestResizeTargetWidth(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+	tests := []struct {
+		options       Options
+		width, height int
+	}{
+		{Options{TargetWidth: 800, MaxHeight: 300}, 800, 300},
+		{Options{TargetWidth: 800, MaxHeight: 600}, 800, 500},
+		{Options{TargetWidth: 800}, 800, 500},
+		{Options{TargetWidth: 2000, MaxHeight: 600, Gravity: GravityNorth}, 2000, 600},
+		{Options{TargetWidth: 400, MaxHeight: 200, Width: 100, Height: 100}, 400, 200},
+		{Options{TargetWidth: 400, MaxHeight: 200, Width: 100, Height: 100, Crop: true, UseThumbnail: true}, 400, 200},
+	}
+
+	for _, test := range tests {
+		newImg, err := Resize(buf, test.options)
+		if err != nil {
+			t.Fatalf("Cannot process the image with %#v: %#v", test.options, err)
+		}
+		if err := assertSize(newImg, test.width, test.height); err != nil {
+			t.Errorf("%#v: %s", test.options, err)
+		}
+	}
+
+	if _, err := Resize(buf, Options{TargetWidth: -1}); err == nil {
+		t.Error("Expected error for negative target width")
+	}
+}
+
+func TestCalculateTargetWidthSize(t *testing.T) {
+	tests := []struct {
+		width, height, targetWidth, maxHeight int
+		outWidth, outHeight                   int
+		crop                                  bool
+	}{
+		{1680, 1050, 800, 600, 800, 0, false},
+		{1680, 1050, 800, 400, 800, 400, true},
+		{1000, 3000, 500, 0, 500, 0, false},
+		{3000, 10, 100, 600, 100, 0, false},
+	}
+
+	for _, test := range tests {
+		width, height, crop := calculateTargetWidthSize(test.width, test.height, test.targetWidth, test.maxHeight)
+		if width != test.outWidth || height != test.outHeight || crop != test.crop {
+			t.Errorf("Invalid size for %#v: %dx%d (crop: %v)", test, width, height, crop)
+		}
+	}
+}
+
+func TestProcessResponsive(t *testing.T) {
+	if !IsTypeSupportedSave(WEBP) {
+		t.Skip("WebP saving is not supported")
+	}
+
+	tests := []struct {
+		file     string
+		fallback ImageType
+	}{
+		{"test.jpg", JPEG},
+		{"transparent.png", PNG},
+	}
+
+	for _, test := range tests {
+		webp, fallback, err := ProcessResponsive(readFile(test.file), Options{Width: 300})
+		if err != nil {
+			t.Fatalf("Cannot process the image %s: %#v", test.file, err)
+		}
+
+		if DetermineImageType(webp) != WEBP {
+			t.Errorf("%s: invalid image type: %s", test.file, ImageTypeName(DetermineImageType(webp)))
+		}
+		if DetermineImageType(fallback) != test.fallback {
+			t.Errorf("%s: invalid fallback image type: %s", test.file, ImageTypeName(DetermineImageType(fallback)))
+		}
+
+		webpSize, _ := Size(webp)
+		fallbackSize, _ := Size(fallback)
+		if webpSize.Width != 300 || webpSize != fallbackSize {
+			t.Errorf("%s: invalid image sizes: %#v and %#v", test.file, webpSize, fallbackSize)
+		}
+	}
+}
+
+func TestResizeCropRelative(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+	tests := []struct {
+		options       Options
+		width, height int
+	}{
+		{Options{CropRelative: CropRelative{Left: 0.25, Top: 0.25, Width: 0.5, Height: 0.5}}, 840, 525},
+		{Options{CropRelative: CropRelative{Left: 0.5, Width: 1}}, 840, 1050},
+		{Options{CropRelative: CropRelative{Width: 0.5, Height: 0.5}, Width: 420, Height: 200, Crop: true}, 420, 200},
+		{Options{CropRelative: CropRelative{Left: 0.5, Top: 0.5}, Width: 420, Height: 300, Crop: true}, 420, 300},
+	}
+
+	for _, test := range tests {
+		newImg, err := Resize(buf, test.options)
+		if err != nil {
+			t.Fatalf("Cannot process the image with %#v: %#v", test.options, err)
+		}
+		if err := assertSize(newImg, test.width, test.height); err != nil {
+			t.Errorf("%#v: %s", test.options, err)
+		}
+	}
+}
+
+func TestResizeTrimCropRelative(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+	letterbox, err := Resize(buf, Options{Width: 800, Height: 800, Embed: true, Extend: ExtendBackground, Background: Color{255, 255, 255}})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+
+	// The trimmed image must not be loaded again with shrink-on-load
+	options := Options{Width: 200, Trim: true, TrimBackground: Color{255, 255, 255}, CropRelative: CropRelative{Width: 1, Height: 1}}
+	newImg, err := Resize(letterbox, options)
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+
+	size, _ := Size(newImg)
+	if size.Width != 200 || size.Height >= 150 {
+		t.Fatalf("Invalid image size: %dx%d", size.Width, size.Height)
+	}
+}
+
+func TestCalculateCropRelative(t *testing.T) {
+	tests := []struct {
+		area                     CropRelative
+		left, top, width, height int
+	}{
+		{CropRelative{Left: 0.25, Top: 0.1, Width: 0.5, Height: 0.5}, 25, 20, 50, 100},
+		{CropRelative{Width: 1, Height: 1}, 0, 0, 100, 200},
+		{CropRelative{Left: 0.5, Top: 0.5}, 50, 100, 50, 100},
+		{CropRelative{Left: -0.5, Top: 0.9, Width: 1, Height: 0.5}, 0, 180, 50, 20},
+		{CropRelative{Left: 2, Top: 1, Width: 0.1, Height: 0.1}, 99, 199, 1, 1},
+		{CropRelative{Left: 0.5, Top: 0.5, Width: 0.001, Height: 0.001}, 50, 100, 1, 1},
+	}
+
+	for _, test := range tests {
+		left, top, width, height := calculateCropRelative(100, 200, test.area)
+		if left != test.left || top != test.top || width != test.width || height != test.height {
+			t.Errorf("Invalid area for %#v: %d,%d %dx%d", test.area, left, top, width, height)
+		}
+	}
+}
+
+func TestResizeUseResize(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+
+	tests := []Options{
+		{Width: 800, Height: 600, UseResize: true},
+		{Width: 800, Height: 600, UseResize: true, Crop: true},
+		{Width: 800, Height: 600, UseResize: true, Embed: true},
+		{Width: 800, Height: 600, UseResize: true, Force: true, Kernel: KernelCubic},
+		{Width: 300, UseResize: true, Kernel: KernelNearest},
+	}
+
+	for _, options := range tests {
+		newImg, err := Resize(buf, options)
+		if err != nil {
+			t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+		}
+
+		height := options.Height
+		if height == 0 {
+			height = 187
+		}
+		if err := assertSize(newImg, options.Width, height); err != nil {
+			t.Errorf("%#v: %s", options, err)
+		}
+	}
+}
+
+func TestResizeAntiAlias(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+
+	sharpImg, err := Resize(buf, Options{Width: 300, ShrinkOnLoad: 1})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+
+	for _, sigma := range []float64{0, 2} {
+		newImg, err := Resize(buf, Options{Width: 300, ShrinkOnLoad: 1, AntiAlias: true, AntiAliasSigma: sigma})
+		if err != nil {
+			t.Fatalf("Cannot process the image: %#v", err)
+		}
+		if err := assertSize(newImg, 300, 187); err != nil {
+			t.Error(err)
+		}
+
+		// Blurred images are better compressed
+		if len(newImg) >= len(sharpImg) {
+			t.Errorf("Expected a blurred image (sigma %f): %d >= %d", sigma, len(newImg), len(sharpImg))
+		}
+	}
+}
+
+func TestResizeScaleResolution(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+	metadata, _ := Metadata(buf)
+
+	newImg, err := Resize(buf, Options{Width: 840, ScaleResolution: true})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+
+	newMetadata, _ := Metadata(newImg)
+	if math.Abs(newMetadata.ResolutionX-metadata.ResolutionX/2) > 1 || math.Abs(newMetadata.ResolutionY-metadata.ResolutionY/2) > 1 {
+		t.Fatalf("Invalid resolution: %vx%v", newMetadata.ResolutionX, newMetadata.ResolutionY)
+	}
+
+	// Zoomed in by 2, then resized or not
+	for _, options := range []Options{{Zoom: 1, ScaleResolution: true}, {Zoom: 1, Width: 840, Crop: true, ScaleResolution: true}} {
+		newImg, err = Resize(buf, options)
+		if err != nil {
+			t.Fatalf("Cannot process the image: %#v", err)
+		}
+
+		size, _ := Size(newImg)
+		newMetadata, _ = Metadata(newImg)
+		scale := float64(size.Width) / 1680
+		if math.Abs(newMetadata.ResolutionX-metadata.ResolutionX*scale) > 1 {
+			t.Fatalf("Invalid resolution for %#v, expected a %v scale: %vx%v", options, scale, newMetadata.ResolutionX, newMetadata.ResolutionY)
+		}
+	}
+
+	newImg, err = Resize(buf, Options{Width: 840})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+
+	newMetadata, _ = Metadata(newImg)
+	if math.Abs(newMetadata.ResolutionX-metadata.ResolutionX) > 1 {
+		t.Fatalf("Invalid resolution: %vx%v", newMetadata.ResolutionX, newMetadata.ResolutionY)
+	}
+}
+
+func TestResizeUseThumbnail(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+
+	tests := []Options{
+		{Width: 300, Height: 300, Crop: true, UseThumbnail: true},
+		{Width: 300, Height: 300, Crop: true, UseThumbnail: true, Gravity: GravitySmart},
+		{Width: 300, Height: 300, Crop: true, UseThumbnail: true, Gravity: GravityNorth},
+		{Width: 300, Height: 200, UseThumbnail: true},
+	}
+
+	for _, options := range tests {
+		newImg, err := Resize(buf, options)
+		if err != nil {
+			t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+		}
+		if err := assertSize(newImg, options.Width, options.Height); err != nil {
+			t.Errorf("%#v: %s", options, err)
+		}
+	}
+}
+
+func TestResizeGravitySmart(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+
+	options := Options{Width: 300, Height: 300, Crop: true, Gravity: GravitySmart}
+	newImg, err := Resize(buf, options)
+	if err != nil {
+		t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+	}
+	if err := assertSize(newImg, 300, 300); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	for _, file := range []string{"test.jpg", "test.png", "test.webp"} {
+		buf := readFile(file)
+		size, _ := Size(buf)
+
+		optimized, err := Optimize(buf, Options{Quality: 80, Width: 100})
+		if err != nil {
+			t.Fatalf("Cannot optimize the image %s: %#v", file, err)
+		}
+		if len(optimized) > len(buf) {
+			t.Errorf("Optimized image %s is larger: %d > %d", file, len(optimized), len(buf))
+		}
+		if DetermineImageType(optimized) != DetermineImageType(buf) {
+			t.Errorf("Invalid optimized image %s type", file)
+		}
+		if err := assertSize(optimized, size.Width, size.Height); err != nil {
+			t.Errorf("Optimized image %s was resized: %s", file, err)
+		}
+	}
+
+	if _, err := Optimize(nil, Options{}); err == nil {
+		t.Fatal("Expected error for an empty buffer")
+	}
+}
+
+func TestResizeSmoothUpscale(t *testing.T) {
+	stripes, err := NewImageFromRaw([]byte{0, 255, 0, 255, 0, 255, 0, 255}, 8, 1, 1)
+	if err != nil {
+		t.Fatalf("Cannot create the image: %#v", err)
+	}
+
+	tests := []struct {
+		options Options
+		smooth  bool
+	}{
+		{Options{Zoom: 1}, false},
+		{Options{Zoom: 1, SmoothUpscale: true, UpscaleKernel: KernelLinear}, true},
+		{Options{Width: 16, Height: 2, Enlarge: true, SmoothUpscale: true, UpscaleKernel: KernelLinear}, true},
+		{Options{Width: 16, Height: 2, Enlarge: true, UseResize: true, SmoothUpscale: true, UpscaleKernel: KernelLinear}, true},
+	}
+
+	for _, test := range tests {
+		test.options.Type = PNG
+		buf, err := Resize(stripes.Image(), test.options)
+		if err != nil {
+			t.Fatalf("Cannot resize the image: %#v", err)
+		}
+		if err := assertSize(buf, 16, 2); err != nil {
+			t.Fatal(err)
+		}
+
+		pixels, _, _, _, err := ToRaw(buf, Options{})
+		if err != nil {
+			t.Fatalf("Cannot read the image: %#v", err)
+		}
+
+		blended := false
+		for _, value := range pixels {
+			if value > 16 && value < 240 {
+				blended = true
+			}
+		}
+		if blended != test.smooth {
+			t.Errorf("Invalid upscale for %#v: %v", test.options, pixels)
+		}
+	}
+}
+
+func TestEncodeToSSIM(t *testing.T) {
+	buf := readFile("test.jpg")
+
+	for _, target := range []float64{0.9, 0.98} {
+		encoded, quality, err := EncodeToSSIM(buf, target, WEBP)
+		if err != nil {
+			t.Fatalf("Cannot encode the image: %#v", err)
+		}
+		if DetermineImageType(encoded) != WEBP {
+			t.Fatal("Image is not webp")
+		}
+		if quality < 1 || quality > 100 {
+			t.Fatalf("Invalid quality: %d", quality)
+		}
+
+		value, err := SSIM(buf, encoded)
+		if err != nil {
+			t.Fatalf("Cannot compare the images: %#v", err)
+		}
+		if value < target && quality < 100 {
+			t.Errorf("SSIM %f doesn't meet the target %f with quality %d", value, target, quality)
+		}
+	}
+
+	if _, _, err := EncodeToSSIM(buf, 0.9, PNG); err == nil {
+		t.Error("Expected error for a lossless image type")
+	}
+	if _, _, err := EncodeToSSIM(buf, 1.5, JPEG); err == nil {
+		t.Error("Expected error for an invalid SSIM target")
+	}
+}
+
+func TestRemoveLetterbox(t *testing.T) {
+	raw := make([]byte, 200*100*3)
+	for i := range raw {
+		raw[i] = byte(i * 7 % 256)
+	}
+	content, err := NewImageFromRaw(raw, 200, 100, 3)
+	if err != nil {
+		t.Fatalf("Cannot create the image: %#v", err)
+	}
+
+	tests := []struct {
+		width, height int
+	}{
+		{200, 160},
+		{300, 100},
+		{260, 140},
+	}
+
+	for _, test := range tests {
+		letterboxed, err := Place(content.Image(), test.width, test.height, GravityCentre, ColorBlack)
+		if err != nil {
+			t.Fatalf("Cannot place the image: %#v", err)
+		}
+
+		buf, err := RemoveLetterbox(letterboxed, 0)
+		if err != nil {
+			t.Fatalf("Cannot remove the letterbox: %#v", err)
+		}
+		if err := assertSize(buf, 200, 100); err != nil {
+			t.Errorf("%dx%d: %s", test.width, test.height, err)
+		}
+	}
+
+	// Images without bars are left untouched
+	buf, err := RemoveLetterbox(content.Image(), 0)
+	if err != nil {
+		t.Fatalf("Cannot remove the letterbox: %#v", err)
+	}
+	if err := assertSize(buf, 200, 100); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRemoveLetterboxInvalidTolerance(t *testing.T) {
+	if _, err := RemoveLetterbox(readFile("test.jpg"), -1); err == nil {
+		t.Fatal("Expected error for negative tolerance")
+	}
+}
+
+func TestRemoveLetterboxColoredBars(t *testing.T) {
+	// 30 rows of red and green columns of about the same luminance, 100 rows of
+	// content and 30 black rows
+	width, height := 200, 160
+	raw := make([]byte, width*height*3)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pixel := raw[(y*width+x)*3 : (y*width+x)*3+3]
+			switch {
+			case y < 30 && x%2 == 0:
+				copy(pixel, []byte{200, 0, 0})
+			case y < 30:
+				copy(pixel, []byte{0, 115, 0})
+			case y < 130:
+				copy(pixel, []byte{byte(x * 7), byte(y * 5), byte(x + y)})
+			}
+		}
+	}
+	image, err := NewImageFromRaw(raw, width, height, 3)
+	if err != nil {
+		t.Fatalf("Cannot create the image: %#v", err)
+	}
+
+	// Only the black bar is removed
+	buf, err := RemoveLetterbox(image.Image(), 0)
+	if err != nil {
+		t.Fatalf("Cannot remove the letterbox: %#v", err)
+	}
+	if err := assertSize(buf, 200, 130); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCropToAspect(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+	tests := []struct {
+		wRatio, hRatio int
+		gravity        Gravity
+		width, height  int
+	}{
+		{1, 1, GravityCentre, 1050, 1050},
+		{16, 9, GravityNorth, 1680, 945},
+		{9, 16, GravitySmart, 590, 1050},
+	}
+
+	for _, test := range tests {
+		newImg, err := CropToAspect(buf, test.wRatio, test.hRatio, test.gravity)
+		if err != nil {
+			t.Fatalf("Cannot crop the image: %#v", err)
+		}
+		if err := assertSize(newImg, test.width, test.height); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err := CropToAspect(buf, 0, 1, GravityCentre); err == nil {
+		t.Fatal("Expected error for invalid aspect ratio")
+	}
+}
+
+func TestCalculateAspectCrop(t *testing.T) {
+	if width, height := calculateAspectCrop(400, 300, 1, 1); width != 300 || height != 300 {
+		t.Errorf("Invalid crop size: %dx%d", width, height)
+	}
+	if width, height := calculateAspectCrop(400, 300, 2, 1); width != 400 || height != 200 {
+		t.Errorf("Invalid crop size: %dx%d", width, height)
+	}
+	if width, height := calculateAspectCrop(400, 300, 4, 3); width != 400 || height != 300 {
+		t.Errorf("Invalid crop size: %dx%d", width, height)
+	}
+}
+
+func TestResizeFocusPoint(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+
+	options := Options{Width: 300, Height: 300, Crop: true, Focus: &Point{0.9, 0.5}}
+	newImg, err := Resize(buf, options)
+	if err != nil {
+		t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+	}
+	if err := assertSize(newImg, 300, 300); err != nil {
+		t.Error(err)
+	}
+
+	// Left half red and right half blue: the left edge focus keeps the red half
+	raw := make([]byte, 200*100*3)
+	for i := 0; i < len(raw); i += 3 {
+		if (i/3)%200 < 100 {
+			raw[i] = 255
+		} else {
+			raw[i+2] = 255
+		}
+	}
+	image, err := NewImageFromRaw(raw, 200, 100, 3)
+	if err != nil {
+		t.Fatalf("Cannot create the image: %#v", err)
+	}
+	newImg, err = Resize(image.Image(), Options{Width: 100, Height: 100, Crop: true, Focus: &Point{0, 0.5}})
+	if err != nil {
+		t.Fatalf("Cannot crop the image: %#v", err)
+	}
+	pixels, width, _, bands, err := ToRaw(newImg, Options{})
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+	if right := (50*width + width - 1) * bands; pixels[right] != 255 || pixels[right+2] != 0 {
+		t.Errorf("Expected the crop origin at the left edge: %v", pixels[right:right+3])
+	}
+
+	if _, err := Resize(buf, Options{Width: 300, Height: 300, Crop: true, Focus: &Point{1.5, 0.5}}); err == nil {
+		t.Fatal("Expected error for invalid focus point")
+	}
+}
+
+func TestCalculateFocusCrop(t *testing.T) {
+	tests := []struct {
+		focusX, focusY float64
+		left, top      int
+	}{
+		{0, 0, 0, 0},
+		{0.5, 0.5, 100, 50},
+		{0.25, 0.75, 0, 100},
+		{0.6, 0.4, 140, 20},
+		{1, 1, 200, 100},
+	}
+
+	for _, test := range tests {
+		left, top := calculateFocusCrop(400, 300, 200, 200, Point{test.focusX, test.focusY})
+		if left != test.left || top != test.top {
+			t.Errorf("Invalid crop for the %f,%f focus point: %d,%d", test.focusX, test.focusY, left, top)
+		}
+	}
+}
+
+func TestResizeLoadOptions(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+
+	newImg, err := Resize(buf, Options{LoadOptions: map[string]string{"shrink": "2"}})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	if err := assertSize(newImg, 840, 525); err != nil {
+		t.Error(err)
+	}
+
+	newImg, err = Resize(buf, Options{Width: 300, LoadOptions: map[string]string{"shrink": "2"}})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	if err := assertSize(newImg, 300, 187); err != nil {
+		t.Error(err)
+	}
+
+	// The image is not loaded again, which would drop the custom load options
+	_, plan, err := ResizeExplain(buf, Options{Width: 300, LoadOptions: map[string]string{"fail": "false"}})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	for _, step := range plan.Steps {
+		if step.Operation == ResizeShrinkOnLoad {
+			t.Errorf("Unexpected shrink-on-load with custom load options: %#v", plan.Steps)
+		}
+	}
+
+	if _, err := Resize(buf, Options{LoadOptions: map[string]string{"foo": "bar"}}); err == nil {
+		t.Fatal("Expected error for unsupported load option")
+	}
+	if _, err := Resize(buf, Options{LoadOptions: map[string]string{"shrink": "2,page=1"}}); err == nil {
+		t.Fatal("Expected error for invalid load option")
+	}
+	for _, name := range []string{"", "page1", "Shrink", "shrink=2"} {
+		if _, err := Resize(buf, Options{LoadOptions: map[string]string{name: "1"}}); err == nil {
+			t.Fatalf("Expected error for invalid load option name %q", name)
+		}
+	}
+}
+
+func TestResizeInputType(t *testing.T) {
+	buf, _ := Read("fixtures/test.png")
+
+	newImg, err := Resize(buf, Options{Width: 100, InputType: PNG, Type: JPEG})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	if DetermineImageType(newImg) != JPEG {
+		t.Fatal("Image is not jpeg")
+	}
+
+	if _, err := Resize(buf, Options{Width: 100, InputType: JPEG}); err == nil {
+		t.Fatal("Expected error for mismatching input image type")
+	}
+
+	if _, err := Resize(buf, Options{Width: 100, InputType: ImageType(100)}); err == nil {
+		t.Fatal("Expected error for unsupported input image type")
+	}
+}
+
+func TestResizeExplain(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+
+	newImg, plan, err := ResizeExplain(buf, Options{Width: 100})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	if size, _ := Size(newImg); size.Width != 100 {
+		t.Errorf("Invalid image width: %d", size.Width)
+	}
+	if len(plan.Steps) == 0 || plan.Steps[0].Operation != ResizeShrinkOnLoad || plan.Steps[0].Factor != 8 {
+		t.Fatalf("Invalid resize plan: %#v", plan)
+	}
+	if last := plan.Steps[len(plan.Steps)-1]; last.Operation != ResizeAffine {
+		t.Fatalf("Invalid last resize step: %s", last.Operation)
+	}
+
+	_, plan, err = ResizeExplain(buf, Options{Width: 100, UseResize: true, ShrinkOnLoad: 1})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	if len(plan.Steps) != 1 || plan.Steps[0].Operation != ResizeReduce {
+		t.Fatalf("Invalid resize plan: %#v", plan)
+	}
+
+	// The resize reduces the image left by the shrink-on-load only
+	_, plan, err = ResizeExplain(buf, Options{Width: 100, UseResize: true})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	if len(plan.Steps) != 2 || plan.Steps[0].Operation != ResizeShrinkOnLoad || plan.Steps[0].Factor != 8 ||
+		plan.Steps[1].Operation != ResizeReduce || math.Abs(plan.Steps[1].Factor-2.1) > 0.01 {
+		t.Fatalf("Invalid shrink-on-load resize plan: %#v", plan)
+	}
+
+	_, plan, err = ResizeExplain(buf, Options{Width: 3360, Enlarge: true, SmoothUpscale: true, UpscaleKernel: KernelLinear})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	if len(plan.Steps) != 1 || plan.Steps[0].Operation != ResizeReduce || plan.Steps[0].Kernel != KernelLinear || plan.Steps[0].Factor != 0.5 {
+		t.Fatalf("Invalid smooth upscale resize plan: %#v", plan)
+	}
+
+	_, plan, err = ResizeExplain(buf, Options{})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	if len(plan.Steps) != 0 {
+		t.Fatalf("Unexpected resize steps: %#v", plan.Steps)
+	}
+}
+
+func TestResizeJP2K(t *testing.T) {
+	if !IsTypeSupportedSave(JP2K) {
+		t.Skip("JPEG 2000 save is not supported by the current libvips compilation")
+	}
+
+	buf, _ := Read("fixtures/test.jpg")
+	for _, lossless := range []bool{false, true} {
+		newImg, err := Resize(buf, Options{Width: 300, Type: JP2K, Lossless: lossless, JP2KTileWidth: 256, JP2KTileHeight: 256})
+		if err != nil {
+			t.Fatalf("Cannot process the image: %#v", err)
+		}
+		if DetermineImageType(newImg) != JP2K {
+			t.Fatal("Image is not jp2k")
+		}
+	}
+}
+
+func TestResizeLosslessWEBP(t *testing.T) {
+	if !IsTypeSupportedSave(WEBP) {
+		t.Skip("WebP saving is not supported")
+	}
+
+	pixels := make([]byte, 16*16*3)
+	for i := range pixels {
+		pixels[i] = byte(i * 7)
+	}
+	image, err := NewImageFromRaw(pixels, 16, 16, 3)
+	if err != nil {
+		t.Fatalf("Cannot create the image: %#v", err)
+	}
+
+	newImg, err := Resize(image.Image(), Options{Type: WEBP, Lossless: true, Quality: 10})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	decoded, _, _, _, err := ToRaw(newImg, Options{})
+	if err != nil {
+		t.Fatalf("Cannot decode the image: %#v", err)
+	}
+	if !bytes.Equal(decoded, pixels) {
+		t.Error("Expected the lossless WebP image pixels to be preserved")
+	}
+}
+
+func TestResizeTruncated(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+	truncated := buf[:len(buf)*6/10]
+
+	newImg, err := Resize(truncated, Options{Width: 800})
+	if err != nil {
+		t.Fatalf("Cannot process the truncated image: %#v", err)
+	}
+	if err := assertSize(newImg, 800, 500); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResizeStrictLoad(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+
+	if _, err := Resize(buf, Options{Width: 800, StrictLoad: true}); err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+
+	truncated := buf[:len(buf)*6/10]
+	_, err := Resize(truncated, Options{Width: 800, StrictLoad: true})
+	if err == nil {
+		t.Fatal("Expected error for truncated image")
+	}
+	if err.Error() == "" {
+		t.Fatal("Expected the libvips warning as error message")
+	}
+}
+
+func TestResizeDefaultQuality(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+	tests := []struct {
+		format  ImageType
+		quality int
+	}{
+		{JPEG, Quality},
+		{WEBP, 75},
+	}
+
+	for _, test := range tests {
+		defaultImg, err := Resize(buf, Options{Width: 800, Height: 600, Type: test.format})
+		if err != nil {
+			t.Fatalf("Cannot process the image: %#v", err)
+		}
+
+		explicitImg, err := Resize(buf, Options{Width: 800, Height: 600, Type: test.format, Quality: test.quality})
+		if err != nil {
+			t.Fatalf("Cannot process the image: %#v", err)
+		}
+
+		if len(defaultImg) != len(explicitImg) {
+			t.Errorf("Unexpected default quality for %s: %d != %d", ImageTypeName(test.format), len(defaultImg), len(explicitImg))
+		}
+	}
+}
+
+func TestResizeDefaultQualityOverride(t *testing.T) {
+	defer func(quality, compression int) {
+		DefaultJPEGQuality, DefaultPNGCompression = quality, compression
+	}(DefaultJPEGQuality, DefaultPNGCompression)
+	DefaultJPEGQuality, DefaultPNGCompression = 50, 9
+
+	o := applyDefaults(Options{}, JPEG)
+	if o.Quality != 50 || o.Compression != 9 {
+		t.Fatalf("Invalid default quality and compression: %d, %d", o.Quality, o.Compression)
+	}
+
+	buf, _ := Read("fixtures/test.jpg")
+	defaultImg, err := Resize(buf, Options{Width: 800})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	explicitImg, err := Resize(buf, Options{Width: 800, Quality: 50})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	if len(defaultImg) != len(explicitImg) {
+		t.Errorf("Unexpected default quality: %d != %d", len(defaultImg), len(explicitImg))
+	}
+
+	if o := applyDefaults(Options{Quality: 90}, JPEG); o.Quality != 90 {
+		t.Errorf("Quality must take precedence over the default: %d", o.Quality)
+	}
+}
+
+func TestResizeWatermarkFontFileNotFound(t *testing.T) {
+	options := Options{
+		Watermark: Watermark{
+			Text:     "Copy me if you can",
+			FontFile: "fixtures/missing.ttf",
+		},
+	}
+
+	if _, err := Resize(readFile("test.jpg"), options); err == nil {
+		t.Fatal("Expected error for a missing font file")
+	}
+}
+
+func TestResizeWatermarkMargins(t *testing.T) {
+	options := Options{
+		Watermark: Watermark{
+			Text:         "Copy me if you can",
+			Width:        200,
+			NoReplicate:  true,
+			MarginTop:    20,
+			MarginRight:  10,
+			MarginBottom: 20,
+			MarginLeft:   10,
+			Background:   Color{255, 255, 255},
+		},
+	}
+
+	buf, err := Resize(readFile("test.jpg"), options)
 	if err != nil {
-		t.Errorf("Resize(imgData, %#v) error: %#v", options, err)
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	if err := assertSize(buf, 1680, 1050); err != nil {
+		t.Error(err)
 	}
 
-	if DetermineImageType(newImg) != JPEG {
+	options.Watermark.MarginLeft = -10
+	if _, err := Resize(readFile("test.jpg"), options); err == nil {
+		t.Fatal("Expected error for negative margins")
+	}
+}
+
+func TestResizeWatermarkRTL(t *testing.T) {
+	options := Options{
+		Watermark: Watermark{
+			Text:  "שלום\nעולם",
+			Align: TextAlignCentre,
+			RTL:   true,
+			Width: 200,
+		},
+	}
+
+	buf, err := Resize(readFile("test.jpg"), options)
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	if err := assertSize(buf, 1680, 1050); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWatermarkText(t *testing.T) {
+	tests := []struct {
+		text     string
+		rtl      bool
+		expected string
+	}{
+		{"Copy me", false, "Copy me"},
+		{"Copy\r\nme\rif you can", false, "Copy\nme\nif you can"},
+		{"2021 שלום", true, "\u200f2021 שלום"},
+		{"שלום\r\nעולם", true, "\u200fשלום\n\u200fעולם"},
+	}
+
+	for _, test := range tests {
+		if text := watermarkText(test.text, test.rtl); text != test.expected {
+			t.Errorf("Invalid watermark text for %q: %q != %q", test.text, text, test.expected)
+		}
+	}
+}
+
+func TestResizeFitPad(t *testing.T) {
+	options := Options{Width: 400, Height: 400, FitPad: true, Background: Color{255, 0, 0}}
+	buf, err := Resize(readFile("test.jpg"), options)
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	if err := assertSize(buf, 400, 400); err != nil {
+		t.Fatal(err)
+	}
+
+	// The 400x250 image is centred, hence the top rows are padded
+	pixels, _, _, _, err := ToRaw(buf, Options{})
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+	if pixels[0] < 240 || pixels[1] > 15 || pixels[2] > 15 {
+		t.Errorf("Invalid padding pixel: %v", pixels[:3])
+	}
+
+	if _, err := Resize(readFile("test.jpg"), Options{Width: 400, FitPad: true}); err == nil {
+		t.Fatal("Expected error for missing height")
+	}
+}
+
+func TestResizeFitPadSmallImage(t *testing.T) {
+	logo, _ := NewImageColor(50, 50, Color{0, 0, 255}, PNG)
+
+	for _, useResize := range []bool{false, true} {
+		options := Options{Width: 200, Height: 200, FitPad: true, UseResize: useResize, Background: Color{255, 255, 255}}
+		buf, err := Resize(logo, options)
+		if err != nil {
+			t.Fatalf("Cannot process the image: %#v", err)
+		}
+		if err := assertSize(buf, 200, 200); err != nil {
+			t.Fatal(err)
+		}
+
+		// The logo is centred at its size, not enlarged
+		pixels, width, _, bands, err := ToRaw(buf, Options{})
+		if err != nil {
+			t.Fatalf("Cannot read the image: %#v", err)
+		}
+		inside := pixels[(100*width+100)*bands:]
+		if inside[2] < 250 || inside[0] > 5 {
+			t.Errorf("Invalid image pixel: %v", inside[:3])
+		}
+		outside := pixels[(60*width+100)*bands:]
+		if outside[0] < 250 || outside[1] < 250 || outside[2] < 250 {
+			t.Errorf("Invalid padding pixel: %v", outside[:3])
+		}
+	}
+}
+
+func TestConvertFlatten(t *testing.T) {
+	buf, err := Convert(readFile("transparent.png"), JPEG, Options{Background: Color{255, 255, 255}})
+	if err != nil {
+		t.Fatalf("Cannot convert the image: %#v", err)
+	}
+	if DetermineImageType(buf) != JPEG {
 		t.Fatal("Image is not jpeg")
 	}
 
-	size, _ := Size(newImg)
-	if size.Height != options.Height || size.Width != options.Width {
-		t.Fatalf("Invalid image size: %dx%d", size.Width, size.Height)
+	_, _, _, bands, err := ToRaw(buf, Options{})
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+	if bands != 3 {
+		t.Fatalf("Invalid number of bands: %d", bands)
 	}
 
-	Write("fixtures/test_corrupt_out.jpg", newImg)
+	buf, err = Convert(readFile("transparent.png"), WEBP, Options{Width: 100})
+	if err != nil {
+		t.Fatalf("Cannot convert the image: %#v", err)
+	}
+	if metadata, _ := Metadata(buf); metadata.Type != "webp" || !metadata.Alpha || metadata.Size.Width != 100 {
+		t.Fatalf("Invalid converted image: %#v", metadata)
+	}
+
+	if _, err := Convert(readFile("test.jpg"), MAGICK, Options{}); err == nil {
+		t.Fatal("Expected error for unsupported output type")
+	}
 }
 
-func TestNoColorProfile(t *testing.T) {
-	options := Options{Width: 800, Height: 600, NoProfile: true}
+func TestResizePreset(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+	tests := []struct {
+		format  ImageType
+		preset  QualityPreset
+		quality int
+	}{
+		{JPEG, PresetLow, 60},
+		{JPEG, PresetMax, 95},
+		{WEBP, PresetHigh, 80},
+	}
+
+	for _, test := range tests {
+		presetImg, err := Resize(buf, Options{Width: 800, Height: 600, Type: test.format, Preset: test.preset})
+		if err != nil {
+			t.Fatalf("Cannot process the image: %#v", err)
+		}
+
+		explicitImg, err := Resize(buf, Options{Width: 800, Height: 600, Type: test.format, Quality: test.quality})
+		if err != nil {
+			t.Fatalf("Cannot process the image: %#v", err)
+		}
+
+		if len(presetImg) != len(explicitImg) {
+			t.Errorf("Unexpected %s preset quality for %s: %d != %d", test.preset, ImageTypeName(test.format), len(presetImg), len(explicitImg))
+		}
+	}
+}
+
+func TestPresetQuality(t *testing.T) {
+	if q := presetQuality(PresetMedium, JPEG); q != 75 {
+		t.Errorf("Invalid JPEG medium quality: %d", q)
+	}
+	if q := presetQuality(PresetDefault, WEBP); q != 75 {
+		t.Errorf("Invalid WEBP default quality: %d", q)
+	}
+	if q := presetQuality(PresetHigh, PNG); q != Quality {
+		t.Errorf("Invalid PNG high quality: %d", q)
+	}
+	if c := presetCompression(PresetLow); c != 9 {
+		t.Errorf("Invalid low compression: %d", c)
+	}
+	if c := presetCompression(PresetDefault); c != 6 {
+		t.Errorf("Invalid default compression: %d", c)
+	}
+
+	o := applyDefaults(Options{Preset: PresetLow, Quality: 90}, JPEG)
+	if o.Quality != 90 {
+		t.Errorf("Quality must take precedence over the preset: %d", o.Quality)
+	}
+}
+
+func TestResizeAssignSRGBProfile(t *testing.T) {
 	buf, _ := Read("fixtures/test.jpg")
 
+	for _, format := range []ImageType{JPEG, PNG, WEBP} {
+		newImg, err := Resize(buf, Options{Width: 300, Type: format, AssignSRGBProfile: true})
+		if err != nil {
+			t.Fatalf("Cannot process the image: %#v", err)
+		}
+
+		metadata, err := Metadata(newImg)
+		if err != nil {
+			t.Fatalf("Cannot read the image metadata: %#v", err)
+		}
+		if !metadata.Profile {
+			t.Errorf("Expected an embedded profile for %s", ImageTypeName(format))
+		}
+	}
+
+	newImg, err := Resize(buf, Options{Width: 300, AssignSRGBProfile: true, NoProfile: true})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	if metadata, _ := Metadata(newImg); metadata.Profile {
+		t.Error("Unexpected embedded profile along with NoProfile")
+	}
+}
+
+func TestResizeOutputProfile(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+
+	if vipsVersionAtLeast(8, 14) {
+		newImg, err := Resize(buf, Options{Width: 300, OutputProfile: ProfileDisplayP3})
+		if err != nil {
+			t.Fatalf("Cannot process the image: %#v", err)
+		}
+		if metadata, _ := Metadata(newImg); !metadata.Profile {
+			t.Error("Expected an embedded Display P3 profile")
+		}
+	}
+
+	newImg, err := Resize(buf, Options{Width: 300, OutputProfile: ProfileDisplayP3, NoProfile: true})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	if metadata, _ := Metadata(newImg); metadata.Profile {
+		t.Error("Unexpected embedded profile along with NoProfile")
+	}
+
+	if _, err := Resize(buf, Options{Width: 300, OutputProfile: ProfileAdobeRGB}); err == nil {
+		t.Error("Expected error for undefined Adobe RGB profile file")
+	}
+}
+
+func TestNoColourspaceConvert(t *testing.T) {
+	buf, err := initImage("test.jpg").Colourspace(InterpretationBW)
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+
+	options := Options{Width: 800, Height: 600, NoColourspaceConvert: true}
 	newImg, err := Resize(buf, options)
 	if err != nil {
 		t.Errorf("Resize(imgData, %#v) error: %#v", options, err)
 	}
 
-	metadata, err := Metadata(newImg)
-	if metadata.Profile == true {
-		t.Fatal("Invalid profile data")
+	interpretation, err := ImageInterpretation(newImg)
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
 	}
+	if interpretation != InterpretationBW {
+		t.Errorf("Invalid interpretation: %d", interpretation)
+	}
+}
 
-	size, _ := Size(newImg)
-	if size.Height != options.Height || size.Width != options.Width {
-		t.Fatalf("Invalid image size: %dx%d", size.Width, size.Height)
+func TestResizePremultiplyAlpha(t *testing.T) {
+	// Opaque white on the left half, transparent black on the right half
+	raw := make([]byte, 40*40*4)
+	for i := 0; i < 40*40; i++ {
+		if i%40 < 20 {
+			copy(raw[i*4:], []byte{255, 255, 255, 255})
+		}
+	}
+	edges, err := NewImageFromRaw(raw, 40, 40, 4)
+	if err != nil {
+		t.Fatalf("Cannot create the image: %#v", err)
+	}
+
+	for _, premultiply := range []bool{true, false} {
+		buf, err := Resize(edges.Image(), Options{Width: 15, Type: PNG, NoPremultiplyAlpha: !premultiply})
+		if err != nil {
+			t.Fatalf("Cannot process the image: %#v", err)
+		}
+
+		pixels, _, _, bands, err := ToRaw(buf, Options{})
+		if err != nil {
+			t.Fatalf("Cannot read the image: %#v", err)
+		}
+		if bands != 4 {
+			t.Fatalf("Invalid number of bands: %d", bands)
+		}
+
+		halo := false
+		for i := 0; i < len(pixels); i += bands {
+			if pixels[i+3] > 16 && pixels[i] < 200 {
+				halo = true
+			}
+		}
+		if halo == premultiply {
+			t.Errorf("Invalid edge colors with premultiplied alpha %v", premultiply)
+		}
 	}
 }
 
-func TestEmbedExtendColor(t *testing.T) {
-	options := Options{Width: 400, Height: 600, Crop: false, Embed: true, Extend: ExtendWhite, Background: Color{255, 20, 10}}
-	buf, _ := Read("fixtures/test_issue.jpg")
+func TestResizeKeepInterpretation(t *testing.T) {
+	gray, err := initImage("test.jpg").Colourspace(InterpretationBW)
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	gray16, err := initImage("test.png").Colourspace(InterpretationGREY16)
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+
+	tests := []struct {
+		buf      []byte
+		options  Options
+		expected Interpretation
+	}{
+		{gray, Options{Width: 300, KeepInterpretation: true}, InterpretationBW},
+		{gray, Options{Width: 300, KeepInterpretation: true, Type: PNG}, InterpretationBW},
+		{gray, Options{Width: 300}, InterpretationSRGB},
+		{gray, Options{Width: 300, KeepInterpretation: true, Interpretation: InterpretationSRGB}, InterpretationSRGB},
+		{readFile("test.jpg"), Options{Width: 300, KeepInterpretation: true}, InterpretationSRGB},
+		{gray16, Options{Width: 300, KeepInterpretation: true}, InterpretationGREY16},
+	}
+
+	for _, test := range tests {
+		newImg, err := Resize(test.buf, test.options)
+		if err != nil {
+			t.Fatalf("Cannot process the image: %#v", err)
+		}
+
+		interpretation, err := ImageInterpretation(newImg)
+		if err != nil {
+			t.Fatalf("Cannot read the image: %#v", err)
+		}
+		if interpretation != test.expected {
+			t.Errorf("Invalid interpretation for %#v: %d", test.options, interpretation)
+		}
+	}
+}
+
+func TestSkipColourspaceCheck(t *testing.T) {
+	options := Options{Width: 800, Height: 600, SkipColourspaceCheck: true}
+	buf, _ := Read("fixtures/test.jpg")
+
+	newImg, err := Resize(buf, options)
+	if err != nil {
+		t.Errorf("Resize(imgData, %#v) error: %#v", options, err)
+	}
+
+	interpretation, err := ImageInterpretation(newImg)
+	if err != nil {
+		t.Fatalf("Cannot read the image: %#v", err)
+	}
+	if interpretation != InterpretationSRGB {
+		t.Errorf("Invalid interpretation: %d", interpretation)
+	}
+}
+
+func TestEmbedExtendBackground(t *testing.T) {
+	options := Options{Width: 800, Height: 800, Embed: true, Extend: ExtendBackground, Background: Color{255, 255, 255}}
+	buf, _ := Read("fixtures/test.jpg")
 
 	newImg, err := Resize(buf, options)
 	if err != nil {
@@ -209,24 +1808,61 @@ func TestEmbedExtendColor(t *testing.T) {
 		t.Fatalf("Invalid image size: %dx%d", size.Width, size.Height)
 	}
 
-	Write("fixtures/test_extend_white_out.jpg", newImg)
+	pixels, width, _, bands, err := ToRaw(newImg, Options{})
+	if err != nil {
+		t.Fatalf("Cannot read the image pixels: %#v", err)
+	}
+
+	// The top-left corner belongs to the letterbox area
+	if pixels[0] < 250 || pixels[1] < 250 || pixels[2] < 250 {
+		t.Fatalf("Invalid background color: %v (width=%d, bands=%d)", pixels[:3], width, bands)
+	}
+
+	Write("fixtures/test_extend_background_white_out.jpg", newImg)
 }
 
-func TestEmbedExtendWithCustomColor(t *testing.T) {
-	options := Options{Width: 400, Height: 600, Crop: false, Embed: true, Extend: 5, Background: Color{255, 20, 10}}
-	buf, _ := Read("fixtures/test_issue.jpg")
+func TestResizeTrimAuto(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+	letterbox, err := Resize(buf, Options{Width: 800, Height: 800, Embed: true, Extend: ExtendBackground, Background: Color{250, 250, 250}})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
 
-	newImg, err := Resize(buf, options)
+	newImg, err := Resize(letterbox, Options{TrimAuto: true})
 	if err != nil {
-		t.Errorf("Resize(imgData, %#v) error: %#v", options, err)
+		t.Fatalf("Cannot trim the image: %#v", err)
 	}
 
 	size, _ := Size(newImg)
-	if size.Height != options.Height || size.Width != options.Width {
+	if size.Width != 800 || size.Height >= 800 {
 		t.Fatalf("Invalid image size: %dx%d", size.Width, size.Height)
 	}
 
-	Write("fixtures/test_extend_background_out.jpg", newImg)
+	Write("fixtures/test_trim_auto_out.jpg", newImg)
+}
+
+func TestCalculateTrimThreshold(t *testing.T) {
+	corners := [4][3]float64{{250, 250, 250}, {246, 246, 246}, {254, 254, 254}, {250, 250, 250}}
+	background, threshold, uniform := calculateTrimThreshold(corners, 1)
+	if !uniform {
+		t.Fatal("Expected uniform corners")
+	}
+	if background != [3]float64{250, 250, 250} {
+		t.Errorf("Invalid background: %v", background)
+	}
+	if threshold != TrimThreshold {
+		t.Errorf("Invalid threshold: %f", threshold)
+	}
+
+	corners[3] = [3]float64{220, 220, 220}
+	if _, threshold, _ = calculateTrimThreshold(corners, 1); threshold <= TrimThreshold {
+		t.Errorf("Expected a threshold greater than the default: %f", threshold)
+	}
+
+	corners[3] = [3]float64{0, 0, 0}
+	if _, _, uniform = calculateTrimThreshold(corners, 1); uniform {
+		t.Error("Expected not uniform corners")
+	}
 }
 
 func TestGaussianBlur(t *testing.T) {
@@ -246,6 +1882,41 @@ func TestGaussianBlur(t *testing.T) {
 	Write("fixtures/test_gaussian.jpg", newImg)
 }
 
+func TestGaussianBlurEdge(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+
+	for _, edge := range []Extend{ExtendMirror, ExtendCopy, ExtendBackground} {
+		options := Options{Width: 800, Height: 600, Background: Color{255, 255, 255}, GaussianBlur: GaussianBlur{Sigma: 5, Edge: edge}}
+		newImg, err := Resize(buf, options)
+		if err != nil {
+			t.Fatalf("Resize(imgData, %#v) error: %#v", options, err)
+		}
+
+		size, _ := Size(newImg)
+		if size.Height != options.Height || size.Width != options.Width {
+			t.Fatalf("Invalid image size: %dx%d", size.Width, size.Height)
+		}
+	}
+}
+
+func TestGaussianBlurMargin(t *testing.T) {
+	tests := []struct {
+		blur   GaussianBlur
+		margin int
+	}{
+		{GaussianBlur{Sigma: 5}, 10},
+		{GaussianBlur{Sigma: 5, MinAmpl: 0.2}, 10},
+		{GaussianBlur{Sigma: 5, MinAmpl: 0.01}, 17},
+		{GaussianBlur{Sigma: 0.5}, 2},
+	}
+
+	for _, test := range tests {
+		if margin := gaussianBlurMargin(test.blur); margin != test.margin {
+			t.Errorf("Invalid margin for %#v: %d != %d", test.blur, margin, test.margin)
+		}
+	}
+}
+
 func TestSharpen(t *testing.T) {
 	options := Options{Width: 800, Height: 600, Sharpen: Sharpen{Radius: 1, X1: 1.5, Y2: 20, Y3: 50, M1: 1, M2: 2}}
 	buf, _ := Read("fixtures/test.jpg")
@@ -337,6 +2008,35 @@ func TestResizePngWithTransparency(t *testing.T) {
 	Write("fixtures/transparent_out.png", newImg)
 }
 
+func TestCalculateHomography(t *testing.T) {
+	from := [4]Point{{0, 0}, {100, 0}, {100, 100}, {0, 100}}
+	to := [4]Point{{10, 20}, {90, 10}, {110, 120}, {0, 90}}
+
+	matrix, err := calculateHomography(from, to)
+	if err != nil {
+		t.Fatalf("Cannot calculate the homography: %s", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		x, y := from[i].X, from[i].Y
+		w := matrix[6]*x + matrix[7]*y + matrix[8]
+		u := (matrix[0]*x + matrix[1]*y + matrix[2]) / w
+		v := (matrix[3]*x + matrix[4]*y + matrix[5]) / w
+		if math.Abs(u-to[i].X) > 1e-6 || math.Abs(v-to[i].Y) > 1e-6 {
+			t.Errorf("Invalid point mapping: %v -> (%v, %v)", from[i], u, v)
+		}
+	}
+}
+
+func TestCalculateHomographyCollinear(t *testing.T) {
+	from := [4]Point{{0, 0}, {10, 10}, {20, 20}, {30, 30}}
+	to := [4]Point{{0, 0}, {100, 0}, {100, 100}, {0, 100}}
+
+	if _, err := calculateHomography(from, to); err == nil {
+		t.Fatal("Expected error for collinear points")
+	}
+}
+
 func runBenchmarkResize(file string, o Options, b *testing.B) {
 	buf, _ := Read(path.Join("fixtures", file))
 
@@ -0,0 +1,108 @@
+package bimg
+
+/*
+#cgo pkg-config: vips
+#include "vips/vips.h"
+*/
+import "C"
+
+import (
+	"errors"
+)
+
+// TileEdge represents how the partial tiles on the image edges are handled.
+type TileEdge int
+
+const (
+	// TileEdgeTruncate keeps the partial edge tiles smaller than the tile size.
+	TileEdgeTruncate TileEdge = iota
+	// TileEdgePad extends the partial edge tiles to the tile size with black
+	// (or transparent, if the image has an alpha channel) pixels.
+	TileEdgePad
+)
+
+// Tiles splits the given image into a grid of tiles of the given size, in
+// rows from the top-left corner, where adjacent tiles share the given number
+// of overlapping pixels. The partial tiles on the right and bottom edges are
+// handled according to the given TileEdge mode. The image is auto rotated
+// based on its EXIF orientation first. Tiles are encoded in the same image
+// format, or as JPEG if the image format cannot be saved.
+func Tiles(buf []byte, tileWidth, tileHeight, overlap int, edge TileEdge) ([][]byte, error) {
+	defer C.vips_thread_shutdown()
+
+	if tileWidth <= 0 || tileHeight <= 0 {
+		return nil, errors.New("Tile size must be greater than zero")
+	}
+	if overlap < 0 || overlap >= tileWidth || overlap >= tileHeight {
+		return nil, errors.New("Tile overlap must be positive and smaller than the tile size")
+	}
+
+	image, imageType, err := vipsRead(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	image, _, err = rotateAndFlipImage(image, Options{})
+	if err != nil {
+		return nil, err
+	}
+	defer C.g_object_unref(C.gpointer(image))
+
+	// The orientation is already applied to the pixels
+	vipsRemoveOrientation(image)
+
+	format := imageType
+	if !IsTypeSupportedSave(format) {
+		format = JPEG
+	}
+	o := applyDefaults(Options{Type: format}, imageType)
+
+	var tiles [][]byte
+	width, height := int(image.Xsize), int(image.Ysize)
+	for _, top := range tileOffsets(height, tileHeight, overlap) {
+		for _, left := range tileOffsets(width, tileWidth, overlap) {
+			w, h := tileWidth, tileHeight
+			if left+w > width {
+				w = width - left
+			}
+			if top+h > height {
+				h = height - top
+			}
+
+			// vipsExtract releases its input image, hence keep a reference
+			C.g_object_ref(C.gpointer(image))
+			tile, err := vipsExtract(image, left, top, w, h)
+			if err != nil {
+				return nil, err
+			}
+
+			if edge == TileEdgePad && (w < tileWidth || h < tileHeight) {
+				tile, err = vipsEmbed(tile, 0, 0, tileWidth, tileHeight, ExtendBlack, ColorBlack)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			out, err := saveImage(tile, o)
+			if err != nil {
+				return nil, err
+			}
+			tiles = append(tiles, out)
+		}
+	}
+
+	return tiles, nil
+}
+
+// tileOffsets returns the offsets of the tiles of the given size covering
+// the given length, where adjacent tiles share the given overlap.
+func tileOffsets(length, size, overlap int) []int {
+	var offsets []int
+	for offset := 0; offset < length; offset += size - overlap {
+		offsets = append(offsets, offset)
+		if offset+size >= length {
+			break
+		}
+	}
+	return offsets
+}
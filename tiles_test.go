@@ -0,0 +1,80 @@
+package bimg
+
+import (
+	"testing"
+)
+
+func TestTiles(t *testing.T) {
+	buf := readFile("test.jpg")
+	size, _ := Size(buf)
+
+	tiles, err := Tiles(buf, 512, 512, 0, TileEdgeTruncate)
+	if err != nil {
+		t.Fatalf("Cannot split the image: %#v", err)
+	}
+
+	columns := (size.Width + 511) / 512
+	rows := (size.Height + 511) / 512
+	if len(tiles) != columns*rows {
+		t.Fatalf("Invalid number of tiles: %d != %d", len(tiles), columns*rows)
+	}
+
+	if err := assertSize(tiles[0], 512, 512); err != nil {
+		t.Error(err)
+	}
+	last := tiles[len(tiles)-1]
+	if err := assertSize(last, size.Width-(columns-1)*512, size.Height-(rows-1)*512); err != nil {
+		t.Error(err)
+	}
+	if DetermineImageType(last) != JPEG {
+		t.Fatal("Invalid tile image type")
+	}
+}
+
+func TestTilesPad(t *testing.T) {
+	tiles, err := Tiles(readFile("test.jpg"), 500, 400, 100, TileEdgePad)
+	if err != nil {
+		t.Fatalf("Cannot split the image: %#v", err)
+	}
+
+	for _, tile := range tiles {
+		if err := assertSize(tile, 500, 400); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTilesInvalidSize(t *testing.T) {
+	buf := readFile("test.jpg")
+
+	if _, err := Tiles(buf, 0, 100, 0, TileEdgeTruncate); err == nil {
+		t.Error("Expected error for invalid tile size")
+	}
+	if _, err := Tiles(buf, 100, 100, 100, TileEdgeTruncate); err == nil {
+		t.Error("Expected error for invalid tile overlap")
+	}
+}
+
+func TestTileOffsets(t *testing.T) {
+	tests := []struct {
+		length, size, overlap int
+		expected              []int
+	}{
+		{1000, 500, 0, []int{0, 500}},
+		{1001, 500, 0, []int{0, 500, 1000}},
+		{1000, 500, 100, []int{0, 400, 800}},
+		{300, 500, 0, []int{0}},
+	}
+
+	for _, test := range tests {
+		offsets := tileOffsets(test.length, test.size, test.overlap)
+		if len(offsets) != len(test.expected) {
+			t.Fatalf("Invalid offsets for %#v: %v", test, offsets)
+		}
+		for i := range offsets {
+			if offsets[i] != test.expected[i] {
+				t.Fatalf("Invalid offsets for %#v: %v", test, offsets)
+			}
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package bimg
 
 import (
+	"bytes"
 	"regexp"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"unicode/utf8"
 )
 
@@ -25,6 +29,8 @@ const (
 	SVG
 	// MAGICK represents the libmagick compatible genetic image type.
 	MAGICK
+	// JP2K represents the JPEG 2000 image type.
+	JP2K
 )
 
 // ImageType represents an image type value.
@@ -32,9 +38,16 @@ type ImageType int
 
 var (
 	htmlCommentRegex = regexp.MustCompile("(?i)<!--([\\s\\S]*?)-->")
-	svgRegex         = regexp.MustCompile(`(?i)^\s*(?:<\?xml[^>]*>\s*)?(?:<!doctype svg[^>]*>\s*)?<svg[^>]*>[^*]*<\/svg>\s*$`)
+	svgRootRegex     = regexp.MustCompile(`(?i)^\s*(?:<\?xml[^>]*>\s*)?(?:<!doctype svg[^>]*>\s*)?<svg[\s>]`)
+	svgEndRegex      = regexp.MustCompile(`(?i)<\/svg>\s*$`)
 )
 
+// SVGDetectionLimit defines the maximum number of bytes inspected from both
+// the beginning and the end of a buffer to detect SVG images, which must start
+// with an <svg> root element (optionally preceded by the XML declaration, the
+// doctype and comments) and end with its closing tag.
+var SVGDetectionLimit = 32 * 1024
+
 // ImageTypes stores as pairs of image types supported and its alias names.
 var ImageTypes = map[ImageType]string{
 	JPEG:   "jpeg",
@@ -45,6 +58,33 @@ var ImageTypes = map[ImageType]string{
 	PDF:    "pdf",
 	SVG:    "svg",
 	MAGICK: "magick",
+	JP2K:   "jp2k",
+}
+
+// ImageExtensions stores the file name extensions of each image type,
+// the first one being the canonical extension of the image type.
+var ImageExtensions = map[ImageType][]string{
+	JPEG: {"jpg", "jpeg", "jpe", "jfif"},
+	PNG:  {"png"},
+	WEBP: {"webp"},
+	TIFF: {"tif", "tiff"},
+	GIF:  {"gif"},
+	PDF:  {"pdf"},
+	SVG:  {"svg", "svgz"},
+	JP2K: {"jp2", "j2k", "jpf", "jpx"},
+}
+
+// ImageMimeTypes stores the MIME type of each image type, e.g: to define
+// the Content-Type header of the HTTP responses.
+var ImageMimeTypes = map[ImageType]string{
+	JPEG: "image/jpeg",
+	PNG:  "image/png",
+	WEBP: "image/webp",
+	TIFF: "image/tiff",
+	GIF:  "image/gif",
+	PDF:  "application/pdf",
+	SVG:  "image/svg+xml",
+	JP2K: "image/jp2",
 }
 
 // imageMutex is used to provide thread-safe synchronization
@@ -91,18 +131,95 @@ func isBinary(buf []byte) bool {
 }
 
 // IsSVGImage returns true if the given buffer is a valid SVG image.
+// Only SVGDetectionLimit bytes are inspected from both buffer ends.
 func IsSVGImage(buf []byte) bool {
-	return !isBinary(buf) && svgRegex.Match(htmlCommentRegex.ReplaceAll(buf, []byte{}))
+	if isBinary(buf) {
+		return false
+	}
+
+	head, tail := buf, buf
+	if len(buf) > SVGDetectionLimit {
+		head, tail = buf[:SVGDetectionLimit], buf[len(buf)-SVGDetectionLimit:]
+	}
+
+	return svgRootRegex.Match(htmlCommentRegex.ReplaceAll(head, []byte{})) && svgEndRegex.Match(tail)
 }
 
-// DetermineImageType determines the image type format (jpeg, png, webp or tiff)
+// DetermineImageType determines the image type format (jpeg, png, webp, tiff, gif, pdf or svg).
+// Note that gif, pdf and svg images are detected even if the current libvips
+// compilation cannot load them: use IsTypeSupported to check it.
 func DetermineImageType(buf []byte) ImageType {
-	return vipsImageType(buf)
+	imageType := vipsImageType(buf)
+	if imageType == UNKNOWN || imageType == MAGICK {
+		if t := determineImageTypeSignature(buf); t != UNKNOWN {
+			return t
+		}
+	}
+	return imageType
+}
+
+// IsValidImage returns true if libvips can load the given buffer, with any
+// of its loaders, not only the image types natively handled by bimg. Only the
+// image header is read, so the image data itself may still be truncated or
+// corrupted: use Options.StrictLoad to reject these images when processing.
+func IsValidImage(buf []byte) bool {
+	return vipsIsValidImage(buf)
 }
 
-// DetermineImageTypeName determines the image type format by name (jpeg, png, webp or tiff)
+// DetermineImageTypes determines the image types of the given buffers, like
+// DetermineImageType, spreading them over up to GOMAXPROCS goroutines. The
+// image types are returned in the same order as the buffers.
+func DetermineImageTypes(bufs [][]byte) []ImageType {
+	types := make([]ImageType, len(bufs))
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(bufs) {
+		workers = len(bufs)
+	}
+
+	var next int64
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Release the libvips thread resources used by the loaders lookup
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			defer vipsThreadShutdown()
+
+			for {
+				index := int(atomic.AddInt64(&next, 1) - 1)
+				if index >= len(bufs) {
+					return
+				}
+				types[index] = DetermineImageType(bufs[index])
+			}
+		}()
+	}
+	wg.Wait()
+
+	return types
+}
+
+// DetermineImageTypeName determines the image type format by name (jpeg, png, webp, tiff, gif, pdf or svg)
 func DetermineImageTypeName(buf []byte) string {
-	return ImageTypeName(vipsImageType(buf))
+	return ImageTypeName(DetermineImageType(buf))
+}
+
+// determineImageTypeSignature detects the image types not natively loaded
+// by bimg, which are otherwise reported as unknown or magick.
+func determineImageTypeSignature(buf []byte) ImageType {
+	switch {
+	case bytes.HasPrefix(buf, []byte("GIF87a")) || bytes.HasPrefix(buf, []byte("GIF89a")):
+		return GIF
+	case bytes.HasPrefix(buf, []byte("%PDF-")):
+		return PDF
+	case IsSVGImage(buf):
+		return SVG
+	}
+	return UNKNOWN
 }
 
 // IsImageTypeSupportedByVips returns true if the given image type
@@ -162,6 +279,16 @@ func IsTypeNameSupportedSave(t string) bool {
 	return false
 }
 
+// IsSaveSupported checks if the current libvips compilation provides the
+// given save operation by its libvips name (e.g: "jpegsave", "gifsave",
+// "heifsave_buffer"), regardless of the ImageTypes supported by bimg.
+func IsSaveSupported(name string) bool {
+	if name == "" {
+		return false
+	}
+	return vipsIsSaveOperationSupported(name)
+}
+
 // ImageTypeName is used to get the human friendly name of an image format.
 func ImageTypeName(t ImageType) string {
 	imageType := ImageTypes[t]
@@ -170,3 +297,49 @@ func ImageTypeName(t ImageType) string {
 	}
 	return imageType
 }
+
+// ImageTypeFromExtension returns the image type of the given file name
+// extension, with or without the leading dot and case insensitive
+// (e.g: "jpg", ".JPEG" or "tif"), or UNKNOWN if it's not recognized.
+func ImageTypeFromExtension(ext string) ImageType {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	for imageType, extensions := range ImageExtensions {
+		for _, extension := range extensions {
+			if extension == ext {
+				return imageType
+			}
+		}
+	}
+	return UNKNOWN
+}
+
+// Extension returns the canonical file name extension of the given image
+// type, without the leading dot (e.g: "jpg" for JPEG), or an empty string if
+// the image type has no file name extension.
+func Extension(t ImageType) string {
+	if extensions := ImageExtensions[t]; len(extensions) > 0 {
+		return extensions[0]
+	}
+	return ""
+}
+
+// ImageTypeMimeType returns the MIME type of the given image type, or the
+// generic application/octet-stream type if it's unknown (including magick).
+func ImageTypeMimeType(t ImageType) string {
+	if mimeType := ImageMimeTypes[t]; mimeType != "" {
+		return mimeType
+	}
+	return "application/octet-stream"
+}
+
+// MimeType returns the MIME type of the given image buffer, based on its
+// detected image type (see DetermineImageType).
+func MimeType(buf []byte) string {
+	return ImageTypeMimeType(DetermineImageType(buf))
+}
+
+// typeSupportsAlpha returns true if the given image type
+// can be saved with an alpha channel.
+func typeSupportsAlpha(t ImageType) bool {
+	return t == PNG || t == WEBP || t == TIFF || t == JP2K
+}
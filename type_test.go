@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 	"testing"
 )
 
@@ -28,6 +29,34 @@ func TestDeterminateImageType(t *testing.T) {
 	}
 }
 
+func TestDeterminateImageTypes(t *testing.T) {
+	bufs := [][]byte{
+		readFile("test.jpg"),
+		readFile("test.png"),
+		readFile("test.webp"),
+		[]byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;"),
+		[]byte("ab"),
+		nil,
+	}
+	for i, n := 0, len(bufs); i < 100; i++ {
+		bufs = append(bufs, bufs[i%n])
+	}
+
+	types := DetermineImageTypes(bufs)
+	if len(types) != len(bufs) {
+		t.Fatalf("Invalid number of image types: %d", len(types))
+	}
+	for i, buf := range bufs {
+		if types[i] != DetermineImageType(buf) {
+			t.Fatalf("Invalid image type at %d: %s", i, ImageTypeName(types[i]))
+		}
+	}
+
+	if types := DetermineImageTypes(nil); len(types) != 0 {
+		t.Fatalf("Invalid image types for no buffers: %v", types)
+	}
+}
+
 func TestDeterminateImageTypeName(t *testing.T) {
 	files := []struct {
 		name     string
@@ -49,6 +78,46 @@ func TestDeterminateImageTypeName(t *testing.T) {
 	}
 }
 
+func TestDeterminateImageTypeSignature(t *testing.T) {
+	files := []struct {
+		buf      []byte
+		expected string
+	}{
+		{[]byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;"), "gif"},
+		{[]byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n"), "pdf"},
+		{[]byte(`<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"></svg>`), "svg"},
+		{[]byte("\x00\x00\x00\x0cjP  \r\n\x87\n\x00\x00\x00\x14ftypjp2 "), "jp2k"},
+		{[]byte("\xff\x4f\xff\x51\x00\x2f\x00\x00\x00\x00\x01\x00"), "jp2k"},
+		{[]byte("name,value\nfoo,1\nbar,2\n"), "unknown"},
+	}
+
+	for _, file := range files {
+		if name := DetermineImageTypeName(file.buf); name != file.expected {
+			t.Fatalf("Invalid image type: %s != %s", name, file.expected)
+		}
+	}
+}
+
+func TestIsValidImage(t *testing.T) {
+	for _, file := range []string{"test.jpg", "test.png", "test.webp"} {
+		if !IsValidImage(readFile(file)) {
+			t.Errorf("Expected %s to be a valid image", file)
+		}
+	}
+
+	invalid := [][]byte{
+		nil,
+		[]byte("not an image"),
+		readFile("test.jpg")[:10],
+		[]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x00"),
+	}
+	for _, buf := range invalid {
+		if IsValidImage(buf) {
+			t.Errorf("Expected %q to be an invalid image", buf)
+		}
+	}
+}
+
 func TestIsTypeSupported(t *testing.T) {
 	types := []struct {
 		name ImageType
@@ -80,3 +149,114 @@ func TestIsTypeNameSupported(t *testing.T) {
 		}
 	}
 }
+
+func TestIsSaveSupported(t *testing.T) {
+	types := []struct {
+		name     string
+		expected bool
+	}{
+		{"jpegsave", true},
+		{"jpegsave_buffer", true},
+		{"pngsave_buffer", true},
+		{"jpegload", false},
+		{"foosave", false},
+		{"", false},
+	}
+
+	for _, n := range types {
+		if IsSaveSupported(n.name) != n.expected {
+			t.Fatalf("Invalid save support for %s", n.name)
+		}
+	}
+}
+
+func TestImageTypeFromExtension(t *testing.T) {
+	types := []struct {
+		ext      string
+		expected ImageType
+	}{
+		{"jpg", JPEG},
+		{".JPEG", JPEG},
+		{"tif", TIFF},
+		{"tiff", TIFF},
+		{".png", PNG},
+		{"webp", WEBP},
+		{"svgz", SVG},
+		{"bmp", UNKNOWN},
+		{"", UNKNOWN},
+	}
+
+	for _, n := range types {
+		if imageType := ImageTypeFromExtension(n.ext); imageType != n.expected {
+			t.Fatalf("Invalid image type for %s: %s", n.ext, ImageTypeName(imageType))
+		}
+	}
+}
+
+func TestExtension(t *testing.T) {
+	types := []struct {
+		imageType ImageType
+		expected  string
+	}{
+		{JPEG, "jpg"},
+		{TIFF, "tif"},
+		{PNG, "png"},
+		{MAGICK, ""},
+		{UNKNOWN, ""},
+	}
+
+	for _, n := range types {
+		if ext := Extension(n.imageType); ext != n.expected {
+			t.Fatalf("Invalid extension for %s: %s", ImageTypeName(n.imageType), ext)
+		}
+		if n.expected != "" && ImageTypeFromExtension(n.expected) != n.imageType {
+			t.Fatalf("Invalid image type for the %s extension", n.expected)
+		}
+	}
+}
+
+func TestMimeType(t *testing.T) {
+	files := []struct {
+		buf      []byte
+		expected string
+	}{
+		{readFile("test.jpg"), "image/jpeg"},
+		{readFile("test.png"), "image/png"},
+		{readFile("test.webp"), "image/webp"},
+		{[]byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;"), "image/gif"},
+		{[]byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n"), "application/pdf"},
+		{[]byte("name,value\nfoo,1\nbar,2\n"), "application/octet-stream"},
+	}
+
+	for _, file := range files {
+		if mimeType := MimeType(file.buf); mimeType != file.expected {
+			t.Errorf("Invalid MIME type: %s != %s", mimeType, file.expected)
+		}
+	}
+
+	if ImageTypeMimeType(SVG) != "image/svg+xml" || ImageTypeMimeType(MAGICK) != "application/octet-stream" {
+		t.Error("Invalid image type MIME type")
+	}
+}
+
+func TestIsSVGImage(t *testing.T) {
+	padding := strings.Repeat(`<rect width="10" height="10"/>`, 4096)
+
+	files := []struct {
+		buf      []byte
+		expected bool
+	}{
+		{[]byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), true},
+		{[]byte(`<?xml version="1.0"?><!-- comment --><svg xmlns="http://www.w3.org/2000/svg"></svg>` + "\n"), true},
+		{[]byte(`<svg xmlns="http://www.w3.org/2000/svg">` + padding + `</svg>`), true},
+		{[]byte(`<html><body><svg></svg></body></html>`), false},
+		{[]byte(`<svgfoo></svgfoo>`), false},
+		{[]byte("name,value\n<svg>,1\n</svg>,2\n"), false},
+	}
+
+	for _, file := range files {
+		if IsSVGImage(file.buf) != file.expected {
+			t.Fatalf("Invalid SVG detection for %.40q", file.buf)
+		}
+	}
+}
@@ -10,9 +10,12 @@ import (
 	"errors"
 	"math"
 	"os"
+	"regexp"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 	"unsafe"
 
 	d "github.com/visionmedia/go-debug"
@@ -31,6 +34,10 @@ const HasMagickSupport = int(C.VIPS_MAGICK_SUPPORT) == 1
 const (
 	maxCacheMem  = 100 * 1024 * 1024
 	maxCacheSize = 500
+	// defaultTileSize defines the default JPEG 2000 tile width and height.
+	defaultTileSize = 512
+	// edgeBlurSigma defines the gaussian blur of the ExtendEdgeBlur padding.
+	edgeBlurSigma = 20
 )
 
 var (
@@ -38,6 +45,37 @@ var (
 	initialized bool
 )
 
+// uncachedMutex guards the number of calls running with the operation
+// cache disabled and the cache size to restore once all of them are done.
+var (
+	uncachedMutex sync.Mutex
+	uncachedCalls int
+	uncachedMax   C.int
+)
+
+// OperationHook, if defined, is called with the name and duration of the
+// internal libvips operations (e.g: read, shrink, affine or save), in order
+// to attribute the processing latency to specific stages. Since libvips
+// evaluates images lazily, most of the pixel processing time is attributed
+// to the save operation. It's not guarded, so it must be defined before
+// processing any image.
+var OperationHook func(op string, d time.Duration)
+
+// traceOperation calls the operation hook with the duration of the given
+// operation once the returned function is called. It's a no-op if the hook
+// is not defined.
+func traceOperation(op string) func() {
+	hook := OperationHook
+	if hook == nil {
+		return func() {}
+	}
+
+	start := time.Now()
+	return func() {
+		hook(op, time.Since(start))
+	}
+}
+
 // VipsMemoryInfo represents the memory stats provided by libvips.
 type VipsMemoryInfo struct {
 	Memory          int64
@@ -45,28 +83,68 @@ type VipsMemoryInfo struct {
 	Allocations     int64
 }
 
+// VipsCacheInfo represents the operation cache stats provided by libvips.
+// libvips does not track the cache hits and misses, so the effectiveness of
+// the cache must be inferred from the number of cached operations over time.
+type VipsCacheInfo struct {
+	Size     int
+	Max      int
+	MaxMem   int64
+	MaxFiles int
+}
+
+// VipsBuildInfo represents the libvips version and features
+// supported by the current libvips compilation.
+type VipsBuildInfo struct {
+	Version       string
+	Major         int
+	Minor         int
+	Micro         int
+	MagickSupport bool
+	Load          []string
+	Save          []string
+}
+
 // vipsSaveOptions represents the internal option used to talk with libvips.
 type vipsSaveOptions struct {
-	Quality        int
-	Compression    int
-	Type           ImageType
-	Interlace      bool
-	NoProfile      bool
-	Interpretation Interpretation
+	Quality              int
+	Compression          int
+	Type                 ImageType
+	JPEGProgressive      bool
+	PNGInterlace         bool
+	NoProfile            bool
+	AssignSRGBProfile    bool
+	OutputProfile        OutputProfile
+	NoColourspaceConvert bool
+	SkipColourspaceCheck bool
+	NoSubsample          bool
+	QuantTable           int
+	RestartInterval      int
+	Lossless             bool
+	TileWidth            int
+	TileHeight           int
+	PaletteColors        int
+	Dither               float64
+	Interpretation       Interpretation
 }
 
 type vipsWatermarkOptions struct {
-	Width       C.int
-	DPI         C.int
-	Margin      C.int
-	NoReplicate C.int
-	Opacity     C.float
-	Background  [3]C.double
+	Width        C.int
+	DPI          C.int
+	MarginTop    C.int
+	MarginRight  C.int
+	MarginBottom C.int
+	MarginLeft   C.int
+	NoReplicate  C.int
+	Align        C.int
+	Opacity      C.float
+	Background   [3]C.double
 }
 
 type vipsWatermarkTextOptions struct {
-	Text *C.char
-	Font *C.char
+	Text     *C.char
+	Font     *C.char
+	FontFile *C.char
 }
 
 func init() {
@@ -74,7 +152,9 @@ func init() {
 }
 
 // Initialize is used to explicitly start libvips in thread-safe way.
-// Only call this function if you have previously turned off libvips.
+// libvips is started when the package is loaded and kept running until the
+// process exits, since it cannot be restarted once shut down, hence calling
+// Initialize again is no-op.
 func Initialize() {
 	if C.VIPS_MAJOR_VERSION <= 7 && C.VIPS_MINOR_VERSION < 40 {
 		panic("unsupported libvips version!")
@@ -85,6 +165,10 @@ func Initialize() {
 	defer m.Unlock()
 	defer runtime.UnlockOSThread()
 
+	if initialized {
+		return
+	}
+
 	err := C.vips_init(C.CString("bimg"))
 	if err != 0 {
 		panic("unable to start vips!")
@@ -108,17 +192,55 @@ func Initialize() {
 	initialized = true
 }
 
-// Shutdown is used to shutdown libvips in a thread-safe way.
-// You can call this to drop caches as well.
-// If libvips was already initialized, the function is no-op
-func Shutdown() {
+// Config represents the libvips runtime settings used by InitializeWithConfig.
+// Concurrency defaults to GOMAXPROCS, which is aware of the container CPU
+// limits, while the cache limits default to the bimg ones. ReportLeaks makes
+// libvips report the leaked objects and memory when the process exits.
+type Config struct {
+	Concurrency   int
+	MaxCacheMem   int
+	MaxCacheSize  int
+	MaxCacheFiles int
+	ReportLeaks   bool
+}
+
+// InitializeWithConfig applies the given runtime settings to libvips, which
+// is started when the package is loaded. It can be called at any time to
+// change them and, unlike Initialize, it takes no reference.
+func InitializeWithConfig(config Config) {
 	m.Lock()
 	defer m.Unlock()
 
-	if initialized {
-		C.vips_shutdown()
-		initialized = false
+	if config.Concurrency <= 0 {
+		config.Concurrency = runtime.GOMAXPROCS(0)
+	}
+	if config.MaxCacheMem <= 0 {
+		config.MaxCacheMem = maxCacheMem
 	}
+	if config.MaxCacheSize <= 0 {
+		config.MaxCacheSize = maxCacheSize
+	}
+
+	C.vips_concurrency_set(C.int(config.Concurrency))
+	C.vips_cache_set_max_mem(C.size_t(config.MaxCacheMem))
+	C.vips_cache_set_max(C.int(config.MaxCacheSize))
+	if config.MaxCacheFiles > 0 {
+		C.vips_cache_set_max_files(C.int(config.MaxCacheFiles))
+	}
+	C.vips_leak_set(C.gboolean(boolToInt(config.ReportLeaks)))
+}
+
+// Shutdown drops the libvips caches in a thread-safe way. Since libvips
+// cannot be restarted, it's never actually shut down, so bimg keeps working
+// after any sequence of Shutdown and Initialize calls (e.g: when a plugin host
+// restarts its subsystems).
+func Shutdown() {
+	C.vips_cache_drop_all()
+}
+
+// vipsThreadShutdown frees the libvips resources of the current thread.
+func vipsThreadShutdown() {
+	C.vips_thread_shutdown()
 }
 
 // VipsDebugInfo outputs to stdout libvips collected data. Useful for debugging.
@@ -126,6 +248,20 @@ func VipsDebugInfo() {
 	C.im__print_all()
 }
 
+// VipsErrorBuffer returns the messages currently held in the libvips error
+// buffer, without clearing it, e.g: to inspect the errors reported by the
+// libvips operations which did not fail, or by the concurrent calls. The
+// error buffer is global. The messages of the failed bimg operations are
+// consumed and returned as errors instead.
+func VipsErrorBuffer() string {
+	return C.GoString(C.vips_error_buffer())
+}
+
+// VipsClearError clears the libvips error buffer.
+func VipsClearError() {
+	C.vips_error_clear()
+}
+
 // VipsMemory gets memory info stats from libvips (cache size, memory allocs...)
 func VipsMemory() VipsMemoryInfo {
 	return VipsMemoryInfo{
@@ -135,6 +271,72 @@ func VipsMemory() VipsMemoryInfo {
 	}
 }
 
+// VipsCacheStats gets the libvips operation cache stats: the number of
+// operations currently cached and the configured cache limits.
+func VipsCacheStats() VipsCacheInfo {
+	return VipsCacheInfo{
+		Size:     int(C.vips_cache_get_size()),
+		Max:      int(C.vips_cache_get_max()),
+		MaxMem:   int64(C.vips_cache_get_max_mem()),
+		MaxFiles: int(C.vips_cache_get_max_files()),
+	}
+}
+
+// vipsDisableCache disables the libvips operation cache, dropping the cached
+// operations, until the returned function is called. Since the cache is global,
+// it's disabled for all the concurrent calls, until all the ones that disabled
+// it are done and the previous cache size is restored.
+func vipsDisableCache() func() {
+	uncachedMutex.Lock()
+	if uncachedCalls == 0 {
+		uncachedMax = C.vips_cache_get_max()
+		C.vips_cache_set_max(0)
+	}
+	uncachedCalls++
+	uncachedMutex.Unlock()
+
+	return func() {
+		uncachedMutex.Lock()
+		uncachedCalls--
+		if uncachedCalls == 0 {
+			C.vips_cache_set_max(uncachedMax)
+		}
+		uncachedMutex.Unlock()
+	}
+}
+
+// VipsInfo returns the libvips version and the image types that can be
+// loaded and saved by the current libvips compilation.
+func VipsInfo() VipsBuildInfo {
+	info := VipsBuildInfo{
+		Version:       VipsVersion,
+		Major:         int(C.VIPS_MAJOR_VERSION),
+		Minor:         int(C.VIPS_MINOR_VERSION),
+		Micro:         int(C.VIPS_MICRO_VERSION),
+		MagickSupport: HasMagickSupport,
+		Load:          []string{},
+		Save:          []string{},
+	}
+
+	types := make([]int, 0, len(ImageTypes))
+	for imageType := range ImageTypes {
+		types = append(types, int(imageType))
+	}
+	sort.Ints(types)
+
+	for _, t := range types {
+		supported := IsImageTypeSupportedByVips(ImageType(t))
+		if supported.Load {
+			info.Load = append(info.Load, ImageTypeName(ImageType(t)))
+		}
+		if supported.Save {
+			info.Save = append(info.Save, ImageTypeName(ImageType(t)))
+		}
+	}
+
+	return info
+}
+
 // VipsIsTypeSupported returns true if the given image type
 // is supported by the current libvips compilation.
 func VipsIsTypeSupported(t ImageType) bool {
@@ -162,9 +364,23 @@ func VipsIsTypeSupported(t ImageType) bool {
 	if t == MAGICK {
 		return int(C.vips_type_find_bridge(C.MAGICK)) != 0
 	}
+	if t == JP2K {
+		return int(C.vips_type_find_bridge(C.JP2K)) != 0
+	}
 	return false
 }
 
+// vipsIsSaveOperationSupported returns true if the current libvips compilation
+// provides the given save operation nickname (e.g: jpegsave or gifsave_buffer).
+func vipsIsSaveOperationSupported(name string) bool {
+	basename := C.CString("VipsForeignSave")
+	nickname := C.CString(name)
+	defer C.free(unsafe.Pointer(basename))
+	defer C.free(unsafe.Pointer(nickname))
+
+	return int(C.vips_type_find(basename, nickname)) != 0
+}
+
 // VipsIsTypeSupportedSave returns true if the given image type
 // is supported by the current libvips compilation for the
 // save operation.
@@ -181,11 +397,27 @@ func VipsIsTypeSupportedSave(t ImageType) bool {
 	if t == TIFF {
 		return int(C.vips_type_find_save_bridge(C.TIFF)) != 0
 	}
+	if t == JP2K {
+		return int(C.vips_type_find_save_bridge(C.JP2K)) != 0
+	}
 	return false
 }
 
 func vipsExifOrientation(image *C.VipsImage) int {
-	return int(C.vips_exif_orientation(image))
+	orientation := int(C.vips_exif_orientation(image))
+
+	// Older libvips versions only parse the EXIF metadata of JPEG images
+	if orientation == 0 {
+		if exif, err := vipsImageBlob(image, "exif-data"); err == nil && exif != nil {
+			orientation = exifOrientation(exif)
+		}
+	}
+
+	return orientation
+}
+
+func vipsRemoveOrientation(image *C.VipsImage) {
+	C.remove_orientation(image)
 }
 
 func vipsHasAlpha(image *C.VipsImage) bool {
@@ -202,11 +434,17 @@ func vipsWindowSize(name string) float64 {
 	return float64(C.interpolator_window_size(cname))
 }
 
+// vipsPixelSize returns the size, in bytes, of a pixel of the given image.
+func vipsPixelSize(image *C.VipsImage) int64 {
+	return int64(image.Bands) * int64(C.vips_format_sizeof(image.BandFmt))
+}
+
 func vipsSpace(image *C.VipsImage) string {
 	return C.GoString(C.vips_enum_nick_bridge(image))
 }
 
 func vipsRotate(image *C.VipsImage, angle Angle) (*C.VipsImage, error) {
+	defer traceOperation("rotate")()
 	var out *C.VipsImage
 	defer C.g_object_unref(C.gpointer(image))
 
@@ -219,6 +457,7 @@ func vipsRotate(image *C.VipsImage, angle Angle) (*C.VipsImage, error) {
 }
 
 func vipsFlip(image *C.VipsImage, direction Direction) (*C.VipsImage, error) {
+	defer traceOperation("flip")()
 	var out *C.VipsImage
 	defer C.g_object_unref(C.gpointer(image))
 
@@ -231,6 +470,7 @@ func vipsFlip(image *C.VipsImage, direction Direction) (*C.VipsImage, error) {
 }
 
 func vipsZoom(image *C.VipsImage, zoom int) (*C.VipsImage, error) {
+	defer traceOperation("zoom")()
 	var out *C.VipsImage
 	defer C.g_object_unref(C.gpointer(image))
 
@@ -243,6 +483,7 @@ func vipsZoom(image *C.VipsImage, zoom int) (*C.VipsImage, error) {
 }
 
 func vipsWatermark(image *C.VipsImage, w Watermark) (*C.VipsImage, error) {
+	defer traceOperation("watermark")()
 	var out *C.VipsImage
 
 	// Defaults
@@ -255,8 +496,25 @@ func vipsWatermark(image *C.VipsImage, w Watermark) (*C.VipsImage, error) {
 	font := C.CString(w.Font)
 	background := [3]C.double{C.double(w.Background.R), C.double(w.Background.G), C.double(w.Background.B)}
 
-	textOpts := vipsWatermarkTextOptions{text, font}
-	opts := vipsWatermarkOptions{C.int(w.Width), C.int(w.DPI), C.int(w.Margin), C.int(noReplicate), C.float(w.Opacity), background}
+	var fontFile *C.char
+	if w.FontFile != "" {
+		fontFile = C.CString(w.FontFile)
+		defer C.free(unsafe.Pointer(fontFile))
+	}
+
+	// The text is offset by 100 pixels within its tile, unless
+	// any edge margin is defined
+	top, right, bottom, left := w.MarginTop, w.MarginRight, w.MarginBottom, w.MarginLeft
+	if top == 0 && right == 0 && bottom == 0 && left == 0 {
+		top, right, bottom, left = 100, w.Margin-100, w.Margin-100, 100
+	}
+
+	textOpts := vipsWatermarkTextOptions{text, font, fontFile}
+	opts := vipsWatermarkOptions{
+		C.int(w.Width), C.int(w.DPI),
+		C.int(top), C.int(right), C.int(bottom), C.int(left),
+		C.int(noReplicate), C.int(w.Align), C.float(w.Opacity), background,
+	}
 
 	defer C.free(unsafe.Pointer(text))
 	defer C.free(unsafe.Pointer(font))
@@ -270,6 +528,8 @@ func vipsWatermark(image *C.VipsImage, w Watermark) (*C.VipsImage, error) {
 }
 
 func vipsRead(buf []byte) (*C.VipsImage, ImageType, error) {
+	defer traceOperation("read")()
+
 	var image *C.VipsImage
 	imageType := vipsImageType(buf)
 
@@ -288,6 +548,127 @@ func vipsRead(buf []byte) (*C.VipsImage, ImageType, error) {
 	return image, imageType, nil
 }
 
+// vipsReadOptions reads the given buffer passing the given load options, as
+// name and value pairs, to the libvips loader (e.g: shrink=2 or page=1).
+func vipsReadOptions(buf []byte, options map[string]string) (*C.VipsImage, ImageType, error) {
+	if len(options) == 0 {
+		return vipsRead(buf)
+	}
+
+	defer traceOperation("read")()
+
+	var image *C.VipsImage
+	imageType := vipsImageType(buf)
+
+	if imageType == UNKNOWN {
+		return nil, UNKNOWN, errors.New("Unsupported image format")
+	}
+
+	optionString, err := vipsLoadOptionString(options)
+	if err != nil {
+		return nil, UNKNOWN, err
+	}
+
+	coptions := C.CString(optionString)
+	defer C.free(unsafe.Pointer(coptions))
+
+	length := C.size_t(len(buf))
+	imageBuf := unsafe.Pointer(&buf[0])
+
+	code := C.vips_init_image_options(imageBuf, length, coptions, &image)
+	if code != 0 {
+		return nil, UNKNOWN, catchVipsError()
+	}
+
+	return image, imageType, nil
+}
+
+// vipsReadType reads the given buffer with the loader of the given image type
+// (e.g: jpegload_buffer), bypassing the image type detection, and passing the
+// given load options. Unknown image types are detected as usual.
+func vipsReadType(buf []byte, imageType ImageType, options map[string]string) (*C.VipsImage, ImageType, error) {
+	if imageType == UNKNOWN {
+		return vipsReadOptions(buf, options)
+	}
+
+	defer traceOperation("read")()
+	if !IsTypeSupported(imageType) {
+		return nil, UNKNOWN, errors.New("Unsupported input image type")
+	}
+
+	var image *C.VipsImage
+	optionString, err := vipsLoadOptionString(options)
+	if err != nil {
+		return nil, UNKNOWN, err
+	}
+
+	loader := C.CString(ImageTypeName(imageType) + "load_buffer")
+	coptions := C.CString(optionString)
+	defer C.free(unsafe.Pointer(loader))
+	defer C.free(unsafe.Pointer(coptions))
+
+	length := C.size_t(len(buf))
+	imageBuf := unsafe.Pointer(&buf[0])
+
+	code := C.vips_init_image_loader(imageBuf, length, loader, coptions, &image)
+	if code != 0 {
+		return nil, UNKNOWN, catchVipsError()
+	}
+
+	return image, imageType, nil
+}
+
+// vipsVersionAtLeast returns true if the current libvips
+// version is equal or greater than the given one.
+func vipsVersionAtLeast(major, minor int) bool {
+	currentMajor, currentMinor := int(C.VIPS_MAJOR_VERSION), int(C.VIPS_MINOR_VERSION)
+	return currentMajor > major || (currentMajor == major && currentMinor >= minor)
+}
+
+// loadOptionNameRegex matches the libvips loader option names, e.g: fail_on.
+var loadOptionNameRegex = regexp.MustCompile("^[a-z_-]+$")
+
+// vipsLoadOptionString builds the libvips option string, e.g: [page=1,shrink=2],
+// sorting the options by name and loading the image with random access.
+func vipsLoadOptionString(options map[string]string) (string, error) {
+	names := make([]string, 0, len(options))
+	for name, value := range options {
+		if !loadOptionNameRegex.MatchString(name) || strings.ContainsAny(value, ",[]") {
+			return "", errors.New("Invalid load option: " + name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := []string{"access=random"}
+	for _, name := range names {
+		pairs = append(pairs, name+"="+options[name])
+	}
+
+	return "[" + strings.Join(pairs, ",") + "]", nil
+}
+
+func vipsReadRaw(pixels []byte, width, height, bands int) (*C.VipsImage, error) {
+	var image *C.VipsImage
+
+	if width <= 0 || height <= 0 || bands <= 0 {
+		return nil, errors.New("Invalid raw image dimensions")
+	}
+	if len(pixels) != width*height*bands {
+		return nil, errors.New("Raw pixels length does not match the image dimensions")
+	}
+
+	length := C.size_t(len(pixels))
+	imageBuf := unsafe.Pointer(&pixels[0])
+
+	err := C.vips_image_new_from_memory_bridge(imageBuf, length, C.int(width), C.int(height), C.int(bands), &image)
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
 func vipsColourspaceIsSupportedBuffer(buf []byte) (bool, error) {
 	image, _, err := vipsRead(buf)
 	if err != nil {
@@ -335,21 +716,91 @@ func vipsFlattenBackground(image *C.VipsImage, background Color) (*C.VipsImage,
 	return image, nil
 }
 
+func vipsFlattenOver(image, background *C.VipsImage) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+	defer C.g_object_unref(C.gpointer(background))
+
+	err := C.vips_flatten_over_bridge(image, background, &out)
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+	return out, nil
+}
+
+func vipsComposite2(image, overlay *C.VipsImage, blend BlendMode, left, top int) (*C.VipsImage, error) {
+	defer traceOperation("composite")()
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+	defer C.g_object_unref(C.gpointer(overlay))
+
+	err := C.vips_composite2_bridge(image, overlay, &out, C.int(blend), C.int(left), C.int(top))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+	return out, nil
+}
+
+func vipsOpacity(image *C.VipsImage, factor float64) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+
+	err := C.vips_opacity_bridge(image, &out, C.double(factor))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+	return out, nil
+}
+
+func vipsFlattenBackgroundAlpha(image *C.VipsImage, background ColorAlpha) (*C.VipsImage, error) {
+	var outImage *C.VipsImage
+
+	// Fully opaque backgrounds are just regular flatten operations
+	if background.A == 255 {
+		return vipsFlattenBackground(image, Color{background.R, background.G, background.B})
+	}
+
+	backgroundC := [4]C.double{
+		C.double(background.R),
+		C.double(background.G),
+		C.double(background.B),
+		C.double(background.A),
+	}
+
+	if vipsHasAlpha(image) {
+		err := C.vips_flatten_background_alpha_bridge(image, &outImage, (*C.double)(&backgroundC[0]))
+		if int(err) != 0 {
+			return nil, catchVipsError()
+		}
+		C.g_object_unref(C.gpointer(image))
+		image = outImage
+	}
+
+	return image, nil
+}
+
 func vipsPreSave(image *C.VipsImage, o *vipsSaveOptions) (*C.VipsImage, error) {
+	input := image
+
 	// Remove ICC profile metadata
 	if o.NoProfile {
 		C.remove_profile(image)
 	}
 
+	// Keep the image in its current colour space, if required
+	if o.NoColourspaceConvert {
+		return image, nil
+	}
+
 	// Use a default interpretation and cast it to C type
 	if o.Interpretation == 0 {
 		o.Interpretation = InterpretationSRGB
 	}
 	interpretation := C.VipsInterpretation(o.Interpretation)
 
-	// Apply the proper colour space
+	// Apply the proper colour space, skipping the support check if required
 	var outImage *C.VipsImage
-	if vipsColourspaceIsSupported(image) {
+	if o.SkipColourspaceCheck || vipsColourspaceIsSupported(image) {
 		err := C.vips_colourspace_bridge(image, &outImage, interpretation)
 		if int(err) != 0 {
 			return nil, catchVipsError()
@@ -357,33 +808,140 @@ func vipsPreSave(image *C.VipsImage, o *vipsSaveOptions) (*C.VipsImage, error) {
 		image = outImage
 	}
 
+	// Embed the sRGB profile into the sRGB images with no profile, if required
+	if o.AssignSRGBProfile && !o.NoProfile && !vipsHasProfile(image) && vipsInterpretation(image) == InterpretationSRGB {
+		var profiled *C.VipsImage
+		err := C.vips_assign_srgb_profile_bridge(image, &profiled)
+		if image != input {
+			C.g_object_unref(C.gpointer(image))
+		}
+		if int(err) != 0 {
+			return nil, catchVipsError()
+		}
+		image = profiled
+	}
+
+	// Transform the sRGB images into the output profile colour space, if required
+	if o.OutputProfile != ProfileSRGB && !o.NoProfile && vipsInterpretation(image) == InterpretationSRGB {
+		profile, err := vipsOutputProfile(o.OutputProfile)
+		if err != nil {
+			if image != input {
+				C.g_object_unref(C.gpointer(image))
+			}
+			return nil, err
+		}
+
+		cprofile := C.CString(profile)
+		defer C.free(unsafe.Pointer(cprofile))
+
+		var profiled *C.VipsImage
+		code := C.vips_output_profile_bridge(image, &profiled, cprofile)
+		if image != input {
+			C.g_object_unref(C.gpointer(image))
+		}
+		if int(code) != 0 {
+			return nil, catchVipsError()
+		}
+		image = profiled
+	}
+
 	return image, nil
 }
 
+// vipsOutputProfile returns the libvips built-in profile name, or the profile
+// file path, of the given output profile.
+func vipsOutputProfile(p OutputProfile) (string, error) {
+	switch p {
+	case ProfileDisplayP3:
+		if !vipsVersionAtLeast(8, 14) {
+			return "", errors.New("Display P3 profile requires libvips 8.14+")
+		}
+		return "p3", nil
+	case ProfileAdobeRGB:
+		if AdobeRGBProfileFile == "" {
+			return "", errors.New("Adobe RGB profile file is not defined")
+		}
+		if _, err := os.Stat(AdobeRGBProfileFile); err != nil {
+			return "", errors.New("Adobe RGB profile file not found")
+		}
+		return AdobeRGBProfileFile, nil
+	default:
+		return "", errors.New("Unsupported output profile")
+	}
+}
+
 func vipsSave(image *C.VipsImage, o vipsSaveOptions) ([]byte, error) {
+	defer traceOperation("save")()
 	defer C.g_object_unref(C.gpointer(image))
 
+	if o.QuantTable < 0 || o.QuantTable > MaxQuantTable {
+		return nil, errors.New("Invalid JPEG quantization table")
+	}
+	if o.RestartInterval < 0 {
+		return nil, errors.New("JPEG restart interval must be positive")
+	}
+	if o.RestartInterval > 0 && o.Type == JPEG && !vipsVersionAtLeast(8, 13) {
+		return nil, errors.New("JPEG restart interval requires libvips 8.13+")
+	}
+	if o.PaletteColors != 0 && (o.PaletteColors < 2 || o.PaletteColors > 256) {
+		return nil, errors.New("Palette colors must be between 2 and 256")
+	}
+	if o.Dither < 0 || o.Dither > 1 {
+		return nil, errors.New("Dither must be between 0 and 1")
+	}
+
 	tmpImage, err := vipsPreSave(image, &o)
 	if err != nil {
 		return nil, err
 	}
-	defer C.g_object_unref(C.gpointer(tmpImage))
+	if tmpImage != image {
+		defer C.g_object_unref(C.gpointer(tmpImage))
+	}
+
+	// Never encode with the lowest quality just because it was not defined
+	if o.Quality == 0 {
+		o.Quality = defaultQuality(o.Type)
+	}
+	if o.Compression == 0 {
+		o.Compression = DefaultPNGCompression
+	}
+	if o.TileWidth == 0 {
+		o.TileWidth = defaultTileSize
+	}
+	if o.TileHeight == 0 {
+		o.TileHeight = defaultTileSize
+	}
+
+	// Keep the assigned profile, stripping any other metadata
+	strip := C.int(1)
+	if (o.AssignSRGBProfile || o.OutputProfile != ProfileSRGB) && !o.NoProfile && vipsHasProfile(tmpImage) {
+		C.remove_metadata_except_profile(tmpImage)
+		strip = 0
+	}
 
 	length := C.size_t(0)
 	saveErr := C.int(0)
-	interlace := C.int(boolToInt(o.Interlace))
+	jpegProgressive := C.int(boolToInt(o.JPEGProgressive))
+	pngInterlace := C.int(boolToInt(o.PNGInterlace))
 	quality := C.int(o.Quality)
+	lossless := C.int(boolToInt(o.Lossless))
 
 	var ptr unsafe.Pointer
 	switch o.Type {
 	case WEBP:
-		saveErr = C.vips_webpsave_bridge(tmpImage, &ptr, &length, 1, quality)
+		saveErr = C.vips_webpsave_bridge(tmpImage, &ptr, &length, strip, quality, lossless)
+		break
+	case GIF:
+		saveErr = C.vips_gifsave_bridge(tmpImage, &ptr, &length, strip)
+		break
+	case JP2K:
+		saveErr = C.vips_jp2ksave_bridge(tmpImage, &ptr, &length, strip, quality, lossless, C.int(o.TileWidth), C.int(o.TileHeight))
 		break
 	case PNG:
-		saveErr = C.vips_pngsave_bridge(tmpImage, &ptr, &length, 1, C.int(o.Compression), quality, interlace)
+		saveErr = C.vips_pngsave_bridge(tmpImage, &ptr, &length, 1, C.int(o.Compression), quality, pngInterlace, C.int(o.PaletteColors), C.double(o.Dither))
 		break
 	default:
-		saveErr = C.vips_jpegsave_bridge(tmpImage, &ptr, &length, 1, quality, interlace)
+		saveErr = C.vips_jpegsave_bridge(tmpImage, &ptr, &length, strip, quality, jpegProgressive, C.int(boolToInt(o.NoSubsample)), C.int(o.QuantTable), C.int(o.RestartInterval))
 		break
 	}
 
@@ -408,7 +966,7 @@ func getImageBuffer(image *C.VipsImage) ([]byte, error) {
 	quality := C.int(100)
 
 	err := C.int(0)
-	err = C.vips_jpegsave_bridge(image, &ptr, &length, 1, quality, interlace)
+	err = C.vips_jpegsave_bridge(image, &ptr, &length, 1, quality, interlace, 0, 0, 0)
 	if int(err) != 0 {
 		return nil, catchVipsError()
 	}
@@ -420,6 +978,7 @@ func getImageBuffer(image *C.VipsImage) ([]byte, error) {
 }
 
 func vipsExtract(image *C.VipsImage, left, top, width, height int) (*C.VipsImage, error) {
+	defer traceOperation("extract")()
 	var buf *C.VipsImage
 	defer C.g_object_unref(C.gpointer(image))
 
@@ -436,49 +995,352 @@ func vipsExtract(image *C.VipsImage, left, top, width, height int) (*C.VipsImage
 	return buf, nil
 }
 
-func vipsShrinkJpeg(buf []byte, input *C.VipsImage, shrink int) (*C.VipsImage, error) {
-	var image *C.VipsImage
-	var ptr = unsafe.Pointer(&buf[0])
-	defer C.g_object_unref(C.gpointer(input))
+func vipsCheckerboard(image *C.VipsImage, size int, c1, c2 Color) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
 
-	err := C.vips_jpegload_buffer_shrink(ptr, C.size_t(len(buf)), &image, C.int(shrink))
+	c1C := [3]C.double{C.double(c1.R), C.double(c1.G), C.double(c1.B)}
+	c2C := [3]C.double{C.double(c2.R), C.double(c2.G), C.double(c2.B)}
+
+	err := C.vips_checkerboard_bridge(image, &out, C.int(size), &c1C[0], &c2C[0])
 	if err != 0 {
 		return nil, catchVipsError()
 	}
-
-	return image, nil
+	return out, nil
 }
 
-func vipsShrink(input *C.VipsImage, shrink int) (*C.VipsImage, error) {
-	var image *C.VipsImage
-	defer C.g_object_unref(C.gpointer(input))
+func vipsReplicate(image *C.VipsImage, across, down int) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
 
-	err := C.vips_shrink_bridge(input, &image, C.double(float64(shrink)), C.double(float64(shrink)))
+	err := C.vips_replicate_bridge(image, &out, C.int(across), C.int(down))
 	if err != 0 {
 		return nil, catchVipsError()
 	}
 
-	return image, nil
+	return out, nil
 }
 
-func vipsEmbed(input *C.VipsImage, left int, top int, width int, height int, extend Extend) (*C.VipsImage, error) {
-	var image *C.VipsImage
-	defer C.g_object_unref(C.gpointer(input))
+func vipsSmartCropAttention(image *C.VipsImage, width, height int) (int, int, error) {
+	var x, y C.int
 
-	if extend > 5 {
-		extend = ExtendBackground
+	err := C.vips_smartcrop_attention_bridge(image, C.int(width), C.int(height), &x, &y)
+	if err != 0 {
+		return 0, 0, catchVipsError()
 	}
 
-	err := C.vips_embed_bridge(input, &image, C.int(left), C.int(top), C.int(width), C.int(height), C.int(extend))
+	return int(x), int(y), nil
+}
+
+func vipsImageBlob(image *C.VipsImage, name string) ([]byte, error) {
+	var data unsafe.Pointer
+	var length C.size_t
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	err := C.vips_image_get_blob_bridge(image, cname, &data, &length)
 	if err != 0 {
 		return nil, catchVipsError()
 	}
+	if data == nil || length == 0 {
+		return nil, nil
+	}
 
-	return image, nil
+	return C.GoBytes(data, C.int(length)), nil
 }
 
-func vipsInsert(main *C.VipsImage, sub *C.VipsImage, left, top int) (*C.VipsImage, error) {
-	var image *C.VipsImage
+func vipsIsOpaque(image *C.VipsImage) (bool, error) {
+	var opaque C.int
+
+	err := C.vips_is_opaque_bridge(image, &opaque)
+	if err != 0 {
+		return false, catchVipsError()
+	}
+
+	return opaque == 1, nil
+}
+
+func vipsEntropy(image *C.VipsImage) (float64, error) {
+	var entropy C.double
+
+	err := C.vips_entropy_bridge(image, &entropy)
+	if err != 0 {
+		return 0, catchVipsError()
+	}
+
+	return float64(entropy), nil
+}
+
+func vipsSharpness(image *C.VipsImage) (float64, error) {
+	var sharpness C.double
+
+	err := C.vips_sharpness_bridge(image, &sharpness)
+	if err != 0 {
+		return 0, catchVipsError()
+	}
+
+	return float64(sharpness), nil
+}
+
+func vipsReplaceColor(image *C.VipsImage, target, replacement Color, tolerance float64, key bool) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+
+	targetC := [3]C.double{C.double(target.R), C.double(target.G), C.double(target.B)}
+	replacementC := [3]C.double{C.double(replacement.R), C.double(replacement.G), C.double(replacement.B)}
+
+	err := C.vips_replace_color_bridge(image, &out, &targetC[0], &replacementC[0], C.double(tolerance), C.int(boolToInt(key)))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+	return out, nil
+}
+
+func vipsColorAdjust(image *C.VipsImage, a ColorAdjust) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+
+	if a.Chroma < -1 {
+		return nil, errors.New("Chroma adjustment must be greater than or equal to -1")
+	}
+
+	err := C.vips_color_adjust_bridge(image, &out, C.double(a.Lightness), C.double(a.Chroma), C.double(a.Hue))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return out, nil
+}
+
+func vipsPageHeight(image *C.VipsImage) int {
+	return int(C.vips_page_height_bridge(image))
+}
+
+func vipsAnimationInfo(image *C.VipsImage) (int, int, []int, error) {
+	var frames, loop, length C.int
+	var delays *C.int
+
+	err := C.vips_animation_info_bridge(image, &frames, &loop, &delays, &length)
+	if err != 0 {
+		return 0, 0, nil, catchVipsError()
+	}
+
+	// Copy the delays, owned by the image
+	var delaysMs []int
+	if delays != nil && length > 0 {
+		values := (*[1 << 20]C.int)(unsafe.Pointer(delays))[:length:length]
+		for _, delay := range values {
+			delaysMs = append(delaysMs, int(delay))
+		}
+	}
+
+	return int(frames), int(loop), delaysMs, nil
+}
+
+// vipsArrayJoin stacks the given images vertically, e.g: animation frames.
+func vipsArrayJoin(images []*C.VipsImage) (*C.VipsImage, error) {
+	defer traceOperation("arrayjoin")()
+	var out *C.VipsImage
+	defer func() {
+		for _, image := range images {
+			C.g_object_unref(C.gpointer(image))
+		}
+	}()
+
+	err := C.vips_arrayjoin_bridge(&images[0], &out, C.int(len(images)))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return out, nil
+}
+
+// vipsSetAnimation defines the animation metadata of the given image, whose
+// frames of the given height are stacked vertically.
+func vipsSetAnimation(image *C.VipsImage, pageHeight, loop int, delays []int) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+
+	delaysC := make([]C.int, len(delays)+1)
+	for i, delay := range delays {
+		delaysC[i] = C.int(delay)
+	}
+
+	err := C.vips_animation_set_bridge(image, &out, C.int(pageHeight), C.int(loop), &delaysC[0], C.int(len(delays)))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return out, nil
+}
+
+func vipsGetPoint(image *C.VipsImage, x, y int) ([3]float64, error) {
+	var pixel [3]C.double
+
+	err := C.vips_getpoint_bridge(image, C.int(x), C.int(y), &pixel[0])
+	if err != 0 {
+		return [3]float64{}, catchVipsError()
+	}
+
+	return [3]float64{float64(pixel[0]), float64(pixel[1]), float64(pixel[2])}, nil
+}
+
+func vipsFindTrim(image *C.VipsImage, background [3]float64, threshold float64) (int, int, int, int, error) {
+	var left, top, width, height C.int
+	backgroundC := [3]C.double{C.double(background[0]), C.double(background[1]), C.double(background[2])}
+
+	err := C.vips_find_trim_bridge(image, &backgroundC[0], C.double(threshold), &left, &top, &width, &height)
+	if err != 0 {
+		return 0, 0, 0, 0, catchVipsError()
+	}
+
+	return int(left), int(top), int(width), int(height), nil
+}
+
+func vipsShrinkJpeg(buf []byte, input *C.VipsImage, shrink int) (*C.VipsImage, error) {
+	defer traceOperation("shrink")()
+	var image *C.VipsImage
+	var ptr = unsafe.Pointer(&buf[0])
+	defer C.g_object_unref(C.gpointer(input))
+
+	err := C.vips_jpegload_buffer_shrink(ptr, C.size_t(len(buf)), &image, C.int(shrink))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsThumbnail(buf []byte, width, height int, gravity Gravity, noAutoRotate, enlarge bool) (*C.VipsImage, error) {
+	defer traceOperation("thumbnail")()
+	var image *C.VipsImage
+	var ptr = unsafe.Pointer(&buf[0])
+	smart := C.int(boolToInt(gravity == GravitySmart))
+
+	err := C.vips_thumbnail_bridge(ptr, C.size_t(len(buf)), &image, C.int(width), C.int(height), smart, C.int(boolToInt(noAutoRotate)), C.int(boolToInt(enlarge)))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsShrink(input *C.VipsImage, shrink int) (*C.VipsImage, error) {
+	defer traceOperation("shrink")()
+	var image *C.VipsImage
+	defer C.g_object_unref(C.gpointer(input))
+
+	err := C.vips_shrink_bridge(input, &image, C.double(float64(shrink)), C.double(float64(shrink)))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsEmbed(input *C.VipsImage, left int, top int, width int, height int, extend Extend, background Color) (*C.VipsImage, error) {
+	if extend == ExtendEdgeBlur {
+		return vipsEmbedEdgeBlur(input, left, top, width, height, background)
+	}
+
+	defer traceOperation("embed")()
+	var image *C.VipsImage
+	defer C.g_object_unref(C.gpointer(input))
+
+	if extend > 5 {
+		extend = ExtendBackground
+	}
+	backgroundC := vipsBackground(input, background)
+
+	err := C.vips_embed_bridge(input, &image, C.int(left), C.int(top), C.int(width), C.int(height), C.int(extend), &backgroundC[0], C.int(len(backgroundC)))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+// vipsEmbedEdgeBlur embeds the image replicating its edges, then blurs the
+// replicated area, while the image itself is kept sharp.
+func vipsEmbedEdgeBlur(input *C.VipsImage, left int, top int, width int, height int, background Color) (*C.VipsImage, error) {
+	// vipsEmbed releases its input image, hence keep a reference to insert it back
+	C.g_object_ref(C.gpointer(input))
+	extended, err := vipsEmbed(input, left, top, width, height, ExtendCopy, background)
+	if err != nil {
+		C.g_object_unref(C.gpointer(input))
+		return nil, err
+	}
+
+	blurred, err := vipsGaussianBlur(extended, GaussianBlur{Sigma: edgeBlurSigma, Edge: ExtendCopy}, background)
+	if err != nil {
+		C.g_object_unref(C.gpointer(input))
+		return nil, err
+	}
+
+	return vipsInsert(blurred, input, left, top)
+}
+
+// compassDirections maps the Gravity values into the libvips compass
+// directions. Any other gravity places the image at the centre.
+var compassDirections = map[Gravity]C.int{
+	GravityCentre:    C.VIPS_COMPASS_DIRECTION_CENTRE,
+	GravityNorth:     C.VIPS_COMPASS_DIRECTION_NORTH,
+	GravityEast:      C.VIPS_COMPASS_DIRECTION_EAST,
+	GravitySouth:     C.VIPS_COMPASS_DIRECTION_SOUTH,
+	GravityWest:      C.VIPS_COMPASS_DIRECTION_WEST,
+	GravityNorthEast: C.VIPS_COMPASS_DIRECTION_NORTH_EAST,
+	GravitySouthEast: C.VIPS_COMPASS_DIRECTION_SOUTH_EAST,
+	GravitySouthWest: C.VIPS_COMPASS_DIRECTION_SOUTH_WEST,
+	GravityNorthWest: C.VIPS_COMPASS_DIRECTION_NORTH_WEST,
+}
+
+func vipsGravity(input *C.VipsImage, gravity Gravity, width, height int, background Color) (*C.VipsImage, error) {
+	defer traceOperation("gravity")()
+	var image *C.VipsImage
+	defer C.g_object_unref(C.gpointer(input))
+
+	backgroundC := vipsBackground(input, background)
+	direction, ok := compassDirections[gravity]
+	if !ok {
+		direction = C.VIPS_COMPASS_DIRECTION_CENTRE
+	}
+
+	err := C.vips_gravity_bridge(input, &image, direction, C.int(width), C.int(height), &backgroundC[0], C.int(len(backgroundC)))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsPremultiply(input *C.VipsImage) (*C.VipsImage, error) {
+	defer traceOperation("premultiply")()
+	var image *C.VipsImage
+	defer C.g_object_unref(C.gpointer(input))
+
+	err := C.vips_premultiply_bridge(input, &image)
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsUnpremultiply(input *C.VipsImage, format C.VipsBandFormat) (*C.VipsImage, error) {
+	defer traceOperation("unpremultiply")()
+	var image *C.VipsImage
+	defer C.g_object_unref(C.gpointer(input))
+
+	err := C.vips_unpremultiply_bridge(input, &image, C.int(format))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsInsert(main *C.VipsImage, sub *C.VipsImage, left, top int) (*C.VipsImage, error) {
+	var image *C.VipsImage
 	defer C.g_object_unref(C.gpointer(main))
 	defer C.g_object_unref(C.gpointer(sub))
 
@@ -491,6 +1353,7 @@ func vipsInsert(main *C.VipsImage, sub *C.VipsImage, left, top int) (*C.VipsImag
 }
 
 func vipsAffine(input *C.VipsImage, residualx, residualy float64, i Interpolator) (*C.VipsImage, error) {
+	defer traceOperation("affine")()
 	var image *C.VipsImage
 	cstring := C.CString(i.String())
 	interpolator := C.vips_interpolate_new(cstring)
@@ -507,8 +1370,106 @@ func vipsAffine(input *C.VipsImage, residualx, residualy float64, i Interpolator
 	return image, nil
 }
 
+func vipsResize(input *C.VipsImage, scalex, scaley float64, kernel Kernel) (*C.VipsImage, error) {
+	defer traceOperation("resize")()
+	var image *C.VipsImage
+	defer C.g_object_unref(C.gpointer(input))
+
+	err := C.vips_resize_bridge(input, &image, C.double(scalex), C.double(scaley), C.int(kernel))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsSetResolution(input *C.VipsImage, xres, yres float64) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	defer C.g_object_unref(C.gpointer(input))
+
+	err := C.vips_copy_resolution_bridge(input, &image, C.double(xres), C.double(yres))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+// vipsResolutionDPI converts the libvips resolution,
+// defined in pixels per millimetre, into pixels per inch.
+func vipsResolutionDPI(res C.double) float64 {
+	return float64(res) * 25.4
+}
+
+func vipsShear(input *C.VipsImage, s Shear, background Color, i Interpolator) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	cstring := C.CString(i.String())
+	interpolator := C.vips_interpolate_new(cstring)
+	backgroundC := vipsBackground(input, background)
+
+	defer C.free(unsafe.Pointer(cstring))
+	defer C.g_object_unref(C.gpointer(input))
+	defer C.g_object_unref(C.gpointer(interpolator))
+
+	b := math.Tan(s.X * math.Pi / 180)
+	c := math.Tan(s.Y * math.Pi / 180)
+
+	err := C.vips_affine_background_bridge(input, &image, 1, C.double(b), C.double(c), 1, interpolator, &backgroundC[0], C.int(len(backgroundC)))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+func vipsPerspective(input *C.VipsImage, matrix [9]float64, i Interpolator) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	cstring := C.CString(i.String())
+	interpolator := C.vips_interpolate_new(cstring)
+
+	defer C.free(unsafe.Pointer(cstring))
+	defer C.g_object_unref(C.gpointer(input))
+	defer C.g_object_unref(C.gpointer(interpolator))
+
+	var matrixC [9]C.double
+	for n, value := range matrix {
+		matrixC[n] = C.double(value)
+	}
+
+	err := C.vips_perspective_bridge(input, &image, &matrixC[0], interpolator)
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+
+	return image, nil
+}
+
+// vipsBackground returns the given color as a pixel matching the bands
+// of the given image, using an opaque value for the alpha channel.
+func vipsBackground(image *C.VipsImage, background Color) []C.double {
+	pixel := []C.double{C.double(background.R), C.double(background.G), C.double(background.B)}
+	if image.Bands < 3 {
+		pixel = []C.double{(pixel[0] + pixel[1] + pixel[2]) / 3}
+	}
+	if vipsHasAlpha(image) {
+		pixel = append(pixel, 255)
+	}
+	return pixel
+}
+
+func vipsIsValidImage(buf []byte) bool {
+	if len(buf) == 0 {
+		return false
+	}
+
+	length := C.size_t(len(buf))
+	imageBuf := unsafe.Pointer(&buf[0])
+	return C.vips_is_valid_image_bridge(imageBuf, length) == 1
+}
+
 func vipsImageType(bytes []byte) ImageType {
-	if len(bytes) == 0 {
+	// Every supported signature is matched within the first 12 bytes
+	if len(bytes) < 12 {
 		return UNKNOWN
 	}
 
@@ -525,6 +1486,10 @@ func vipsImageType(bytes []byte) ImageType {
 		(bytes[0] == 0x4D && bytes[1] == 0x4D && bytes[2] == 0x0 && bytes[3] == 0x2A) {
 		return TIFF
 	}
+	if (string(bytes[4:12]) == "jP  \r\n\x87\n") ||
+		(bytes[0] == 0xFF && bytes[1] == 0x4F && bytes[2] == 0xFF && bytes[3] == 0x51) {
+		return JP2K
+	}
 	if HasMagickSupport && strings.HasSuffix(readImageType(bytes), "MagickBuffer") {
 		return MAGICK
 	}
@@ -554,18 +1519,45 @@ func boolToInt(b bool) int {
 	return 0
 }
 
-func vipsGaussianBlur(image *C.VipsImage, o GaussianBlur) (*C.VipsImage, error) {
+func vipsGaussianBlur(image *C.VipsImage, o GaussianBlur, background Color) (*C.VipsImage, error) {
+	defer traceOperation("blur")()
 	var out *C.VipsImage
-	defer C.g_object_unref(C.gpointer(image))
+	var err error
+
+	// Extend the edges by the mask radius, then blur and crop them back
+	margin := 0
+	if o.Edge != ExtendBlack {
+		margin = gaussianBlurMargin(o)
+		width, height := int(image.Xsize), int(image.Ysize)
+		image, err = vipsEmbed(image, margin, margin, width+margin*2, height+margin*2, o.Edge, background)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	err := C.vips_gaussblur_bridge(image, &out, C.double(o.Sigma), C.double(o.MinAmpl))
-	if err != 0 {
+	width, height := int(image.Xsize)-margin*2, int(image.Ysize)-margin*2
+	code := C.vips_gaussblur_bridge(image, &out, C.double(o.Sigma), C.double(o.MinAmpl))
+	C.g_object_unref(C.gpointer(image))
+	if code != 0 {
 		return nil, catchVipsError()
 	}
+
+	if margin > 0 {
+		return vipsExtract(out, margin, margin, width, height)
+	}
 	return out, nil
 }
 
+func gaussianBlurMargin(o GaussianBlur) int {
+	minAmpl := o.MinAmpl
+	if minAmpl <= 0 || minAmpl >= 1 {
+		minAmpl = 0.2
+	}
+	return int(math.Ceil(o.Sigma*math.Sqrt(-2*math.Log(minAmpl)))) + 1
+}
+
 func vipsSharpen(image *C.VipsImage, o Sharpen) (*C.VipsImage, error) {
+	defer traceOperation("sharpen")()
 	var out *C.VipsImage
 	defer C.g_object_unref(C.gpointer(image))
 
@@ -609,6 +1601,21 @@ func vipsBlack(width, height, bands int) (*C.VipsImage, error) {
 	return out, nil
 }
 
+func vipsCanvas(width, height int, background []float64) (*C.VipsImage, error) {
+	var out *C.VipsImage
+
+	backgroundC := make([]C.double, len(background))
+	for i, value := range background {
+		backgroundC[i] = C.double(value)
+	}
+
+	err := C.vips_canvas_bridge(&out, C.int(width), C.int(height), &backgroundC[0], C.int(len(backgroundC)))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+	return out, nil
+}
+
 func vipsAdd(left, right *C.VipsImage) (*C.VipsImage, error) {
 	var out *C.VipsImage
 	defer C.g_object_unref(C.gpointer(left))
@@ -621,6 +1628,29 @@ func vipsAdd(left, right *C.VipsImage) (*C.VipsImage, error) {
 	return out, nil
 }
 
+func vipsSubtract(left, right *C.VipsImage) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(left))
+	defer C.g_object_unref(C.gpointer(right))
+
+	err := C.vips_subtract_bridge(left, right, &out)
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+	return out, nil
+}
+
+func vipsAbs(image *C.VipsImage) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+
+	err := C.vips_abs_bridge(image, &out)
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+	return out, nil
+}
+
 func vipsMultiply(left, right *C.VipsImage) (*C.VipsImage, error) {
 	var out *C.VipsImage
 	defer C.g_object_unref(C.gpointer(left))
@@ -670,6 +1700,66 @@ func vipsBandjoin2(in1, in2 *C.VipsImage) (*C.VipsImage, error) {
 	return out, nil
 }
 
+func vipsColourspace(image *C.VipsImage, interpretation Interpretation) (*C.VipsImage, error) {
+	defer traceOperation("colourspace")()
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+
+	err := C.vips_colourspace_bridge(image, &out, C.VipsInterpretation(interpretation))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+	return out, nil
+}
+
+func vipsCast(image *C.VipsImage, format C.VipsBandFormat) (*C.VipsImage, error) {
+	var out *C.VipsImage
+	defer C.g_object_unref(C.gpointer(image))
+
+	err := C.vips_cast_bridge(image, &out, C.int(format))
+	if err != 0 {
+		return nil, catchVipsError()
+	}
+	return out, nil
+}
+
+// vipsAreaStats returns the mean and the standard deviation of each colour
+// band of the given area of the image, ignoring the alpha channel, keeping
+// the image.
+func vipsAreaStats(image *C.VipsImage, left, top, width, height int) ([]float64, []float64, error) {
+	bands := int(image.Bands)
+	if vipsHasAlpha(image) {
+		bands--
+	}
+
+	means := make([]C.double, bands)
+	deviations := make([]C.double, bands)
+	err := C.vips_area_stats_bridge(image, C.int(left), C.int(top), C.int(width), C.int(height), &means[0], &deviations[0], C.int(bands))
+	if err != 0 {
+		return nil, nil, catchVipsError()
+	}
+
+	meansGo := make([]float64, bands)
+	deviationsGo := make([]float64, bands)
+	for i := range means {
+		meansGo[i], deviationsGo[i] = float64(means[i]), float64(deviations[i])
+	}
+	return meansGo, deviationsGo, nil
+}
+
+func vipsWriteToMemory(image *C.VipsImage) ([]byte, error) {
+	length := C.size_t(0)
+	defer C.g_object_unref(C.gpointer(image))
+
+	ptr := C.vips_image_write_to_memory_bridge(image, &length)
+	if ptr == nil {
+		return nil, catchVipsError()
+	}
+	defer C.g_free(C.gpointer(ptr))
+
+	return C.GoBytes(ptr, C.int(length)), nil
+}
+
 func max(x int) int {
 	return int(math.Max(float64(x), 0))
 }
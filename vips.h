@@ -22,6 +22,23 @@
 #define VIPS_ANGLE_D270 VIPS_ANGLE_270
 #endif
 
+/**
+ * The compass directions are only defined since libvips 8.6, which the
+ * gravity placement requires, so define them to build with older versions.
+ */
+
+#if (VIPS_MAJOR_VERSION < 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION < 6))
+#define VIPS_COMPASS_DIRECTION_CENTRE 0
+#define VIPS_COMPASS_DIRECTION_NORTH 1
+#define VIPS_COMPASS_DIRECTION_EAST 2
+#define VIPS_COMPASS_DIRECTION_SOUTH 3
+#define VIPS_COMPASS_DIRECTION_WEST 4
+#define VIPS_COMPASS_DIRECTION_NORTH_EAST 5
+#define VIPS_COMPASS_DIRECTION_SOUTH_EAST 6
+#define VIPS_COMPASS_DIRECTION_SOUTH_WEST 7
+#define VIPS_COMPASS_DIRECTION_NORTH_WEST 8
+#endif
+
 #define EXIF_IFD0_ORIENTATION "exif-ifd0-Orientation"
 
 enum types {
@@ -33,19 +50,25 @@ enum types {
 	GIF,
 	PDF,
 	SVG,
-	MAGICK
+	MAGICK,
+	JP2K
 };
 
 typedef struct {
 	const char *Text;
 	const char *Font;
+	const char *FontFile;
 } WatermarkTextOptions;
 
 typedef struct {
 	int    Width;
 	int    DPI;
-	int    Margin;
+	int    MarginTop;
+	int    MarginRight;
+	int    MarginBottom;
+	int    MarginLeft;
 	int    NoReplicate;
+	int    Align;
 	float  Opacity;
 	double Background[3];
 } WatermarkOptions;
@@ -60,6 +83,33 @@ remove_profile(VipsImage *image) {
 	vips_image_remove(image, VIPS_META_ICC_NAME);
 }
 
+static void
+remove_metadata_except_profile(VipsImage *image) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 5))
+	gchar **fields = vips_image_get_fields(image);
+	int i;
+
+	for (i = 0; fields[i] != NULL; i++) {
+		if (
+			vips_isprefix("exif-", fields[i]) ||
+			vips_isprefix("png-comment-", fields[i]) ||
+			strcmp(fields[i], VIPS_META_XMP_NAME) == 0 ||
+			strcmp(fields[i], VIPS_META_IPTC_NAME) == 0
+		) {
+			vips_image_remove(image, fields[i]);
+		}
+	}
+
+	g_strfreev(fields);
+#endif
+}
+
+static void
+remove_orientation(VipsImage *image) {
+	vips_image_remove(image, EXIF_IFD0_ORIENTATION);
+	vips_image_remove(image, "orientation");
+}
+
 static gboolean
 with_interlace(int interlace) {
 	return interlace > 0 ? TRUE : FALSE;
@@ -120,6 +170,42 @@ vips_shrink_bridge(VipsImage *in, VipsImage **out, double xshrink, double yshrin
 	return vips_shrink(in, out, xshrink, yshrink, NULL);
 }
 
+int
+vips_resize_bridge(VipsImage *in, VipsImage **out, double scale, double vscale, int kernel) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 3))
+	VipsKernel vipsKernel = VIPS_KERNEL_LANCZOS3;
+	if (kernel == 1) {
+		vipsKernel = VIPS_KERNEL_NEAREST;
+	} else if (kernel == 2) {
+		vipsKernel = VIPS_KERNEL_LINEAR;
+	} else if (kernel == 3) {
+		vipsKernel = VIPS_KERNEL_CUBIC;
+	} else if (kernel == 4) {
+		vipsKernel = VIPS_KERNEL_LANCZOS2;
+	}
+	return vips_resize(in, out, scale, "vscale", vscale, "kernel", vipsKernel, NULL);
+#else
+	vips_error("bimg", "Resize requires libvips 8.3+");
+	return 1;
+#endif
+}
+
+int
+vips_thumbnail_bridge(void *buf, size_t len, VipsImage **out, int width, int height, int smart, int no_rotate, int enlarge) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 6))
+	return vips_thumbnail_buffer(buf, len, out, width,
+		"height", height,
+		"crop", smart ? VIPS_INTERESTING_ATTENTION : VIPS_INTERESTING_CENTRE,
+		"size", enlarge ? VIPS_SIZE_BOTH : VIPS_SIZE_DOWN,
+		"no_rotate", no_rotate ? TRUE : FALSE,
+		NULL
+	);
+#else
+	vips_error("bimg", "Thumbnail requires libvips 8.6+");
+	return 1;
+#endif
+}
+
 int
 vips_type_find_bridge(int t) {
 	if (t == GIF) {
@@ -146,6 +232,9 @@ vips_type_find_bridge(int t) {
 	if (t == MAGICK) {
 		return vips_type_find("VipsOperation", "magickload");
 	}
+	if (t == JP2K) {
+		return vips_type_find("VipsOperation", "jp2kload");
+	}
 	return 0;
 }
 
@@ -163,6 +252,12 @@ vips_type_find_save_bridge(int t) {
 	if (t == JPEG) {
 		return vips_type_find("VipsOperation", "jpegsave_buffer");
 	}
+	if (t == JP2K) {
+		return vips_type_find("VipsOperation", "jp2ksave_buffer");
+	}
+	if (t == GIF) {
+		return vips_type_find("VipsOperation", "gifsave_buffer");
+	}
 	return 0;
 }
 
@@ -185,6 +280,15 @@ int
 vips_exif_orientation(VipsImage *image) {
 	int orientation = 0;
 	const char *exif;
+
+	// Parsed from the metadata of any format (e.g: EXIF in WebP or PNG, TIFF tags)
+	if (
+		vips_image_get_typeof(image, "orientation") != 0 &&
+		!vips_image_get_int(image, "orientation", &orientation)
+	) {
+		return orientation;
+	}
+
 	if (
 		vips_image_get_typeof(image, EXIF_IFD0_ORIENTATION) != 0 &&
 		!vips_image_get_string(image, EXIF_IFD0_ORIENTATION, &exif)
@@ -213,10 +317,42 @@ vips_zoom_bridge(VipsImage *in, VipsImage **out, int xfac, int yfac) {
 }
 
 int
-vips_embed_bridge(VipsImage *in, VipsImage **out, int left, int top, int width, int height, int extend) {
+vips_embed_bridge(VipsImage *in, VipsImage **out, int left, int top, int width, int height, int extend, double *background, int n) {
+#if (VIPS_MAJOR_VERSION >= 8)
+	if (extend == VIPS_EXTEND_BACKGROUND) {
+		VipsArrayDouble *vipsBackground = vips_array_double_new(background, n);
+		int code = vips_embed(in, out, left, top, width, height,
+			"extend", extend,
+			"background", vipsBackground,
+			NULL
+		);
+		vips_area_unref(VIPS_AREA(vipsBackground));
+		return code;
+	}
+#endif
 	return vips_embed(in, out, left, top, width, height, "extend", extend, NULL);
 }
 
+int
+vips_gravity_bridge(VipsImage *in, VipsImage **out, int direction, int width, int height, double *background, int n) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 6))
+	VipsArrayDouble *vipsBackground;
+	int code;
+
+	vipsBackground = vips_array_double_new(background, n);
+	code = vips_gravity(in, out, (VipsCompassDirection) direction, width, height,
+		"extend", VIPS_EXTEND_BACKGROUND,
+		"background", vipsBackground,
+		NULL
+	);
+	vips_area_unref(VIPS_AREA(vipsBackground));
+	return code;
+#else
+	vips_error("bimg", "Gravity placement requires libvips 8.6+");
+	return 1;
+#endif
+}
+
 int
 vips_insert_bridge(VipsImage *main, VipsImage *sub, VipsImage **out, int left, int top) {
 	return vips_insert(main, sub, out, left, top, NULL);
@@ -243,7 +379,38 @@ vips_colourspace_bridge(VipsImage *in, VipsImage **out, VipsInterpretation space
 }
 
 int
-vips_jpegsave_bridge(VipsImage *in, void **buf, size_t *len, int strip, int quality, int interlace) {
+vips_jpegsave_bridge(VipsImage *in, void **buf, size_t *len, int strip, int quality, int interlace, int no_subsample, int quant_table, int restart_interval) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 13))
+	return vips_jpegsave_buffer(in, buf, len,
+		"strip", strip,
+		"Q", quality,
+		"optimize_coding", TRUE,
+		"interlace", with_interlace(interlace),
+		"no_subsample", no_subsample > 0 ? TRUE : FALSE,
+		"quant_table", quant_table,
+		"restart_interval", restart_interval,
+		NULL
+	);
+#elif (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 5)
+	return vips_jpegsave_buffer(in, buf, len,
+		"strip", strip,
+		"Q", quality,
+		"optimize_coding", TRUE,
+		"interlace", with_interlace(interlace),
+		"no_subsample", no_subsample > 0 ? TRUE : FALSE,
+		"quant_table", quant_table,
+		NULL
+	);
+#elif (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 4)
+	return vips_jpegsave_buffer(in, buf, len,
+		"strip", strip,
+		"Q", quality,
+		"optimize_coding", TRUE,
+		"interlace", with_interlace(interlace),
+		"no_subsample", no_subsample > 0 ? TRUE : FALSE,
+		NULL
+	);
+#else
 	return vips_jpegsave_buffer(in, buf, len,
 		"strip", strip,
 		"Q", quality,
@@ -251,10 +418,25 @@ vips_jpegsave_bridge(VipsImage *in, void **buf, size_t *len, int strip, int qual
 		"interlace", with_interlace(interlace),
 		NULL
 	);
+#endif
 }
 
 int
-vips_pngsave_bridge(VipsImage *in, void **buf, size_t *len, int strip, int compression, int quality, int interlace) {
+vips_pngsave_bridge(VipsImage *in, void **buf, size_t *len, int strip, int compression, int quality, int interlace, int colours, double dither) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 7))
+	if (colours > 0) {
+		return vips_pngsave_buffer(in, buf, len,
+			"strip", FALSE,
+			"compression", compression,
+			"interlace", with_interlace(interlace),
+			"filter", VIPS_FOREIGN_PNG_FILTER_NONE,
+			"palette", TRUE,
+			"colours", colours,
+			"dither", dither,
+			NULL
+		);
+	}
+#endif
 #if (VIPS_MAJOR_VERSION >= 8 || (VIPS_MAJOR_VERSION >= 7 && VIPS_MINOR_VERSION >= 42))
 	return vips_pngsave_buffer(in, buf, len,
 		"strip", FALSE,
@@ -274,12 +456,43 @@ vips_pngsave_bridge(VipsImage *in, void **buf, size_t *len, int strip, int compr
 }
 
 int
-vips_webpsave_bridge(VipsImage *in, void **buf, size_t *len, int strip, int quality) {
+vips_webpsave_bridge(VipsImage *in, void **buf, size_t *len, int strip, int quality, int lossless) {
 	return vips_webpsave_buffer(in, buf, len,
 		"strip", strip,
 		"Q", quality,
+		"lossless", lossless > 0 ? TRUE : FALSE,
+		NULL
+	);
+}
+
+int
+vips_gifsave_bridge(VipsImage *in, void **buf, size_t *len, int strip) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 12))
+	return vips_gifsave_buffer(in, buf, len,
+		"strip", strip,
+		NULL
+	);
+#else
+	vips_error("bimg", "GIF save requires libvips 8.12+");
+	return 1;
+#endif
+}
+
+int
+vips_jp2ksave_bridge(VipsImage *in, void **buf, size_t *len, int strip, int quality, int lossless, int tile_width, int tile_height) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 11))
+	return vips_jp2ksave_buffer(in, buf, len,
+		"strip", strip,
+		"Q", quality,
+		"lossless", lossless > 0 ? TRUE : FALSE,
+		"tile_width", tile_width,
+		"tile_height", tile_height,
 		NULL
 	);
+#else
+	vips_error("bimg", "JPEG 2000 save requires libvips 8.11+");
+	return 1;
+#endif
 }
 
 int
@@ -300,18 +513,93 @@ vips_init_image (void *buf, size_t len, int imageType, VipsImage **out) {
 	} else if (imageType == PNG) {
 		code = vips_pngload_buffer(buf, len, out, "access", VIPS_ACCESS_RANDOM, NULL);
 	} else if (imageType == WEBP) {
+		// Explicitly load only the first frame of animated images
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 8))
+		code = vips_webpload_buffer(buf, len, out, "access", VIPS_ACCESS_RANDOM, "page", 0, "n", 1, NULL);
+#else
 		code = vips_webpload_buffer(buf, len, out, "access", VIPS_ACCESS_RANDOM, NULL);
+#endif
+	} else if (imageType == GIF) {
+		// Frames are decoded already disposed, with their transparency
+		code = vips_gifload_buffer(buf, len, out, "access", VIPS_ACCESS_RANDOM, NULL);
 	} else if (imageType == TIFF) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 5))
+		code = vips_tiffload_buffer(buf, len, out, "access", VIPS_ACCESS_RANDOM, "page", 0, "n", 1, NULL);
+#else
 		code = vips_tiffload_buffer(buf, len, out, "access", VIPS_ACCESS_RANDOM, NULL);
-#if (VIPS_MAJOR_VERSION >= 8)
+#endif
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 5))
+	} else if (imageType == MAGICK) {
+		code = vips_magickload_buffer(buf, len, out, "access", VIPS_ACCESS_RANDOM, "page", 0, "n", 1, NULL);
+#elif (VIPS_MAJOR_VERSION >= 8)
 	} else if (imageType == MAGICK) {
 		code = vips_magickload_buffer(buf, len, out, "access", VIPS_ACCESS_RANDOM, NULL);
+#endif
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 11))
+	} else if (imageType == JP2K) {
+		code = vips_jp2kload_buffer(buf, len, out, "access", VIPS_ACCESS_RANDOM, NULL);
 #endif
 	}
 
 	return code;
 }
 
+int
+vips_is_valid_image_bridge(void *buf, size_t len) {
+	VipsImage *image;
+	int valid;
+
+	if (vips_foreign_find_load_buffer(buf, len) == NULL) {
+		vips_error_clear();
+		return 0;
+	}
+
+	// Only the image header is read, the pixels are decoded lazily
+	image = vips_image_new_from_buffer(buf, len, "", "access", VIPS_ACCESS_SEQUENTIAL, NULL);
+	if (image == NULL) {
+		vips_error_clear();
+		return 0;
+	}
+
+	valid = image->Xsize > 0 && image->Ysize > 0;
+	g_object_unref(image);
+	return valid;
+}
+
+int
+vips_init_image_options (void *buf, size_t len, const char *options, VipsImage **out) {
+	*out = vips_image_new_from_buffer(buf, len, options, NULL);
+	return *out == NULL ? 1 : 0;
+}
+
+int
+vips_init_image_loader (void *buf, size_t len, const char *loader, const char *options, VipsImage **out) {
+	VipsOperation *operation = vips_operation_new(loader);
+	VipsBlob *blob;
+
+	if (operation == NULL) {
+		return 1;
+	}
+
+	blob = vips_blob_new(NULL, buf, len);
+	g_object_set(operation, "buffer", blob, NULL);
+	vips_area_unref(VIPS_AREA(blob));
+
+	if (
+		vips_object_set_from_string(VIPS_OBJECT(operation), options) ||
+		vips_cache_operation_buildp(&operation)
+	) {
+		vips_object_unref_outputs(VIPS_OBJECT(operation));
+		g_object_unref(operation);
+		return 1;
+	}
+
+	g_object_get(operation, "out", out, NULL);
+	vips_object_unref_outputs(VIPS_OBJECT(operation));
+	g_object_unref(operation);
+	return 0;
+}
+
 int
 vips_watermark_replicate (VipsImage *orig, VipsImage *in, VipsImage **out) {
 	VipsImage *cache = vips_image_new();
@@ -330,6 +618,32 @@ vips_watermark_replicate (VipsImage *orig, VipsImage *in, VipsImage **out) {
 	return 0;
 }
 
+int
+vips_watermark_text(VipsImage **out, WatermarkTextOptions *to, WatermarkOptions *o) {
+	if (to->FontFile == NULL) {
+		return vips_text(out, to->Text,
+			"width", o->Width,
+			"dpi", o->DPI,
+			"font", to->Font,
+			"align", o->Align,
+			NULL);
+	}
+
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 9))
+	// libvips fails if fontconfig cannot load the font file
+	return vips_text(out, to->Text,
+		"width", o->Width,
+		"dpi", o->DPI,
+		"font", to->Font,
+		"fontfile", to->FontFile,
+		"align", o->Align,
+		NULL);
+#else
+	vips_error("bimg", "Watermark font file requires libvips 8.9+");
+	return 1;
+#endif
+}
+
 int
 vips_watermark(VipsImage *in, VipsImage **out, WatermarkTextOptions *to, WatermarkOptions *o) {
 	double ones[3] = { 1, 1, 1 };
@@ -340,14 +654,12 @@ vips_watermark(VipsImage *in, VipsImage **out, WatermarkTextOptions *to, Waterma
 
 	// Make the mask.
 	if (
-		vips_text(&t[1], to->Text,
-			"width", o->Width,
-			"dpi", o->DPI,
-			"font", to->Font,
-			NULL) ||
+		vips_watermark_text(&t[1], to, o) ||
 		vips_linear1(t[1], &t[2], o->Opacity, 0.0, NULL) ||
 		vips_cast(t[2], &t[3], VIPS_FORMAT_UCHAR, NULL) ||
-		vips_embed(t[3], &t[4], 100, 100, t[3]->Xsize + o->Margin, t[3]->Ysize + o->Margin, NULL)
+		vips_embed(t[3], &t[4], o->MarginLeft, o->MarginTop,
+			t[3]->Xsize + o->MarginLeft + o->MarginRight,
+			t[3]->Ysize + o->MarginTop + o->MarginBottom, NULL)
 		) {
 		g_object_unref(base);
 		return 1;
@@ -425,6 +737,16 @@ vips_add_bridge(VipsImage *left, VipsImage *right, VipsImage **out) {
 	return vips_add(left, right, out, NULL);
 }
 
+int
+vips_subtract_bridge(VipsImage *left, VipsImage *right, VipsImage **out) {
+	return vips_subtract(left, right, out, NULL);
+}
+
+int
+vips_abs_bridge(VipsImage *in, VipsImage **out) {
+	return vips_abs(in, out, NULL);
+}
+
 int
 vips_multiply_bridge(VipsImage *left, VipsImage *right, VipsImage **out) {
 	return vips_multiply(left, right, out, NULL);
@@ -444,3 +766,701 @@ int
 vips_bandjoin2_bridge(VipsImage *in1, VipsImage *in2, VipsImage **out) {
 	return vips_bandjoin2(in1, in2, out, NULL);
 }
+
+int
+vips_premultiply_bridge(VipsImage *in, VipsImage **out) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 1))
+	double max_alpha = in->Type == VIPS_INTERPRETATION_RGB16 || in->Type == VIPS_INTERPRETATION_GREY16 ? 65535 : 255;
+	return vips_premultiply(in, out, "max_alpha", max_alpha, NULL);
+#else
+	vips_error("bimg", "Premultiply alpha requires libvips 8.1+");
+	return 1;
+#endif
+}
+
+int
+vips_unpremultiply_bridge(VipsImage *in, VipsImage **out, int format) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 1))
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 1);
+	double max_alpha = in->Type == VIPS_INTERPRETATION_RGB16 || in->Type == VIPS_INTERPRETATION_GREY16 ? 65535 : 255;
+
+	// Restore the band format, premultiplied images are float
+	if (
+		vips_unpremultiply(in, &t[0], "max_alpha", max_alpha, NULL) ||
+		vips_cast(t[0], out, format, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	g_object_unref(base);
+	return 0;
+#else
+	vips_error("bimg", "Unpremultiply alpha requires libvips 8.1+");
+	return 1;
+#endif
+}
+
+int
+vips_cast_bridge(VipsImage *in, VipsImage **out, int format) {
+	return vips_cast(in, out, format, NULL);
+}
+
+void *
+vips_image_write_to_memory_bridge(VipsImage *in, size_t *len) {
+#if (VIPS_MAJOR_VERSION >= 8)
+	return vips_image_write_to_memory(in, len);
+#else
+	vips_error("bimg", "raw pixel output requires libvips 8.0+");
+	return NULL;
+#endif
+}
+
+int
+vips_image_new_from_memory_bridge(void *data, size_t len, int width, int height, int bands, VipsImage **out) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 3))
+	VipsInterpretation interpretation = bands < 3 ? VIPS_INTERPRETATION_B_W : VIPS_INTERPRETATION_sRGB;
+	VipsImage *image = vips_image_new_from_memory_copy(data, len, width, height, bands, VIPS_FORMAT_UCHAR);
+	int code;
+
+	if (image == NULL) {
+		return 1;
+	}
+
+	code = vips_copy(image, out, "interpretation", interpretation, NULL);
+	g_object_unref(image);
+	return code;
+#else
+	vips_error("bimg", "raw pixel input requires libvips 8.3+");
+	return 1;
+#endif
+}
+
+int
+vips_canvas_bridge(VipsImage **out, int width, int height, double *background, int bands) {
+	double ones[4] = { 1, 1, 1, 1 };
+
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 3);
+
+	if (
+		vips_black(&t[0], width, height, NULL) ||
+		vips_linear(t[0], &t[1], ones, background, bands, NULL) ||
+		vips_cast(t[1], &t[2], VIPS_FORMAT_UCHAR, NULL) ||
+		vips_copy(t[2], out, "interpretation", bands < 3 ? VIPS_INTERPRETATION_B_W : VIPS_INTERPRETATION_sRGB, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	g_object_unref(base);
+	return 0;
+}
+
+int
+vips_flatten_over_bridge(VipsImage *in, VipsImage *background, VipsImage **out) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 6))
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 4);
+
+	// Resize the background to cover the image, cropping its centre
+	if (
+		vips_thumbnail_image(background, &t[0], in->Xsize,
+			"height", in->Ysize,
+			"crop", VIPS_INTERESTING_CENTRE,
+			"size", VIPS_SIZE_BOTH,
+			NULL
+		) ||
+		vips_colourspace(t[0], &t[1], VIPS_INTERPRETATION_sRGB, NULL) ||
+		vips_colourspace(in, &t[2], VIPS_INTERPRETATION_sRGB, NULL) ||
+		vips_composite2(t[1], t[2], &t[3], VIPS_BLEND_MODE_OVER, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	// Opaque backgrounds result in opaque images
+	if (has_alpha_channel(t[1])) {
+		*out = t[3];
+		g_object_ref(*out);
+	} else if (vips_extract_band(t[3], out, 0, "n", 3, NULL)) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	g_object_unref(base);
+	return 0;
+#else
+	vips_error("bimg", "Flatten over a background image requires libvips 8.6+");
+	return 1;
+#endif
+}
+
+int
+vips_composite2_bridge(VipsImage *base, VipsImage *overlay, VipsImage **out, int blend, int x, int y) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 6))
+	return vips_composite2(base, overlay, out, (VipsBlendMode) blend, "x", x, "y", y, NULL);
+#else
+	vips_error("bimg", "Composite requires libvips 8.6+");
+	return 1;
+#endif
+}
+
+int
+vips_opacity_bridge(VipsImage *in, VipsImage **out, double factor) {
+	double max_alpha = in->BandFmt == VIPS_FORMAT_USHORT ? 65535 : 255;
+
+	// Images without alpha channel get a uniform one at the given opacity
+	if (!has_alpha_channel(in)) {
+		return vips_bandjoin_const1(in, out, max_alpha * factor, NULL);
+	}
+
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 4);
+
+	// Scale the alpha band, keeping the original band format
+	if (
+		vips_extract_band(in, &t[0], 0, "n", in->Bands - 1, NULL) ||
+		vips_extract_band(in, &t[1], in->Bands - 1, NULL) ||
+		vips_linear1(t[1], &t[2], factor, 0.0, NULL) ||
+		vips_cast(t[2], &t[3], in->BandFmt, NULL) ||
+		vips_bandjoin2(t[0], t[3], out, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	g_object_unref(base);
+	return 0;
+}
+
+int
+vips_flatten_background_alpha_bridge(VipsImage *in, VipsImage **out, double background[4]) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 6))
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 1);
+
+	// Composite the image over a semi-transparent background layer
+	if (
+		vips_canvas_bridge(&t[0], in->Xsize, in->Ysize, background, 4) ||
+		vips_composite2(t[0], in, out, VIPS_BLEND_MODE_OVER, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	g_object_unref(base);
+	return 0;
+#else
+	vips_error("bimg", "Flatten with alpha background requires libvips 8.6+");
+	return 1;
+#endif
+}
+
+int
+vips_copy_resolution_bridge(VipsImage *in, VipsImage **out, double xres, double yres) {
+	return vips_copy(in, out, "xres", xres, "yres", yres, NULL);
+}
+
+int
+vips_checkerboard_bridge(VipsImage *in, VipsImage **out, int size, double *c1, double *c2) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 7);
+	int width = in->Xsize, height = in->Ysize;
+
+	// Draw a 2x2 squares tile, then replicate it to cover the whole image
+	if (
+		vips_colourspace(in, &t[0], VIPS_INTERPRETATION_sRGB, NULL) ||
+		vips_canvas_bridge(&t[1], size * 2, size * 2, c1, 3) ||
+		!(t[2] = vips_image_copy_memory(t[1])) ||
+		vips_draw_rect(t[2], c2, 3, size, 0, size, size, "fill", TRUE, NULL) ||
+		vips_draw_rect(t[2], c2, 3, 0, size, size, size, "fill", TRUE, NULL) ||
+		vips_replicate(t[2], &t[3], width / (size * 2) + 1, height / (size * 2) + 1, NULL) ||
+		vips_extract_area(t[3], &t[4], 0, 0, width, height, NULL) ||
+		vips_extract_band(t[0], &t[5], 0, "n", 3, NULL) ||
+		vips_extract_band(t[0], &t[6], 3, NULL) ||
+		vips_ifthenelse(t[6], t[5], t[4], out, "blend", TRUE, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	g_object_unref(base);
+	return 0;
+}
+
+int
+vips_replicate_bridge(VipsImage *in, VipsImage **out, int across, int down) {
+	return vips_replicate(in, out, across, down, NULL);
+}
+
+int
+vips_affine_background_bridge(VipsImage *in, VipsImage **out, double a, double b, double c, double d, VipsInterpolate *interpolator, double *background, int n) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 5))
+	VipsArrayDouble *vipsBackground = vips_array_double_new(background, n);
+	int code = vips_affine(in, out, a, b, c, d,
+		"interpolate", interpolator,
+		"extend", VIPS_EXTEND_BACKGROUND,
+		"background", vipsBackground,
+		NULL
+	);
+	vips_area_unref(VIPS_AREA(vipsBackground));
+	return code;
+#else
+	return vips_affine(in, out, a, b, c, d, "interpolate", interpolator, NULL);
+#endif
+}
+
+int
+vips_perspective_bridge(VipsImage *in, VipsImage **out, double *m, VipsInterpolate *interpolator) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 7))
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 15);
+
+	// Map every output pixel coordinate into the source image: the matrix
+	// is the homography from destination to source points.
+	if (
+		vips_xyz(&t[0], in->Xsize, in->Ysize, NULL) ||
+		vips_extract_band(t[0], &t[1], 0, NULL) ||
+		vips_extract_band(t[0], &t[2], 1, NULL) ||
+		vips_linear1(t[1], &t[3], m[0], m[2], NULL) ||
+		vips_linear1(t[2], &t[4], m[1], 0, NULL) ||
+		vips_add(t[3], t[4], &t[5], NULL) ||
+		vips_linear1(t[1], &t[6], m[3], m[5], NULL) ||
+		vips_linear1(t[2], &t[7], m[4], 0, NULL) ||
+		vips_add(t[6], t[7], &t[8], NULL) ||
+		vips_linear1(t[1], &t[9], m[6], m[8], NULL) ||
+		vips_linear1(t[2], &t[10], m[7], 0, NULL) ||
+		vips_add(t[9], t[10], &t[11], NULL) ||
+		vips_divide(t[5], t[11], &t[12], NULL) ||
+		vips_divide(t[8], t[11], &t[13], NULL) ||
+		vips_bandjoin2(t[12], t[13], &t[14], NULL) ||
+		vips_mapim(in, out, t[14], "interpolate", interpolator, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	g_object_unref(base);
+	return 0;
+#else
+	vips_error("bimg", "perspective transform requires libvips 8.7+");
+	return 1;
+#endif
+}
+
+int
+vips_smartcrop_attention_bridge(VipsImage *in, int width, int height, int *x, int *y) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 8))
+	VipsImage *out;
+
+	if (vips_smartcrop(in, &out, width, height,
+		"interesting", VIPS_INTERESTING_ATTENTION,
+		"attention_x", x,
+		"attention_y", y,
+		NULL
+	)) {
+		return 1;
+	}
+
+	g_object_unref(out);
+	return 0;
+#else
+	vips_error("bimg", "smart crop region requires libvips 8.8+");
+	return 1;
+#endif
+}
+
+int
+vips_output_profile_bridge(VipsImage *in, VipsImage **out, const char *profile) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 7))
+	return vips_icc_transform(in, out, profile,
+		"input_profile", "srgb",
+		"embedded", TRUE,
+		NULL
+	);
+#else
+	vips_error("bimg", "output profile requires libvips 8.7+");
+	return 1;
+#endif
+}
+
+int
+vips_assign_srgb_profile_bridge(VipsImage *in, VipsImage **out) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 7))
+	return vips_icc_transform(in, out, "srgb",
+		"input_profile", "srgb",
+		"embedded", FALSE,
+		NULL
+	);
+#else
+	vips_error("bimg", "assigning the sRGB profile requires libvips 8.7+");
+	return 1;
+#endif
+}
+
+int
+vips_image_get_blob_bridge(VipsImage *in, const char *name, const void **data, size_t *length) {
+	*data = NULL;
+	*length = 0;
+
+	if (vips_image_get_typeof(in, name) == 0) {
+		return 0;
+	}
+
+	return vips_image_get_blob(in, name, (void *) data, length);
+}
+
+int
+vips_is_opaque_bridge(VipsImage *in, int *opaque) {
+	VipsImage *alpha;
+	double min;
+	double max_alpha = in->BandFmt == VIPS_FORMAT_USHORT ? 65535 : 255;
+
+	*opaque = 1;
+	if (!has_alpha_channel(in)) {
+		return 0;
+	}
+
+	if (vips_extract_band(in, &alpha, in->Bands - 1, NULL)) {
+		return 1;
+	}
+
+	if (vips_min(alpha, &min, NULL)) {
+		g_object_unref(alpha);
+		return 1;
+	}
+
+	g_object_unref(alpha);
+	*opaque = min >= max_alpha ? 1 : 0;
+	return 0;
+}
+
+int
+vips_area_stats_bridge(VipsImage *in, int left, int top, int width, int height, double *means, double *deviations, int bands) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 3);
+	int i;
+
+	// Per band statistics of the colour bands, ignoring the alpha channel
+	if (
+		vips_extract_area(in, &t[0], left, top, width, height, NULL) ||
+		vips_extract_band(t[0], &t[1], 0, "n", bands, NULL) ||
+		vips_stats(t[1], &t[2], NULL) ||
+		vips_image_wio_input(t[2])
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	// The first row holds the statistics of all the bands together
+	for (i = 0; i < bands; i++) {
+		means[i] = *VIPS_MATRIX(t[2], 4, i + 1);
+		deviations[i] = *VIPS_MATRIX(t[2], 5, i + 1);
+	}
+
+	g_object_unref(base);
+	return 0;
+}
+
+int
+vips_entropy_bridge(VipsImage *in, double *entropy) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 2);
+	int bands = has_alpha_channel(in) ? in->Bands - 1 : in->Bands;
+	double sum = 0, value;
+	int i, err;
+
+	// Scale the 16-bit images down to 8 bits rather than clipping them
+	if (in->BandFmt == VIPS_FORMAT_USHORT) {
+		err = vips_rshift_const1(in, &t[0], 8, NULL) ||
+			vips_cast(t[0], &t[1], VIPS_FORMAT_UCHAR, NULL);
+		if (!err) {
+			g_object_unref(t[0]);
+			t[0] = t[1];
+			t[1] = NULL;
+		}
+	} else {
+		err = vips_cast(in, &t[0], VIPS_FORMAT_UCHAR, NULL);
+	}
+	if (err) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	// Average the entropy of the colour bands, ignoring the alpha channel
+	for (i = 0; i < bands; i++) {
+		if (
+			vips_hist_find(t[0], &t[1], "band", i, NULL) ||
+			vips_hist_entropy(t[1], &value, NULL)
+		) {
+			g_object_unref(base);
+			return 1;
+		}
+
+		g_object_unref(t[1]);
+		t[1] = NULL;
+		sum += value;
+	}
+
+	g_object_unref(base);
+	*entropy = sum / bands;
+	return 0;
+}
+
+int
+vips_sharpness_bridge(VipsImage *in, double *sharpness) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 5);
+	double deviation;
+
+	// Laplacian of the 0-1 scaled luminance
+	t[3] = vips_image_new_matrixv(3, 3,
+		0.0, 1.0, 0.0,
+		1.0, -4.0, 1.0,
+		0.0, 1.0, 0.0);
+
+	if (
+		t[3] == NULL ||
+		vips_colourspace(in, &t[0], VIPS_INTERPRETATION_B_W, NULL) ||
+		vips_extract_band(t[0], &t[1], 0, NULL) ||
+		vips_linear1(t[1], &t[2], 1.0 / 255.0, 0.0, NULL) ||
+		vips_conv(t[2], &t[4], t[3], "precision", VIPS_PRECISION_FLOAT, NULL) ||
+		vips_deviate(t[4], &deviation, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	g_object_unref(base);
+	*sharpness = deviation * deviation;
+	return 0;
+}
+
+int
+vips_replace_color_bridge(VipsImage *in, VipsImage **out, double *target, double *replacement, double tolerance, int key) {
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 11);
+	double ones[3] = { 1, 1, 1 };
+	double zeros[3] = { 0, 0, 0 };
+	double negative[3] = { -target[0], -target[1], -target[2] };
+	int alpha;
+
+	if (
+		vips_colourspace(in, &t[0], VIPS_INTERPRETATION_sRGB, NULL) ||
+		vips_cast(t[0], &t[1], VIPS_FORMAT_UCHAR, NULL) ||
+		vips_extract_band(t[1], &t[2], 0, "n", 3, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	alpha = has_alpha_channel(t[1]);
+	if (alpha && vips_extract_band(t[1], &t[3], 3, NULL)) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	// Select the pixels whose distance to the target color is within the tolerance
+	if (
+		vips_linear(t[2], &t[4], ones, negative, 3, NULL) ||
+		vips_multiply(t[4], t[4], &t[5], NULL) ||
+		vips_bandmean(t[5], &t[6], NULL) ||
+		vips_relational_const1(t[6], &t[7], VIPS_OPERATION_RELATIONAL_LESSEQ, tolerance * tolerance / 3, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	if (key) {
+		// Make the selected pixels transparent
+		if (vips_invert(t[7], &t[8], NULL)) {
+			g_object_unref(base);
+			return 1;
+		}
+		if (alpha) {
+			if (vips_ifthenelse(t[7], t[8], t[3], &t[9], NULL)) {
+				g_object_unref(base);
+				return 1;
+			}
+		} else {
+			t[9] = t[8];
+			g_object_ref(t[9]);
+		}
+		if (vips_bandjoin2(t[2], t[9], out, NULL)) {
+			g_object_unref(base);
+			return 1;
+		}
+	} else {
+		// Paint the selected pixels with the replacement color
+		if (
+			vips_linear(t[2], &t[8], zeros, replacement, 3, NULL) ||
+			vips_cast(t[8], &t[9], VIPS_FORMAT_UCHAR, NULL) ||
+			vips_ifthenelse(t[7], t[9], t[2], &t[10], NULL)
+		) {
+			g_object_unref(base);
+			return 1;
+		}
+		if (alpha) {
+			if (vips_bandjoin2(t[10], t[3], out, NULL)) {
+				g_object_unref(base);
+				return 1;
+			}
+		} else {
+			*out = t[10];
+			g_object_ref(*out);
+		}
+	}
+
+	g_object_unref(base);
+	return 0;
+}
+
+int
+vips_color_adjust_bridge(VipsImage *in, VipsImage **out, double lightness, double chroma, double hue) {
+	// Extra bands, such as alpha, are passed through unaltered
+	double a[4] = { 1, 1 + chroma, 1, 1 };
+	double b[4] = { lightness, 0, hue, 0 };
+
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 3);
+
+	if (vips_colourspace(in, &t[0], VIPS_INTERPRETATION_LCH, NULL)) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	if (t[0]->Bands > 4) {
+		vips_error("bimg", "Color adjustment supports up to 4 bands");
+		g_object_unref(base);
+		return 1;
+	}
+
+	if (
+		vips_linear(t[0], &t[1], a, b, t[0]->Bands, NULL) ||
+		vips_colourspace(t[1], &t[2], VIPS_INTERPRETATION_sRGB, NULL) ||
+		vips_cast(t[2], out, VIPS_FORMAT_UCHAR, NULL)
+	) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	g_object_unref(base);
+	return 0;
+}
+
+int
+vips_page_height_bridge(VipsImage *in) {
+	int page_height = 0;
+
+	if (
+		vips_image_get_typeof(in, VIPS_META_PAGE_HEIGHT) &&
+		!vips_image_get_int(in, VIPS_META_PAGE_HEIGHT, &page_height) &&
+		page_height > 0 &&
+		page_height <= in->Ysize &&
+		in->Ysize % page_height == 0
+	) {
+		return page_height;
+	}
+
+	return in->Ysize;
+}
+
+int
+vips_animation_info_bridge(VipsImage *in, int *frames, int *loop, int **delays, int *n_delays) {
+	*frames = 1;
+	*loop = 0;
+	*delays = NULL;
+	*n_delays = 0;
+
+	if (vips_image_get_typeof(in, "n-pages") && vips_image_get_int(in, "n-pages", frames)) {
+		return 1;
+	}
+
+	if (vips_image_get_typeof(in, "loop")) {
+		if (vips_image_get_int(in, "loop", loop)) {
+			return 1;
+		}
+	} else if (vips_image_get_typeof(in, "gif-loop") && vips_image_get_int(in, "gif-loop", loop)) {
+		return 1;
+	}
+
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 9))
+	if (vips_image_get_typeof(in, "delay") && vips_image_get_array_int(in, "delay", delays, n_delays)) {
+		return 1;
+	}
+#endif
+
+	return 0;
+}
+
+int
+vips_arrayjoin_bridge(VipsImage **in, VipsImage **out, int n) {
+	return vips_arrayjoin(in, out, n, "across", 1, NULL);
+}
+
+int
+vips_animation_set_bridge(VipsImage *in, VipsImage **out, int page_height, int loop, int *delays, int n_delays) {
+	if (vips_copy(in, out, NULL)) {
+		return 1;
+	}
+
+	vips_image_set_int(*out, VIPS_META_PAGE_HEIGHT, page_height);
+	vips_image_set_int(*out, "loop", loop);
+	vips_image_set_int(*out, "gif-loop", loop);
+
+	if (n_delays > 0) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 9))
+		vips_image_set_array_int(*out, "delay", delays, n_delays);
+#endif
+		// The legacy GIF delay is a single value in centiseconds
+		vips_image_set_int(*out, "gif-delay", (delays[0] + 5) / 10);
+	}
+
+	return 0;
+}
+
+int
+vips_getpoint_bridge(VipsImage *in, int x, int y, double *pixel) {
+	double *vector;
+	int i, n;
+
+	if (vips_getpoint(in, &vector, &n, x, y, NULL)) {
+		return 1;
+	}
+
+	// Grayscale images fill the three channels with the same value
+	for (i = 0; i < 3; i++) {
+		pixel[i] = vector[n >= 3 ? i : 0];
+	}
+
+	g_free(vector);
+	return 0;
+}
+
+int
+vips_find_trim_bridge(VipsImage *in, double *background, double threshold, int *left, int *top, int *width, int *height) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 6))
+	double ink[3] = { background[0], background[1], background[2] };
+	VipsArrayDouble *vipsBackground;
+	int code;
+
+	if (in->Bands < 3) {
+		ink[0] = (ink[0] + ink[1] + ink[2]) / 3;
+	}
+
+	vipsBackground = vips_array_double_new(ink, in->Bands < 3 ? 1 : 3);
+	code = vips_find_trim(in, left, top, width, height,
+		"background", vipsBackground,
+		"threshold", threshold,
+		NULL
+	);
+
+	vips_area_unref(VIPS_AREA(vipsBackground));
+	return code;
+#else
+	vips_error("bimg", "trim requires libvips 8.6+");
+	return 1;
+#endif
+}
@@ -1,10 +1,13 @@
 package bimg
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestVipsRead(t *testing.T) {
@@ -30,7 +33,7 @@ func TestVipsRead(t *testing.T) {
 
 func TestVipsSave(t *testing.T) {
 	image, _, _ := vipsRead(readImage("test.jpg"))
-	options := vipsSaveOptions{Quality: 95, Type: JPEG, Interlace: true}
+	options := vipsSaveOptions{Quality: 95, Type: JPEG, JPEGProgressive: true}
 
 	buf, err := vipsSave(image, options)
 	if err != nil {
@@ -41,6 +44,40 @@ func TestVipsSave(t *testing.T) {
 	}
 }
 
+func TestVipsSaveDefaultQuality(t *testing.T) {
+	image, _, _ := vipsRead(readImage("test.jpg"))
+	buf, err := vipsSave(image, vipsSaveOptions{Type: JPEG})
+	if err != nil {
+		t.Fatal("Cannot save the image")
+	}
+
+	image, _, _ = vipsRead(readImage("test.jpg"))
+	lowest, err := vipsSave(image, vipsSaveOptions{Type: JPEG, Quality: 1})
+	if err != nil {
+		t.Fatal("Cannot save the image")
+	}
+
+	if len(buf) <= len(lowest)*2 {
+		t.Fatalf("Default quality output looks like the lowest quality: %d <= %d", len(buf), len(lowest)*2)
+	}
+}
+
+func TestVipsSaveQuantTable(t *testing.T) {
+	image, _, _ := vipsRead(readImage("test.jpg"))
+	buf, err := vipsSave(image, vipsSaveOptions{Type: JPEG, QuantTable: 3})
+	if err != nil {
+		t.Fatalf("Cannot save the image: %#v", err)
+	}
+	if len(buf) == 0 {
+		t.Fatal("Empty image")
+	}
+
+	image, _, _ = vipsRead(readImage("test.jpg"))
+	if _, err := vipsSave(image, vipsSaveOptions{Type: JPEG, QuantTable: MaxQuantTable + 1}); err == nil {
+		t.Fatal("Expected error for invalid quantization table")
+	}
+}
+
 func TestVipsRotate(t *testing.T) {
 	image, _, _ := vipsRead(readImage("test.jpg"))
 
@@ -93,11 +130,53 @@ func TestVipsWatermark(t *testing.T) {
 	}
 }
 
+func TestVipsWatermarkFontFile(t *testing.T) {
+	image, _, _ := vipsRead(readImage("test.jpg"))
+
+	watermark := Watermark{
+		Text:       "Copy me if you can",
+		Font:       "sans bold 12",
+		FontFile:   "fixtures/test.jpg",
+		Width:      200,
+		DPI:        100,
+		Background: Color{255, 255, 255},
+	}
+
+	if _, err := vipsWatermark(image, watermark); err == nil {
+		t.Fatal("Expected error for an invalid font file")
+	}
+}
+
+func TestOperationHook(t *testing.T) {
+	durations := map[string]time.Duration{}
+	OperationHook = func(op string, d time.Duration) {
+		durations[op] += d
+	}
+	defer func() { OperationHook = nil }()
+
+	_, err := Resize(readImage("test.jpg"), Options{Width: 300, Height: 200})
+	if err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+
+	for _, op := range []string{"read", "save"} {
+		if _, ok := durations[op]; !ok {
+			t.Errorf("Missing %s operation timing", op)
+		}
+	}
+}
+
 func TestVipsImageType(t *testing.T) {
 	imgType := vipsImageType(readImage("test.jpg"))
 	if imgType != JPEG {
 		t.Fatal("Invalid image type")
 	}
+
+	for _, buf := range [][]byte{nil, []byte("ab"), {0xFF, 0xD8, 0xFF}} {
+		if imgType := vipsImageType(buf); imgType != UNKNOWN {
+			t.Errorf("Invalid image type for a short buffer %v: %s", buf, ImageTypeName(imgType))
+		}
+	}
 }
 
 func TestVipsMemory(t *testing.T) {
@@ -111,6 +190,152 @@ func TestVipsMemory(t *testing.T) {
 	}
 }
 
+func TestVipsErrorBuffer(t *testing.T) {
+	VipsClearError()
+
+	// The failed operations consume the error buffer into the returned error
+	image, _, _ := vipsRead(readImage("test.jpg"))
+	_, err := vipsExtract(image, 0, 0, 5000, 5000)
+	if err == nil || !strings.Contains(err.Error(), "extract_area") {
+		t.Fatalf("Expected the libvips error message: %#v", err)
+	}
+	if buffer := VipsErrorBuffer(); buffer != "" {
+		t.Fatalf("Expected an empty error buffer: %s", buffer)
+	}
+
+	VipsClearError()
+	if buffer := VipsErrorBuffer(); buffer != "" {
+		t.Fatalf("Expected an empty error buffer: %s", buffer)
+	}
+}
+
+func TestVipsCacheStats(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+	if _, err := Resize(buf, Options{Width: 100}); err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+
+	stats := VipsCacheStats()
+
+	if stats.Max != maxCacheSize {
+		t.Fatalf("Invalid cache max size: %d", stats.Max)
+	}
+	if stats.MaxMem != maxCacheMem {
+		t.Fatalf("Invalid cache max memory: %d", stats.MaxMem)
+	}
+	if stats.Size < 0 || stats.Size > stats.Max {
+		t.Fatalf("Invalid cache size: %d", stats.Size)
+	}
+}
+
+func TestVipsSaveRestartInterval(t *testing.T) {
+	image, _, _ := vipsRead(readImage("test.jpg"))
+	buf, err := vipsSave(image, vipsSaveOptions{Type: JPEG, RestartInterval: 2})
+
+	if !vipsVersionAtLeast(8, 13) {
+		if err == nil {
+			t.Fatal("Expected error for unsupported restart interval")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Cannot save the image: %#v", err)
+	}
+
+	// Define restart interval (DRI) marker, with the interval in MCUs
+	if !bytes.Contains(buf, []byte{0xFF, 0xDD, 0x00, 0x04, 0x00, 0x02}) {
+		t.Fatal("Missing restart interval marker")
+	}
+
+	image, _, _ = vipsRead(readImage("test.jpg"))
+	if _, err := vipsSave(image, vipsSaveOptions{Type: JPEG, RestartInterval: -1}); err == nil {
+		t.Fatal("Expected error for negative restart interval")
+	}
+}
+
+func TestVipsDisableCache(t *testing.T) {
+	max := VipsCacheStats().Max
+
+	restore := vipsDisableCache()
+	restoreNested := vipsDisableCache()
+	if stats := VipsCacheStats(); stats.Max != 0 || stats.Size != 0 {
+		t.Fatalf("Expected a disabled cache: %#v", stats)
+	}
+
+	restoreNested()
+	if stats := VipsCacheStats(); stats.Max != 0 {
+		t.Fatalf("Expected a disabled cache: %#v", stats)
+	}
+
+	restore()
+	if stats := VipsCacheStats(); stats.Max != max {
+		t.Fatalf("Invalid restored cache max size: %d", stats.Max)
+	}
+
+	buf, _ := Read("fixtures/test.jpg")
+	if _, err := Resize(buf, Options{Width: 100, DisableCache: true}); err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+	if stats := VipsCacheStats(); stats.Max != max {
+		t.Fatalf("Invalid restored cache max size: %d", stats.Max)
+	}
+}
+
+func TestInitializeWithConfig(t *testing.T) {
+	InitializeWithConfig(Config{MaxCacheSize: 100, MaxCacheFiles: 10})
+	defer InitializeWithConfig(Config{Concurrency: 1, MaxCacheFiles: 100})
+
+	stats := VipsCacheStats()
+	if stats.Max != 100 || stats.MaxFiles != 10 {
+		t.Fatalf("Invalid cache settings: %#v", stats)
+	}
+
+	buf, _ := Read("fixtures/test.jpg")
+	if _, err := Resize(buf, Options{Width: 100}); err != nil {
+		t.Fatalf("Cannot process the image: %#v", err)
+	}
+}
+
+func TestShutdownInitializeProcess(t *testing.T) {
+	buf, _ := Read("fixtures/test.jpg")
+
+	// libvips is never actually shut down, since it cannot be restarted
+	Shutdown()
+	Shutdown()
+	if _, err := Resize(buf, Options{Width: 100}); err != nil {
+		t.Fatalf("Cannot process the image after shutdown: %#v", err)
+	}
+
+	Initialize()
+	if _, err := NewImage(buf).Process(Options{Width: 100}); err != nil {
+		t.Fatalf("Cannot process the image after initialize: %#v", err)
+	}
+}
+
+func TestVipsInfo(t *testing.T) {
+	info := VipsInfo()
+
+	if info.Version != VipsVersion {
+		t.Fatalf("Invalid version: %s", info.Version)
+	}
+	if info.Major < 7 {
+		t.Fatalf("Invalid major version: %d", info.Major)
+	}
+	if len(info.Load) == 0 || len(info.Save) == 0 {
+		t.Fatal("Invalid supported image types")
+	}
+
+	found := false
+	for _, name := range info.Save {
+		if name == "jpeg" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("JPEG save support not reported")
+	}
+}
+
 func readImage(file string) []byte {
 	img, _ := os.Open(path.Join("fixtures", file))
 	buf, _ := ioutil.ReadAll(img)